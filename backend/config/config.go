@@ -2,13 +2,18 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"os"
+	"strconv"
 
 	"github.com/joho/godotenv"
 	"github.com/rs/zerolog/log"
 )
 
 type Config struct {
+	// DBDriver picks the database: "postgres" (default) or "sqlite"
+	DBDriver     string `env:"DB_DRIVER"`
+	DBPath       string `env:"DB_PATH"`
 	DBName       string `env:"DB_NAME"`
 	Host         string `env:"DB_HOST"`
 	Password     string `env:"DB_PASSWORD"`
@@ -19,8 +24,30 @@ type Config struct {
 	S3SecretKey  string `env:"AWS_SECRET_KEY"`
 	S3BucketName string `env:"S3_BUCKET_NAME"`
 	Environment  string `env:"ENVIRONMENT"`
+	// StorageDriver picks the storage backend: "s3" (default) or "local"
+	StorageDriver    string `env:"STORAGE_DRIVER"`
+	LocalStoragePath string `env:"LOCAL_STORAGE_PATH"`
+	// how long deleted files stay in the trash before they are purged
+	TrashRetentionDays int `env:"TRASH_RETENTION_DAYS"`
+	// signs the login tokens and the tokens of share links
+	JWTSecret string `env:"JWT_SECRET"`
 }
 
+const (
+	DBDriverPostgres = "postgres"
+	DBDriverSQLite   = "sqlite"
+)
+
+const (
+	StorageDriverS3    = "s3"
+	StorageDriverLocal = "local"
+)
+
+const defaultTrashRetentionDays = 30
+
+// a shorter JWT secret can be guessed
+const minJWTSecretLength = 32
+
 // Load the config from the environment variables
 func LoadConfig() (*Config, error) {
 	config := Config{}
@@ -33,6 +60,8 @@ func LoadConfig() (*Config, error) {
 
 // Load the config from a file
 func LoadFromEnv(config *Config) error {
+	config.DBDriver = os.Getenv("DB_DRIVER")
+	config.DBPath = os.Getenv("DB_PATH")
 	config.DBName = os.Getenv("DB_NAME")
 	config.Host = os.Getenv("DB_HOST")
 	config.Password = os.Getenv("DB_PASSWORD")
@@ -43,6 +72,17 @@ func LoadFromEnv(config *Config) error {
 	config.S3SecretKey = os.Getenv("AWS_SECRET_KEY")
 	config.S3BucketName = os.Getenv("S3_BUCKET_NAME")
 	config.Environment = os.Getenv("ENVIRONMENT")
+	config.StorageDriver = os.Getenv("STORAGE_DRIVER")
+	config.LocalStoragePath = os.Getenv("LOCAL_STORAGE_PATH")
+	config.JWTSecret = os.Getenv("JWT_SECRET")
+	config.TrashRetentionDays = defaultTrashRetentionDays
+	if retention := os.Getenv("TRASH_RETENTION_DAYS"); retention != "" {
+		days, err := strconv.Atoi(retention)
+		if err != nil {
+			return errors.New("TRASH_RETENTION_DAYS must be a number of days")
+		}
+		config.TrashRetentionDays = days
+	}
 
 	return ValidateConfig(config)
 }
@@ -59,35 +99,68 @@ func LoadFromFile(config *Config) error {
 
 // Validate the config
 func ValidateConfig(config *Config) error {
-	if config.DBName == "" {
-		return errors.New("DB_NAME is not set")
+	if config.DBDriver == "" {
+		config.DBDriver = DBDriverPostgres
 	}
-	if config.Host == "" {
-		return errors.New("DB_HOST is not set")
+	switch config.DBDriver {
+	case DBDriverPostgres:
+		if config.DBName == "" {
+			return errors.New("DB_NAME is not set")
+		}
+		if config.Host == "" {
+			return errors.New("DB_HOST is not set")
+		}
+		if config.Password == "" {
+			return errors.New("DB_PASSWORD is not set")
+		}
+		if config.Port == "" {
+			return errors.New("DB_PORT is not set")
+		}
+		if config.User == "" {
+			return errors.New("DB_USER is not set")
+		}
+	case DBDriverSQLite:
+		if config.DBPath == "" {
+			return errors.New("DB_PATH is not set")
+		}
+	default:
+		return errors.New("DB_DRIVER must be either postgres or sqlite")
 	}
-	if config.Password == "" {
-		return errors.New("DB_PASSWORD is not set")
+	if config.StorageDriver == "" {
+		config.StorageDriver = StorageDriverS3
 	}
-	if config.Port == "" {
-		return errors.New("DB_PORT is not set")
+	switch config.StorageDriver {
+	case StorageDriverS3:
+		if config.S3Region == "" {
+			return errors.New("S3_REGION is not set")
+		}
+		if config.S3AccesKeyID == "" {
+			return errors.New("AWS_ACCESS_KEY_ID is not set")
+		}
+		if config.S3SecretKey == "" {
+			return errors.New("AWS_SECRET_KEY is not set")
+		}
+		if config.S3BucketName == "" {
+			return errors.New("S3_BUCKET_NAME is not set")
+		}
+	case StorageDriverLocal:
+		if config.LocalStoragePath == "" {
+			return errors.New("LOCAL_STORAGE_PATH is not set")
+		}
+	default:
+		return errors.New("STORAGE_DRIVER must be either s3 or local")
 	}
-	if config.User == "" {
-		return errors.New("DB_USER is not set")
-	}
-	if config.S3Region == "" {
-		return errors.New("S3_REGION is not set")
-	}
-	if config.S3AccesKeyID == "" {
-		return errors.New("AWS_ACCESS_KEY_ID is not set")
+	if config.Environment == "" {
+		return errors.New("ENVIRONMENT is not set")
 	}
-	if config.S3SecretKey == "" {
-		return errors.New("AWS_SECRET_KEY is not set")
+	if config.TrashRetentionDays < 0 {
+		return errors.New("TRASH_RETENTION_DAYS cannot be negative")
 	}
-	if config.S3BucketName == "" {
-		return errors.New("S3_BUCKET_NAME is not set")
+	if config.JWTSecret == "" {
+		return errors.New("JWT_SECRET is not set")
 	}
-	if config.Environment == "" {
-		return errors.New("ENVIRONMENT is not set")
+	if len(config.JWTSecret) < minJWTSecretLength {
+		return fmt.Errorf("JWT_SECRET must be at least %d characters", minJWTSecretLength)
 	}
 
 	return nil
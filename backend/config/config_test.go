@@ -0,0 +1,31 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func validConfig() Config {
+	return Config{
+		DBDriver:         DBDriverSQLite,
+		DBPath:           "cascloud.db",
+		StorageDriver:    StorageDriverLocal,
+		LocalStoragePath: "data",
+		Environment:      "test",
+		JWTSecret:        strings.Repeat("s", minJWTSecretLength),
+	}
+}
+
+func TestValidateConfigNeedsJWTSecret(t *testing.T) {
+	config := validConfig()
+	if err := ValidateConfig(&config); err != nil {
+		t.Fatalf("a valid config gave %v", err)
+	}
+	for _, value := range []string{"", "victoria"} {
+		config := validConfig()
+		config.JWTSecret = value
+		if err := ValidateConfig(&config); err == nil || !strings.Contains(err.Error(), "JWT_SECRET") {
+			t.Errorf("JWT_SECRET %q gave %v", value, err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	model "cascloud/models"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+func (c *DBClient) CreateCanvasItem(item *model.CanvasItem) error {
+	log.Info().Str("kind", item.Kind).Msg("Creating canvas item")
+	return c.gorm.Create(item).Error
+}
+
+func (c *DBClient) GetCanvasItemByID(id string) (*model.CanvasItem, error) {
+	var item model.CanvasItem
+	err := c.gorm.Where("id = ?", id).First(&item).Error
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// a function to get the notes, links and frames on the canvas of a folder
+func (c *DBClient) GetCanvasItemsInFolder(folderID string) ([]model.CanvasItem, error) {
+	var items []model.CanvasItem
+	err := c.gorm.Where("folder_id = ?", folderID).Order("created_at").Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// a function to get the canvas items of a folder that overlap a viewport,
+// unlike files and folders they have a size of their own
+func (c *DBClient) GetCanvasItemsInViewport(folderID string, viewport model.Viewport) ([]model.CanvasItem, error) {
+	var items []model.CanvasItem
+	err := c.gorm.Where(viewportQueries[c.gorm.Dialector.Name()].canvasItems, viewportArgs(folderID, viewport)).
+		Order("created_at").Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// a function to save every editable field of a canvas item, its connectors
+// are removed when it moved to another folder
+func (c *DBClient) UpdateCanvasItem(item *model.CanvasItem) error {
+	return c.gorm.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(item).Updates(map[string]interface{}{
+			"FolderID": item.FolderID,
+			"X":        item.X,
+			"Y":        item.Y,
+			"Width":    item.Width,
+			"Height":   item.Height,
+			"Title":    item.Title,
+			"Text":     item.Text,
+			"Format":   item.Format,
+			"URL":      item.URL,
+			"Color":    item.Color,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return detachConnectors(tx, item.ID, item.FolderID)
+	})
+}
+
+// a function to remove a canvas item together with its connectors
+func (c *DBClient) DeleteCanvasItem(item *model.CanvasItem) error {
+	log.Info().Msg("Deleting canvas item")
+	return c.gorm.Transaction(func(tx *gorm.DB) error {
+		if err := detachConnectors(tx, item.ID, uuid.Nil); err != nil {
+			return err
+		}
+		return tx.Delete(item).Error
+	})
+}
@@ -0,0 +1,72 @@
+package db
+
+import (
+	model "cascloud/models"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+func (c *DBClient) CreateConnector(connector *model.Connector) error {
+	log.Info().Msg("Creating connector")
+	return c.gorm.Create(connector).Error
+}
+
+func (c *DBClient) GetConnectorByID(id string) (*model.Connector, error) {
+	var connector model.Connector
+	err := c.gorm.Where("id = ?", id).First(&connector).Error
+	if err != nil {
+		return nil, err
+	}
+	return &connector, nil
+}
+
+// a function to get the connectors on the canvas of a folder
+func (c *DBClient) GetConnectorsInFolder(folderID string) ([]model.Connector, error) {
+	var connectors []model.Connector
+	err := c.gorm.Where("folder_id = ?", folderID).Order("created_at").Find(&connectors).Error
+	if err != nil {
+		return nil, err
+	}
+	return connectors, nil
+}
+
+// a function to get the connectors of a folder that have at least one end at
+// one of the given items
+func (c *DBClient) GetConnectorsOfItems(folderID string, itemIDs []string) ([]model.Connector, error) {
+	var connectors []model.Connector
+	if len(itemIDs) == 0 {
+		return connectors, nil
+	}
+	err := c.gorm.Where("folder_id = ? AND (from_id IN ? OR to_id IN ?)", folderID, itemIDs, itemIDs).
+		Order("created_at").Find(&connectors).Error
+	if err != nil {
+		return nil, err
+	}
+	return connectors, nil
+}
+
+// a function to save the label, style and direction of a connector
+func (c *DBClient) UpdateConnector(connector *model.Connector) error {
+	return c.gorm.Model(connector).Updates(map[string]interface{}{
+		"Label":     connector.Label,
+		"Style":     connector.Style,
+		"Direction": connector.Direction,
+	}).Error
+}
+
+func (c *DBClient) DeleteConnector(connector *model.Connector) error {
+	log.Info().Msg("Deleting connector")
+	return c.gorm.Delete(connector).Error
+}
+
+// detachConnectors removes the connectors of an item that are not on the
+// canvas of folderID, with uuid.Nil every connector of the item goes
+func detachConnectors(tx *gorm.DB, itemID uuid.UUID, folderID uuid.UUID) error {
+	query := tx.Where("(from_id = ? OR to_id = ?)", itemID, itemID)
+	if folderID != uuid.Nil {
+		query = query.Where("folder_id <> ?", folderID)
+	}
+	return query.Delete(&model.Connector{}).Error
+}
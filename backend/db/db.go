@@ -2,42 +2,119 @@ package db
 
 import (
 	"fmt"
+	"reflect"
+	"strings"
 
 	"cascloud/config"
-	model "cascloud/models"
+	"cascloud/migrations"
 
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
 )
 
-func DB(cfg *config.Config) (*gorm.DB, error) {
-	info := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName)
-
-	db, err := gorm.Open(postgres.Open(info), &gorm.Config{})
-
-	if cfg.Environment == "dev" {
-		return db, nil
+// a function to connect to the database without touching the schema
+func Open(cfg *config.Config) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	switch cfg.DBDriver {
+	case config.DBDriverSQLite:
+		// foreign keys are off in SQLite unless every connection turns them on
+		separator := "?"
+		if strings.Contains(cfg.DBPath, "?") {
+			separator = "&"
+		}
+		dialector = sqlite.Open(cfg.DBPath + separator + "_pragma=foreign_keys(1)")
+	default:
+		info := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+			cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName)
+		dialector = postgres.Open(info)
 	}
+
+	// constraint violations come back as gorm.ErrDuplicatedKey and
+	// gorm.ErrForeignKeyViolated on every database
+	db, err := gorm.Open(dialector, &gorm.Config{TranslateError: true})
 	if err != nil {
 		log.Error().Err(err).Msg("Error connecting to database")
 		return nil, err
 	}
+	if cfg.DBDriver == config.DBDriverSQLite {
+		// SQLite takes one writer at a time
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(1)
+	}
+	if err := RegisterCallbacks(db); err != nil {
+		log.Error().Err(err).Msg("Error registering database callbacks")
+		return nil, err
+	}
+	return db, nil
+}
 
-	// we need to do auto migration for our models
-	migrateErr := db.AutoMigrate(
-		&model.User{},
-		&model.Workspace{},
-		&model.Collaborations{},
-		&model.Role{},
-		&model.File{},
-		&model.Folder{},
-	)
+// a function to connect to the database and bring its schema up to date
+func DB(cfg *config.Config) (*gorm.DB, error) {
+	db, err := Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		log.Error().Err(err).Msg("Error loading migrations")
+		return nil, err
+	}
+	applied, migrateErr := migrator.Up()
 	if migrateErr != nil {
-		log.Error().Err(migrateErr).Msg("Error migrating models")
+		log.Error().Err(migrateErr).Msg("Error migrating database")
 		return nil, migrateErr
 	}
+	log.Info().Int("applied", applied).Msg("Database schema is up to date")
+
+	seedErr := SeedRoles(db)
+	if seedErr != nil {
+		log.Error().Err(seedErr).Msg("Error seeding roles")
+		return nil, seedErr
+	}
 
 	return db, nil
 }
+
+// a function to add what the models need from every database, ids are made
+// here instead of by the database because SQLite can not generate uuids
+func RegisterCallbacks(db *gorm.DB) error {
+	return db.Callback().Create().Before("gorm:create").Register("cascloud:assign_ids", assignIDs)
+}
+
+var uuidType = reflect.TypeOf(uuid.UUID{})
+
+// assignIDs gives every row that is created without an id a new uuid
+func assignIDs(tx *gorm.DB) {
+	if tx.Statement.Schema == nil {
+		return
+	}
+	field := tx.Statement.Schema.PrioritizedPrimaryField
+	if field == nil || field.FieldType != uuidType {
+		return
+	}
+	value := tx.Statement.ReflectValue
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			assignID(tx, field, reflect.Indirect(value.Index(i)))
+		}
+	case reflect.Struct:
+		assignID(tx, field, value)
+	}
+}
+
+func assignID(tx *gorm.DB, field *schema.Field, row reflect.Value) {
+	if _, zero := field.ValueOf(tx.Statement.Context, row); zero {
+		if err := field.Set(tx.Statement.Context, row, uuid.New()); err != nil {
+			tx.AddError(err)
+		}
+	}
+}
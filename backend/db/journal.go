@@ -0,0 +1,108 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	model "cascloud/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// returned when a layout step was undone or redone by someone else meanwhile
+var ErrLayoutStepChanged = errors.New("layout step was changed concurrently")
+
+// a function to get the layout journal of a folder, the newest steps first
+func (c *DBClient) GetLayoutSteps(folderID string, limit int) ([]model.LayoutStep, error) {
+	var steps []model.LayoutStep
+	err := c.gorm.Preload("Changes").Where("folder_id = ?", folderID).
+		Order("created_at DESC").Limit(limit).Find(&steps).Error
+	if err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+// a function to get the step an undo or redo in a folder applies to: the
+// newest step that is not undone, or with undone the oldest step that is. It
+// returns nil when there is none.
+func (c *DBClient) GetNextLayoutStep(folderID string, undone bool) (*model.LayoutStep, error) {
+	var steps []model.LayoutStep
+	order := "created_at DESC"
+	if undone {
+		order = "created_at ASC"
+	}
+	err := c.gorm.Preload("Changes").Where("folder_id = ? AND undone = ?", folderID, undone).
+		Order(order).Limit(1).Find(&steps).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(steps) == 0 {
+		return nil, nil
+	}
+	return &steps[0], nil
+}
+
+// a function to get every change made after since to the items that were on
+// the canvas of a folder or came onto it, the oldest first. Undone steps are
+// left out since their changes were reverted.
+func (c *DBClient) GetLayoutChangesSince(folderID string, since time.Time) ([]model.LayoutChange, error) {
+	var changes []model.LayoutChange
+	touched := c.gorm.Model(&model.LayoutChange{}).Select("layout_changes.item_id").
+		Joins("JOIN layout_steps ON layout_steps.id = layout_changes.step_id").
+		Where("layout_steps.undone = ? AND layout_steps.created_at > ?", false, since).
+		Where("layout_changes.from_folder_id = ? OR layout_changes.to_folder_id = ?", folderID, folderID)
+	err := c.gorm.Select("layout_changes.*").
+		Joins("JOIN layout_steps ON layout_steps.id = layout_changes.step_id").
+		Where("layout_steps.undone = ? AND layout_steps.created_at > ?", false, since).
+		Where("layout_changes.item_id IN (?)", touched).
+		Order("layout_steps.created_at ASC").Find(&changes).Error
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// saveLayoutSteps records new layout steps with their changes, a new step drops
+// the undone steps of its folder since they can not be redone after it. Steps
+// that already exist only have their Undone flag saved, and only if nobody
+// flipped it in the meantime.
+func saveLayoutSteps(tx *gorm.DB, steps []model.LayoutStep) error {
+	for i := range steps {
+		step := &steps[i]
+		if step.ID != uuid.Nil {
+			result := tx.Model(&model.LayoutStep{}).
+				Where("id = ? AND undone = ?", step.ID, !step.Undone).
+				Update("undone", step.Undone)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return ErrLayoutStepChanged
+			}
+			continue
+		}
+
+		undone := tx.Model(&model.LayoutStep{}).Select("id").
+			Where("folder_id = ? AND undone = ?", step.FolderID, true)
+		err := tx.Where("step_id IN (?)", undone).Delete(&model.LayoutChange{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("folder_id = ? AND undone = ?", step.FolderID, true).Delete(&model.LayoutStep{}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Omit("Changes").Create(step).Error; err != nil {
+			return err
+		}
+		for j := range step.Changes {
+			step.Changes[j].StepID = step.ID
+			if err := tx.Create(&step.Changes[j]).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package db
+
+import (
+	model "cascloud/models"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// a function to get the folders with the given ids, missing ones are left out
+func (c *DBClient) GetFoldersByIDs(ids []string) ([]model.Folder, error) {
+	var folders []model.Folder
+	if len(ids) == 0 {
+		return folders, nil
+	}
+	err := c.gorm.Where("id IN ?", ids).Find(&folders).Error
+	if err != nil {
+		return nil, err
+	}
+	return folders, nil
+}
+
+// a function to get the files with the given ids, missing ones are left out
+func (c *DBClient) GetFilesByIDs(ids []string) ([]model.File, error) {
+	var files []model.File
+	if len(ids) == 0 {
+		return files, nil
+	}
+	err := c.gorm.Where("id IN ?", ids).Find(&files).Error
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// viewportQueries are the conditions of the viewport queries. On Postgres they
+// have the shape of the GiST indexes on the positions, SQLite has no GiST and
+// uses the B-tree position indexes on (folder, x, y).
+var viewportQueries = map[string]struct{ folders, files, canvasItems string }{
+	"postgres": {
+		folders:     "parent_id = @folder AND point(x, y) <@ box(point(@min_x, @min_y), point(@max_x, @max_y))",
+		files:       "folder_id = @folder AND point(x, y) <@ box(point(@min_x, @min_y), point(@max_x, @max_y))",
+		canvasItems: "folder_id = @folder AND box(point(x, y), point(x + width, y + height)) && box(point(@min_x, @min_y), point(@max_x, @max_y))",
+	},
+	"sqlite": {
+		folders:     "parent_id = @folder AND x BETWEEN @min_x AND @max_x AND y BETWEEN @min_y AND @max_y",
+		files:       "folder_id = @folder AND x BETWEEN @min_x AND @max_x AND y BETWEEN @min_y AND @max_y",
+		canvasItems: "folder_id = @folder AND x <= @max_x AND x + width >= @min_x AND y <= @max_y AND y + height >= @min_y",
+	},
+}
+
+// viewportArgs are the named arguments of the viewport queries
+func viewportArgs(folderID string, viewport model.Viewport) map[string]interface{} {
+	return map[string]interface{}{
+		"folder": folderID,
+		"min_x":  viewport.MinX,
+		"min_y":  viewport.MinY,
+		"max_x":  viewport.MaxX,
+		"max_y":  viewport.MaxY,
+	}
+}
+
+// a function to get the folders and files of a folder whose position lies in
+// a viewport, see viewportQueries for the indexes it uses
+func (c *DBClient) GetFoldersAndFilesInViewport(folderID string, viewport model.Viewport) ([]model.Folder, []model.File, error) {
+	var folders []model.Folder
+	var files []model.File
+	queries := viewportQueries[c.gorm.Dialector.Name()]
+	args := viewportArgs(folderID, viewport)
+	err := c.gorm.Where(queries.folders, args).Find(&folders).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	err = c.gorm.Where(queries.files, args).Find(&files).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	return folders, files, nil
+}
+
+// a function to save where folders and files sit on the canvas together with
+// their names, paths and parents, and the layout steps that record it, in a
+// single transaction. Connectors of items that left their folder are removed.
+// A moved or renamed folder must come with every folder and file below it, it
+// fails with ErrTreeChanged if the tree changed since it was read.
+func (c *DBClient) UpdateLayout(folders []model.Folder, files []model.File, steps []model.LayoutStep) error {
+	log.Info().Int("folders", len(folders)).Int("files", len(files)).Msg("Updating layout")
+	return nameErr(c.gorm.Transaction(func(tx *gorm.DB) error {
+		if err := lockFolders(tx, folders, files); err != nil {
+			return err
+		}
+		for _, folder := range folders {
+			err := tx.Model(&model.Folder{ID: folder.ID}).Updates(map[string]interface{}{
+				"Name":        folder.Name,
+				"Path":        folder.Path,
+				"ParentID":    folder.ParentID,
+				"WorkspaceID": folder.WorkspaceID,
+				"X":           folder.X,
+				"Y":           folder.Y,
+			}).Error
+			if err != nil {
+				return err
+			}
+			if err := detachConnectors(tx, folder.ID, folder.ParentID); err != nil {
+				return err
+			}
+		}
+		for _, file := range files {
+			err := tx.Model(&model.File{ID: file.ID}).Updates(map[string]interface{}{
+				"Name":     file.Name,
+				"Path":     file.Path,
+				"FolderID": file.FolderID,
+				"X":        file.X,
+				"Y":        file.Y,
+			}).Error
+			if err != nil {
+				return err
+			}
+			if err := detachConnectors(tx, file.ID, file.FolderID); err != nil {
+				return err
+			}
+		}
+		if err := checkTree(tx, folders, files); err != nil {
+			return err
+		}
+		if err := checkNames(tx, folders, files); err != nil {
+			return err
+		}
+		return saveLayoutSteps(tx, steps)
+	}))
+}
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"errors"
+
+	model "cascloud/models"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+var (
+	// returned when a user that already collaborates on a workspace joins it again
+	ErrAlreadyMember = errors.New("user is already a member of the workspace")
+	// returned when an invitation was answered or withdrawn in the meantime
+	ErrInvitationNotPending = errors.New("invitation is no longer pending")
+	// returned when a membership that is changed or removed does not exist
+	ErrNotMember = errors.New("user is not a member of the workspace")
+)
+
+func (c *DBClient) CreateInvitation(invitation *model.Invitation) error {
+	log.Info().Msg("Creating invitation")
+	return c.gorm.Create(invitation).Error
+}
+
+func (c *DBClient) GetInvitationByID(id string) (*model.Invitation, error) {
+	var invitation model.Invitation
+	err := c.gorm.Where("id = ?", id).First(&invitation).Error
+	if err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+// a function to get the pending invitations of a workspace, or of an email
+// when workspaceID is empty
+func (c *DBClient) GetPendingInvitations(workspaceID string, email string) ([]model.Invitation, error) {
+	var invitations []model.Invitation
+	query := c.gorm.Where("status = ?", model.InvitationPending)
+	if workspaceID != "" {
+		query = query.Where("workspace_id = ?", workspaceID)
+	}
+	if email != "" {
+		query = query.Where("LOWER(email) = LOWER(?)", email)
+	}
+	err := query.Order("created_at DESC").Find(&invitations).Error
+	if err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+// a function to accept an invitation, the user gets a collaboration with the
+// invited role which is what makes them a member of the workspace
+func (c *DBClient) AcceptInvitation(invitation *model.Invitation, user *model.User, role *model.Role) error {
+	log.Info().Msg("Accepting invitation")
+	return c.gorm.Transaction(func(tx *gorm.DB) error {
+		if err := answerInvitation(tx, invitation, model.InvitationAccepted); err != nil {
+			return err
+		}
+		var count int64
+		err := tx.Model(&model.Collaborations{}).
+			Where("user_id = ? AND workspace_id = ?", user.ID, invitation.WorkspaceID).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrAlreadyMember
+		}
+		// the unique index catches a membership added since the count
+		err = tx.Create(&model.Collaborations{
+			UserID:      user.ID,
+			RoleID:      role.ID,
+			WorkspaceID: invitation.WorkspaceID,
+		}).Error
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return ErrAlreadyMember
+		}
+		if err != nil {
+			return err
+		}
+		user.Workspaces = append(user.Workspaces, invitation.WorkspaceID)
+		return nil
+	})
+}
+
+func (c *DBClient) DeclineInvitation(invitation *model.Invitation) error {
+	log.Info().Msg("Declining invitation")
+	return answerInvitation(c.gorm, invitation, model.InvitationDeclined)
+}
+
+// answerInvitation only changes the status of an invitation that is still
+// pending, so an invitation can not be both accepted and declined
+func answerInvitation(tx *gorm.DB, invitation *model.Invitation, status string) error {
+	result := tx.Model(&model.Invitation{}).
+		Where("id = ? AND status = ?", invitation.ID, model.InvitationPending).
+		Update("status", status)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvitationNotPending
+	}
+	invitation.Status = status
+	return nil
+}
+
+// a function to get the members of a workspace with their roles
+func (c *DBClient) GetWorkspaceMembers(workspaceID string) ([]model.Member, error) {
+	var members []model.Member
+	err := c.gorm.Table("collaborations").
+		Select("users.id AS user_id, users.email, users.user_name, users.first_name, users.last_name, roles.name AS role, collaborations.created_at AS joined_at").
+		Joins("JOIN users ON users.id = collaborations.user_id").
+		Joins("JOIN roles ON roles.id = collaborations.role_id").
+		Where("collaborations.workspace_id = ?", workspaceID).
+		Order("collaborations.created_at").
+		Scan(&members).Error
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// a function to change the role of a member of a workspace
+func (c *DBClient) UpdateMemberRole(workspaceID string, userID string, role *model.Role) error {
+	log.Info().Msg("Updating member role")
+	result := c.gorm.Model(&model.Collaborations{}).
+		Where("workspace_id = ? AND user_id = ?", workspaceID, userID).
+		Update("role_id", role.ID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotMember
+	}
+	return nil
+}
+
+// a function to remove a member from a workspace by deleting their collaboration
+func (c *DBClient) RemoveMember(workspaceID string, userID string) error {
+	log.Info().Msg("Removing member")
+	result := c.gorm.Where("workspace_id = ? AND user_id = ?", workspaceID, userID).Delete(&model.Collaborations{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotMember
+	}
+	return nil
+}
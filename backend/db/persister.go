@@ -2,15 +2,37 @@ package db
 
 import (
 	"cascloud/models"
+	"time"
 )
 
 type DBInterface interface {
+	Transaction(fn func(tx DBInterface) error) error
 	CreateUser(user *models.User) error
 	UserExists(email string) bool
 	GetUserByEmail(email string) (*models.User, error)
 	GetUserByID(id string) (*models.User, error)
 	CreateWorkspace(workspace *models.Workspace, user *models.User) error
 	CreateCollaboration(collaboration *models.Collaborations) error
+	GetRoleByName(name string) (*models.Role, error)
+	GetWorkspaceRole(userID string, workspaceID string) (*models.Role, error)
+	CreateInvitation(invitation *models.Invitation) error
+	GetInvitationByID(id string) (*models.Invitation, error)
+	GetPendingInvitations(workspaceID string, email string) ([]models.Invitation, error)
+	AcceptInvitation(invitation *models.Invitation, user *models.User, role *models.Role) error
+	DeclineInvitation(invitation *models.Invitation) error
+	GetWorkspaceMembers(workspaceID string) ([]models.Member, error)
+	UpdateMemberRole(workspaceID string, userID string, role *models.Role) error
+	RemoveMember(workspaceID string, userID string) error
+	CreateShareLink(link *models.ShareLink) error
+	GetShareLinkByID(id string) (*models.ShareLink, error)
+	GetShareLinkByTokenHash(tokenHash string) (*models.ShareLink, error)
+	GetShareLinks(workspaceID string, itemID string) ([]models.ShareLink, error)
+	DeleteShareLink(link *models.ShareLink) error
+	CountShareDownload(link *models.ShareLink) error
+	StartShareDownload(link *models.ShareLink, download *models.ShareDownload) error
+	ReserveShareDownload(id string, linkID string, fileID string, length int64, now time.Time) error
+	ReleaseShareDownload(id string, length int64) error
+	DeleteExpiredShareDownloads(before time.Time) (int64, error)
 	CreateFolder(folder *models.Folder) error
 	CreateFile(file *models.File) error
 	EditFile(file *models.File) error
@@ -18,7 +40,54 @@ type DBInterface interface {
 	GetFolderPath(folder *models.Folder) (string, error)
 	GetFilesByFolderID(folderID string) ([]models.File, error)
 	GetFileByID(fileID string) (*models.File, error)
+	GetFileByName(folderID string, name string) (*models.File, error)
+	CreateFileWithVersion(file *models.File, version *models.FileVersion) error
+	AddFileVersion(file *models.File, version *models.FileVersion) error
+	GetFileVersions(fileID string) ([]models.FileVersion, error)
+	GetFileVersion(fileID string, version int) (*models.FileVersion, error)
+	GetVersionKeys(fileIDs []string) ([]string, error)
 	GetFoldersAndFilesInFolder(folderID string) ([]models.Folder, []models.File, error)
+	GetFoldersAndFilesInViewport(folderID string, viewport models.Viewport) ([]models.Folder, []models.File, error)
+	GetFolderTree(folderID string) ([]models.Folder, []models.File, error)
+	CreateFolderTree(folders []models.Folder, files []models.File) error
+	GetFoldersByIDs(ids []string) ([]models.Folder, error)
+	GetFilesByIDs(ids []string) ([]models.File, error)
+	UpdateLayout(folders []models.Folder, files []models.File, steps []models.LayoutStep) error
+	CreateCanvasItem(item *models.CanvasItem) error
+	GetCanvasItemByID(id string) (*models.CanvasItem, error)
+	GetCanvasItemsInFolder(folderID string) ([]models.CanvasItem, error)
+	GetCanvasItemsInViewport(folderID string, viewport models.Viewport) ([]models.CanvasItem, error)
+	UpdateCanvasItem(item *models.CanvasItem) error
+	DeleteCanvasItem(item *models.CanvasItem) error
+	CreateConnector(connector *models.Connector) error
+	GetConnectorByID(id string) (*models.Connector, error)
+	GetConnectorsInFolder(folderID string) ([]models.Connector, error)
+	GetConnectorsOfItems(folderID string, itemIDs []string) ([]models.Connector, error)
+	UpdateConnector(connector *models.Connector) error
+	DeleteConnector(connector *models.Connector) error
+	GetLayoutSteps(folderID string, limit int) ([]models.LayoutStep, error)
+	GetNextLayoutStep(folderID string, undone bool) (*models.LayoutStep, error)
+	GetLayoutChangesSince(folderID string, since time.Time) ([]models.LayoutChange, error)
+	TrashFile(file *models.File, item *models.TrashItem) error
+	TrashFolderTree(folders []models.Folder, files []models.File, item *models.TrashItem) error
+	GetTrashItems(workspaceID string) ([]models.TrashItem, error)
+	GetTrashItemByID(id string) (*models.TrashItem, error)
+	GetExpiredTrashItems(before time.Time) ([]models.TrashItem, error)
+	GetTrashedTree(item *models.TrashItem) ([]models.Folder, []models.File, error)
+	GetNestedTrashItems(item *models.TrashItem, folders []models.Folder) ([]models.TrashItem, error)
+	RestoreTrashItem(item *models.TrashItem, folders []models.Folder, files []models.File) error
+	PurgeTrashItem(item *models.TrashItem, folders []models.Folder, files []models.File) error
 	GetWorkspaceByID(id string) (*models.Workspace, error)
 	GetWorkspacesAvailableWorkspaces(userID string) (*[]models.Workspace, error)
+	CreateUploadSession(session *models.UploadSession) error
+	GetUploadSessionByID(id string) (*models.UploadSession, error)
+	ReserveUploadPart(session *models.UploadSession, offset int64, lease string, until time.Time, now time.Time) (int32, error)
+	ReleaseUploadPart(session *models.UploadSession, lease string) error
+	AddUploadPart(session *models.UploadSession, part *models.UploadPart) error
+	GetUploadParts(sessionID string) ([]models.UploadPart, error)
+	UpdateUploadSessionStatus(session *models.UploadSession, from string, to string) error
+	ClaimUploadCompletion(session *models.UploadSession, staleBefore time.Time) error
+	GetExpiredUploadSessions(before time.Time) ([]models.UploadSession, error)
+	ExpireUploadSession(session *models.UploadSession, before time.Time) error
+	DeleteUploadSession(session *models.UploadSession) error
 }
@@ -18,6 +18,16 @@ func NewClient(gormDB *gorm.DB) *DBClient {
 	return &DBClient{gorm: gormDB}
 }
 
+// a function to run several operations as one unit of work, fn gets a client
+// whose writes all happen in one transaction that is rolled back when fn
+// returns an error or panics. Operations that use a transaction of their own
+// run in a savepoint of it.
+func (c *DBClient) Transaction(fn func(tx DBInterface) error) error {
+	return c.gorm.Transaction(func(tx *gorm.DB) error {
+		return fn(NewClient(tx))
+	})
+}
+
 func (c *DBClient) CreateUser(user *model.User) error {
 	log.Info().Msg("Creating user")
 	bcryptPassword, err := bcrypt.GenerateFromPassword([]byte(user.PasswordHash), bcrypt.DefaultCost)
@@ -46,6 +56,9 @@ func (c *DBClient) GetUserByEmail(email string) (*model.User, error) {
 	if err != nil {
 		return nil, err
 	}
+	if user.Workspaces, err = c.userWorkspaceIDs(user.ID); err != nil {
+		return nil, err
+	}
 	return &user, nil
 }
 
@@ -56,11 +69,57 @@ func (c *DBClient) GetUserByID(id string) (*model.User, error) {
 	if err != nil {
 		return nil, err
 	}
+	if user.Workspaces, err = c.userWorkspaceIDs(user.ID); err != nil {
+		return nil, err
+	}
 	return &user, nil
 }
 
+// userWorkspaceIDs gets the workspaces a user collaborates on, oldest first
+func (c *DBClient) userWorkspaceIDs(userID uuid.UUID) ([]uuid.UUID, error) {
+	ids := []uuid.UUID{}
+	err := c.gorm.Model(&model.Collaborations{}).Where("user_id = ?", userID).
+		Order("created_at").Pluck("workspace_id", &ids).Error
+	return ids, err
+}
+
+// fillWorkspaceUsers sets the users of workspaces from their collaborations
+func (c *DBClient) fillWorkspaceUsers(workspaces []model.Workspace) error {
+	if len(workspaces) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, 0, len(workspaces))
+	for _, workspace := range workspaces {
+		ids = append(ids, workspace.ID)
+	}
+	var collaborations []model.Collaborations
+	err := c.gorm.Where("workspace_id IN ?", ids).Order("created_at").Find(&collaborations).Error
+	if err != nil {
+		return err
+	}
+	users := make(map[uuid.UUID][]uuid.UUID, len(workspaces))
+	for _, collaboration := range collaborations {
+		users[collaboration.WorkspaceID] = append(users[collaboration.WorkspaceID], collaboration.UserID)
+	}
+	for i := range workspaces {
+		workspaces[i].Users = users[workspaces[i].ID]
+		if workspaces[i].Users == nil {
+			workspaces[i].Users = []uuid.UUID{}
+		}
+	}
+	return nil
+}
+
+// a function to create a workspace with the home folder of its owner, every
+// write happens in one transaction so a failure leaves nothing behind
 func (c *DBClient) CreateWorkspace(workspace *model.Workspace, user *model.User) error {
 	log.Info().Msg("Creating workspace")
+	return c.gorm.Transaction(func(tx *gorm.DB) error {
+		return NewClient(tx).createWorkspace(workspace, user)
+	})
+}
+
+func (c *DBClient) createWorkspace(workspace *model.Workspace, user *model.User) error {
 	err := c.gorm.Create(workspace).Error
 	if err != nil {
 		return err
@@ -79,28 +138,30 @@ func (c *DBClient) CreateWorkspace(workspace *model.Workspace, user *model.User)
 	if err != nil {
 		return err
 	}
-	workspace.HomeFolderID = folderID
 	// update the workspace with the folder id
-	workspace.Users = append(workspace.Users, user.ID.String())
-	err = c.gorm.Model(workspace).Updates(map[string]interface{}{
-		"Users":        gorm.Expr("ARRAY_APPEND(users, ?)", user.ID),
-		"HomeFolderID": folderID,
-	}).Error
+	err = c.gorm.Model(workspace).Update("HomeFolderID", folderID).Error
 	if err != nil {
 		return err
 	}
 
-	// update the user with the workspace id
-	user.Workspaces = append(user.Workspaces, workspace.ID.String())
-	err = c.gorm.Model(user).Updates(map[string]interface{}{
-		"Workspaces": gorm.Expr("ARRAY_APPEND(workspaces, ?)", workspace.ID),
-	}).Error
+	// the creator owns the workspace
+	role, err := c.GetRoleByName(model.RoleOwner)
 	if err != nil {
 		return err
 	}
-
+	err = c.CreateCollaboration(&model.Collaborations{
+		UserID:      user.ID,
+		RoleID:      role.ID,
+		WorkspaceID: workspace.ID,
+	})
+	if err != nil {
+		return err
+	}
+	// the structs only change once everything is written
+	workspace.HomeFolderID = folderID
+	workspace.Users = append(workspace.Users, user.ID)
+	user.Workspaces = append(user.Workspaces, workspace.ID)
 	return nil
-
 }
 
 func (c *DBClient) GetWorkspaceByID(id string) (*model.Workspace, error) {
@@ -109,19 +170,23 @@ func (c *DBClient) GetWorkspaceByID(id string) (*model.Workspace, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &workspace, nil
+	workspaces := []model.Workspace{workspace}
+	if err := c.fillWorkspaceUsers(workspaces); err != nil {
+		return nil, err
+	}
+	return &workspaces[0], nil
 }
 
 func (c *DBClient) GetWorkspacesAvailableWorkspaces(userID string) (*[]model.Workspace, error) {
 	var workspaces []model.Workspace
-	// first get the user
-	user, err := c.GetUserByID(userID)
+	// the user collaborates on every workspace they can open
+	err := c.gorm.Joins("JOIN collaborations ON collaborations.workspace_id = workspaces.id").
+		Where("collaborations.user_id = ?", userID).
+		Order("collaborations.created_at").Find(&workspaces).Error
 	if err != nil {
 		return nil, err
 	}
-	// user.Workspaces is a slice of uuids
-	err = c.gorm.Where("id = ANY(?)", user.Workspaces).Find(&workspaces).Error
-	if err != nil {
+	if err := c.fillWorkspaceUsers(workspaces); err != nil {
 		return nil, err
 	}
 	return &workspaces, nil
@@ -131,22 +196,32 @@ func (c *DBClient) CreateCollaboration(collaboration *model.Collaborations) erro
 	return c.gorm.Create(collaboration).Error
 }
 
+// a function to create a folder, its parent is locked so it can not be
+// trashed or moved while the folder is added to it. It fails with
+// ErrNameTaken when the parent already holds an item with the name.
 func (c *DBClient) CreateFolder(folder *model.Folder) error {
-	if folder.ParentID != uuid.Nil {
-		// get the parent folder
-		parentFolder, err := c.GetFolderByID(folder.ParentID.String())
+	if folder.ParentID == uuid.Nil {
+		folder.Path = folder.Name
+		// a home folder has no parent, the column is left NULL
+		return c.gorm.Omit("ParentID").Create(folder).Error
+	}
+	return nameErr(c.gorm.Transaction(func(tx *gorm.DB) error {
+		parentFolder, err := lockLiveFolder(tx, folder.ParentID)
 		if err != nil {
 			return err
 		}
+		if err := checkNameFree(tx, folder.ParentID, folder.Name); err != nil {
+			return err
+		}
 		folder.Path = parentFolder.Path + "/" + folder.Name
-	} else {
-		folder.Path = folder.Name
-	}
-	return c.gorm.Create(folder).Error
+		return tx.Create(folder).Error
+	}))
 }
 
 func (c *DBClient) CreateFile(file *model.File) error {
-	files, err := c.GetFilesByFolderID(file.FolderID.String())
+	// an empty folder is fine here, unlike in GetFilesByFolderID
+	var files []model.File
+	err := c.gorm.Where("folder_id = ?", file.FolderID).Find(&files).Error
 	if err != nil {
 		return err
 	}
@@ -201,7 +276,6 @@ func (c *DBClient) GetFilesByFolderID(folderID string) ([]model.File, error) {
 	if len(files) == 0 {
 		return files, fmt.Errorf("no files found in folder")
 	}
-	fmt.Println(files)
 	return files, nil
 }
 
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"errors"
+
+	model "cascloud/models"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// a function to create the roles that do not exist yet
+func SeedRoles(db *gorm.DB) error {
+	log.Info().Msg("Seeding roles")
+	for _, name := range model.Roles {
+		role := model.Role{Name: name}
+		if err := db.Where("name = ?", name).FirstOrCreate(&role).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// a function to get a role by name, the role is created when it is missing
+// so databases that were never seeded still work
+func (c *DBClient) GetRoleByName(name string) (*model.Role, error) {
+	role := model.Role{Name: name}
+	err := c.gorm.Where("name = ?", name).FirstOrCreate(&role).Error
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// a function to get the role of a user in a workspace, it returns nil without
+// an error when the user does not collaborate on the workspace
+func (c *DBClient) GetWorkspaceRole(userID string, workspaceID string) (*model.Role, error) {
+	var role model.Role
+	err := c.gorm.
+		Joins("JOIN collaborations ON collaborations.role_id = roles.id").
+		Where("collaborations.user_id = ? AND collaborations.workspace_id = ?", userID, workspaceID).
+		First(&role).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	model "cascloud/models"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// returned when a share link has been downloaded from as often as allowed
+var ErrShareLimitReached = errors.New("share link download limit reached")
+
+// returned when a download of a shared file is gone or has no bytes left for a request
+var ErrShareDownloadUsed = errors.New("share download used up")
+
+// a function to create a share link, like users the password is given in
+// PasswordHash and hashed here
+func (c *DBClient) CreateShareLink(link *model.ShareLink) error {
+	log.Info().Msg("Creating share link")
+	if link.PasswordHash != "" {
+		bcryptPassword, err := bcrypt.GenerateFromPassword([]byte(link.PasswordHash), bcrypt.DefaultCost)
+		if err != nil {
+			log.Error().Err(err).Msg("Error hashing password")
+			return err
+		}
+		link.PasswordHash = string(bcryptPassword)
+		link.PasswordProtected = true
+	}
+	return c.gorm.Create(link).Error
+}
+
+func (c *DBClient) GetShareLinkByID(id string) (*model.ShareLink, error) {
+	var link model.ShareLink
+	err := c.gorm.Where("id = ?", id).First(&link).Error
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+func (c *DBClient) GetShareLinkByTokenHash(tokenHash string) (*model.ShareLink, error) {
+	var link model.ShareLink
+	err := c.gorm.Where("token_hash = ?", tokenHash).First(&link).Error
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// a function to get the share links of a workspace, only those of one item
+// when itemID is not empty
+func (c *DBClient) GetShareLinks(workspaceID string, itemID string) ([]model.ShareLink, error) {
+	var links []model.ShareLink
+	query := c.gorm.Where("workspace_id = ?", workspaceID)
+	if itemID != "" {
+		query = query.Where("item_id = ?", itemID)
+	}
+	err := query.Order("created_at DESC").Find(&links).Error
+	if err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+func (c *DBClient) DeleteShareLink(link *model.ShareLink) error {
+	log.Info().Msg("Deleting share link")
+	return c.gorm.Delete(link).Error
+}
+
+// a function to count a download of a share link, the count only goes up
+// while it is below the limit so concurrent downloads can not overshoot it
+func (c *DBClient) CountShareDownload(link *model.ShareLink) error {
+	result := c.gorm.Model(&model.ShareLink{}).
+		Where("id = ? AND (max_downloads IS NULL OR download_count < max_downloads)", link.ID).
+		Update("download_count", gorm.Expr("download_count + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrShareLimitReached
+	}
+	link.DownloadCount++
+	return nil
+}
+
+// a function to count a download of a share link and start the download that
+// resumes or splits it, download.Served are the bytes of the first request
+func (c *DBClient) StartShareDownload(link *model.ShareLink, download *model.ShareDownload) error {
+	return c.gorm.Transaction(func(tx *gorm.DB) error {
+		if err := NewClient(tx).CountShareDownload(link); err != nil {
+			return err
+		}
+		return tx.Create(download).Error
+	})
+}
+
+// a function to take length bytes from a download that has not expired, it
+// fails with ErrShareDownloadUsed when that would send more than the file
+func (c *DBClient) ReserveShareDownload(id string, linkID string, fileID string, length int64, now time.Time) error {
+	result := c.gorm.Model(&model.ShareDownload{}).
+		Where("id = ? AND share_link_id = ? AND file_id = ? AND expires_at > ? AND served + ? <= size", id, linkID, fileID, now, length).
+		Update("served", gorm.Expr("served + ?", length))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrShareDownloadUsed
+	}
+	return nil
+}
+
+// a function to give back bytes of a download that were reserved but never
+// sent, so the client can ask for them again
+func (c *DBClient) ReleaseShareDownload(id string, length int64) error {
+	return c.gorm.Model(&model.ShareDownload{}).
+		Where("id = ? AND served >= ?", id, length).
+		Update("served", gorm.Expr("served - ?", length)).Error
+}
+
+// a function to delete the downloads that expired before a time
+func (c *DBClient) DeleteExpiredShareDownloads(before time.Time) (int64, error) {
+	result := c.gorm.Where("expires_at < ?", before).Delete(&model.ShareDownload{})
+	return result.RowsAffected, result.Error
+}
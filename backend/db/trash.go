@@ -0,0 +1,235 @@
+package db
+
+import (
+	"time"
+
+	model "cascloud/models"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// a function to move a file to the trash
+func (c *DBClient) TrashFile(file *model.File, item *model.TrashItem) error {
+	return c.TrashFolderTree(nil, []model.File{*file}, item)
+}
+
+// a function to move folders and files to the trash as one trash item, the rows
+// stay in place but are hidden until the item is restored or purged. The first
+// folder, or the file when there are no folders, is the one that leaves its
+// canvas, so its connectors are removed. It fails with ErrTreeChanged when
+// something was moved or uploaded into the folders, or the files were moved
+// or trashed, since they were read.
+func (c *DBClient) TrashFolderTree(folders []model.Folder, files []model.File, item *model.TrashItem) error {
+	log.Info().Msg("Moving items to the trash")
+	return c.gorm.Transaction(func(tx *gorm.DB) error {
+		if err := lockFolders(tx, folders, files); err != nil {
+			return err
+		}
+		if err := tx.Create(item).Error; err != nil {
+			return err
+		}
+		top := uuid.Nil
+		if len(folders) > 0 {
+			top = folders[0].ID
+		} else if len(files) > 0 {
+			top = files[0].ID
+		}
+		if err := detachConnectors(tx, top, uuid.Nil); err != nil {
+			return err
+		}
+		trashed := map[string]interface{}{
+			"DeletedAt": gorm.DeletedAt{Time: item.DeletedAt.Time, Valid: true},
+			"TrashID":   item.ID,
+		}
+		if len(files) > 0 {
+			ids := make([]string, 0, len(files))
+			folderIDs := make([]string, 0, len(files))
+			for _, file := range files {
+				ids = append(ids, file.ID.String())
+				folderIDs = append(folderIDs, file.FolderID.String())
+			}
+			// every file must still be outside the trash in the folder it was read in
+			result := tx.Model(&model.File{}).Where("id IN ? AND folder_id IN ?", ids, folderIDs).Updates(trashed)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected != int64(len(files)) {
+				return ErrTreeChanged
+			}
+		}
+		if len(folders) > 0 {
+			ids := make([]string, 0, len(folders))
+			for _, folder := range folders {
+				ids = append(ids, folder.ID.String())
+			}
+			result := tx.Model(&model.Folder{}).Where("id IN ?", ids).Updates(trashed)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected != int64(len(folders)) {
+				return ErrTreeChanged
+			}
+		}
+		return checkTrashed(tx, folders)
+	})
+}
+
+// a function to get the trash of a workspace, newest first
+func (c *DBClient) GetTrashItems(workspaceID string) ([]model.TrashItem, error) {
+	var items []model.TrashItem
+	err := c.gorm.Where("workspace_id = ?", workspaceID).Order("deleted_at DESC").Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (c *DBClient) GetTrashItemByID(id string) (*model.TrashItem, error) {
+	var item model.TrashItem
+	err := c.gorm.Where("id = ?", id).First(&item).Error
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// a function to get the trash items that were deleted before the given time
+func (c *DBClient) GetExpiredTrashItems(before time.Time) ([]model.TrashItem, error) {
+	var items []model.TrashItem
+	err := c.gorm.Where("deleted_at < ?", before).Order("deleted_at").Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// a function to get the rows of a trash item, for a folder item the folder
+// itself comes first and parents always come before their children
+func (c *DBClient) GetTrashedTree(item *model.TrashItem) ([]model.Folder, []model.File, error) {
+	var folders []model.Folder
+	var files []model.File
+	err := c.gorm.Unscoped().Where("trash_id = ?", item.ID).Order("length(path)").Find(&folders).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range folders {
+		if folders[i].ID == item.ItemID {
+			folders[0], folders[i] = folders[i], folders[0]
+			break
+		}
+	}
+	err = c.gorm.Unscoped().Where("trash_id = ?", item.ID).Find(&files).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	return folders, files, nil
+}
+
+// a function to get the trash items whose rows sit inside the folders of
+// another trash item, they were trashed on their own before their parent
+func (c *DBClient) GetNestedTrashItems(item *model.TrashItem, folders []model.Folder) ([]model.TrashItem, error) {
+	var items []model.TrashItem
+	if len(folders) == 0 {
+		return items, nil
+	}
+	folderIDs := make([]string, 0, len(folders))
+	for _, folder := range folders {
+		folderIDs = append(folderIDs, folder.ID.String())
+	}
+	nestedFiles := c.gorm.Unscoped().Model(&model.File{}).Select("trash_id").
+		Where("folder_id IN ? AND trash_id <> ?", folderIDs, item.ID)
+	nestedFolders := c.gorm.Unscoped().Model(&model.Folder{}).Select("trash_id").
+		Where("parent_id IN ? AND trash_id <> ?", folderIDs, item.ID)
+	err := c.gorm.Where("id IN (?) OR id IN (?)", nestedFiles, nestedFolders).Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// a function to bring the rows of a trash item back, they are saved with the
+// given names, paths and parents since the original parent may be gone. It
+// fails with ErrNameTaken when the name was taken meanwhile.
+func (c *DBClient) RestoreTrashItem(item *model.TrashItem, folders []model.Folder, files []model.File) error {
+	log.Info().Msg("Restoring trash item")
+	return nameErr(c.gorm.Transaction(func(tx *gorm.DB) error {
+		if err := lockFolders(tx, folders, files); err != nil {
+			return err
+		}
+		for _, folder := range folders {
+			err := tx.Unscoped().Model(&model.Folder{ID: folder.ID}).Updates(map[string]interface{}{
+				"Name":        folder.Name,
+				"Path":        folder.Path,
+				"ParentID":    folder.ParentID,
+				"WorkspaceID": folder.WorkspaceID,
+				"DeletedAt":   nil,
+				"TrashID":     nil,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		for _, file := range files {
+			err := tx.Unscoped().Model(&model.File{ID: file.ID}).Updates(map[string]interface{}{
+				"Name":      file.Name,
+				"Path":      file.Path,
+				"FolderID":  file.FolderID,
+				"DeletedAt": nil,
+				"TrashID":   nil,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		if err := checkNames(tx, folders, files); err != nil {
+			return err
+		}
+		return tx.Delete(item).Error
+	}))
+}
+
+// a function to remove a trash item and its rows, file versions and share
+// links included, for good
+func (c *DBClient) PurgeTrashItem(item *model.TrashItem, folders []model.Folder, files []model.File) error {
+	log.Info().Msg("Purging trash item")
+	return c.gorm.Transaction(func(tx *gorm.DB) error {
+		ids := make([]string, 0, len(files)+len(folders))
+		if len(files) > 0 {
+			fileIDs := make([]string, 0, len(files))
+			for _, file := range files {
+				fileIDs = append(fileIDs, file.ID.String())
+			}
+			ids = append(ids, fileIDs...)
+			if err := tx.Where("file_id IN ?", fileIDs).Delete(&model.FileVersion{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Delete(&files).Error; err != nil {
+				return err
+			}
+		}
+		if len(folders) > 0 {
+			folderIDs := make([]string, 0, len(folders))
+			for _, folder := range folders {
+				folderIDs = append(folderIDs, folder.ID.String())
+			}
+			ids = append(ids, folderIDs...)
+			if err := tx.Where("folder_id IN ?", folderIDs).Delete(&model.CanvasItem{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("folder_id IN ?", folderIDs).Delete(&model.Connector{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Delete(&folders).Error; err != nil {
+				return err
+			}
+		}
+		if len(ids) > 0 {
+			if err := tx.Where("item_id IN ?", ids).Delete(&model.ShareLink{}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(item).Error
+	})
+}
@@ -0,0 +1,278 @@
+package db
+
+import (
+	model "cascloud/models"
+	"errors"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrTreeChanged is returned when folders were moved, renamed or filled by
+// someone else between reading them and saving a change to them
+var ErrTreeChanged = errors.New("folder tree was changed concurrently")
+
+// ErrNameTaken is returned when a file or folder in the folder already has the name
+var ErrNameTaken = errors.New("name is taken in the folder")
+
+// selects the id of a folder and of every folder below it
+const folderTreeQuery = `
+WITH RECURSIVE tree AS (
+	SELECT id FROM folders WHERE id = ?
+	UNION
+	SELECT folders.id FROM folders JOIN tree ON folders.parent_id = tree.id
+)
+SELECT id FROM tree`
+
+// counts the folders that are their own ancestor, folders that are not part
+// of a cycle stop at their home folder
+const folderCycleQuery = `
+WITH RECURSIVE ancestors(start, id) AS (
+	SELECT id, parent_id FROM folders WHERE id IN ?
+	UNION ALL
+	SELECT ancestors.start, folders.parent_id FROM folders JOIN ancestors ON folders.id = ancestors.id
+	WHERE ancestors.id <> ancestors.start
+)
+SELECT count(*) FROM ancestors WHERE id = start`
+
+// counts the folders whose path or workspace does not follow from their parent
+const folderPathQuery = `
+SELECT count(*) FROM folders child JOIN folders parent ON parent.id = child.parent_id
+WHERE (child.id IN ? OR child.parent_id IN ?)
+AND child.deleted_at IS NULL AND parent.deleted_at IS NULL
+AND (child.path <> (parent.path || '/' || child.name) OR child.workspace_id <> parent.workspace_id)`
+
+// counts the folders and files that are not in the trash but sit in one of
+// the given folders
+const liveChildrenQuery = `
+SELECT (SELECT count(*) FROM folders WHERE parent_id IN ? AND deleted_at IS NULL) +
+	(SELECT count(*) FROM files WHERE folder_id IN ? AND deleted_at IS NULL)`
+
+// counts the files whose path is not inside the path of their folder
+const filePathQuery = `
+SELECT count(*) FROM files JOIN folders ON folders.id = files.folder_id
+WHERE (files.id IN ? OR files.folder_id IN ?) AND files.deleted_at IS NULL
+AND substr(files.path, 1, length(folders.path) + 1) <> (folders.path || '/')`
+
+// counts the given folders and files that have the name of an item of the
+// other kind in the same folder, two folders or two files with one name are
+// kept out by the unique indexes on the names
+const nameClashQuery = `
+SELECT (SELECT count(*) FROM folders JOIN files ON files.folder_id = folders.parent_id AND files.name = folders.name
+	WHERE folders.id IN ? AND folders.deleted_at IS NULL AND files.deleted_at IS NULL) +
+	(SELECT count(*) FROM files JOIN folders ON folders.parent_id = files.folder_id AND folders.name = files.name
+	WHERE files.id IN ? AND files.deleted_at IS NULL AND folders.deleted_at IS NULL)`
+
+// a function to get a folder together with all of its descendant folders and
+// the files inside any of them, the folder itself is the first one returned
+func (c *DBClient) GetFolderTree(folderID string) ([]model.Folder, []model.File, error) {
+	var folders []model.Folder
+	var files []model.File
+	err := c.gorm.Where("id IN (?)", gorm.Expr(folderTreeQuery, folderID)).
+		Order("length(path)").Find(&folders).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(folders) == 0 {
+		return nil, nil, gorm.ErrRecordNotFound
+	}
+	// the root has the shortest path but make sure it is first even if a child has an odd name
+	for i := range folders {
+		if folders[i].ID.String() == folderID {
+			folders[0], folders[i] = folders[i], folders[0]
+			break
+		}
+	}
+
+	folderIDs := make([]string, 0, len(folders))
+	for _, folder := range folders {
+		folderIDs = append(folderIDs, folder.ID.String())
+	}
+	err = c.gorm.Where("folder_id IN ?", folderIDs).Find(&files).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	return folders, files, nil
+}
+
+// a function to create folders and files in a single transaction, parents
+// must come before their children. It fails with ErrNameTaken when the
+// folder they are created in already holds an item with one of their names.
+func (c *DBClient) CreateFolderTree(folders []model.Folder, files []model.File) error {
+	log.Info().Msg("Creating folder tree")
+	return nameErr(c.gorm.Transaction(func(tx *gorm.DB) error {
+		if err := lockFolders(tx, folders, files); err != nil {
+			return err
+		}
+		for i := range folders {
+			if err := tx.Create(&folders[i]).Error; err != nil {
+				return err
+			}
+		}
+		for i := range files {
+			if err := tx.Create(&files[i]).Error; err != nil {
+				return err
+			}
+		}
+		return checkNames(tx, folders, files)
+	}))
+}
+
+// lockFolders locks the rows of the folders that are saved, of their parents
+// and of the folders the files are in until the transaction ends, in a fixed
+// order so two moves wait for each other instead of deadlocking. SQLite has no
+// row locks but only runs one transaction at a time.
+func lockFolders(tx *gorm.DB, folders []model.Folder, files []model.File) error {
+	seen := make(map[uuid.UUID]bool)
+	ids := []string{}
+	add := func(id uuid.UUID) {
+		if id != uuid.Nil && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id.String())
+		}
+	}
+	for _, folder := range folders {
+		add(folder.ID)
+		add(folder.ParentID)
+	}
+	for _, file := range files {
+		add(file.FolderID)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	sort.Strings(ids)
+	var locked []model.Folder
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").
+		Where("id IN ?", ids).Order("id").Find(&locked).Error
+}
+
+// checkTree makes sure the saved folders and files still form a tree whose
+// paths follow the folder names, it returns ErrTreeChanged when a concurrent
+// change left a cycle or a stale path behind
+func checkTree(tx *gorm.DB, folders []model.Folder, files []model.File) error {
+	folderIDs := make([]uuid.UUID, 0, len(folders))
+	for _, folder := range folders {
+		folderIDs = append(folderIDs, folder.ID)
+	}
+	fileIDs := make([]uuid.UUID, 0, len(files))
+	for _, file := range files {
+		fileIDs = append(fileIDs, file.ID)
+	}
+
+	var count int64
+	if len(folderIDs) > 0 {
+		if err := tx.Raw(folderCycleQuery, folderIDs).Scan(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrTreeChanged
+		}
+		if err := tx.Raw(folderPathQuery, folderIDs, folderIDs).Scan(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrTreeChanged
+		}
+	}
+	if len(folderIDs) > 0 || len(fileIDs) > 0 {
+		if err := tx.Raw(filePathQuery, fileIDs, folderIDs).Scan(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrTreeChanged
+		}
+	}
+	return nil
+}
+
+// lockLiveFolder locks the row of a folder that is not in the trash until the
+// transaction ends, it returns ErrTreeChanged when the folder was trashed
+func lockLiveFolder(tx *gorm.DB, folderID uuid.UUID) (*model.Folder, error) {
+	var folder model.Folder
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", folderID).First(&folder).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrTreeChanged
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+
+// checkNames makes sure none of the saved folders and files has the name of
+// an item of the other kind in its folder, it returns ErrNameTaken when one
+// does. The folders they are saved in must be locked.
+func checkNames(tx *gorm.DB, folders []model.Folder, files []model.File) error {
+	if len(folders) == 0 && len(files) == 0 {
+		return nil
+	}
+	folderIDs := make([]uuid.UUID, 0, len(folders))
+	for _, folder := range folders {
+		folderIDs = append(folderIDs, folder.ID)
+	}
+	fileIDs := make([]uuid.UUID, 0, len(files))
+	for _, file := range files {
+		fileIDs = append(fileIDs, file.ID)
+	}
+	var count int64
+	if err := tx.Raw(nameClashQuery, folderIDs, fileIDs).Scan(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrNameTaken
+	}
+	return nil
+}
+
+// nameErr turns a violation of the unique indexes on the names into ErrNameTaken
+func nameErr(err error) error {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrNameTaken
+	}
+	return err
+}
+
+// checkNameFree makes sure no file or folder directly inside a folder has the
+// name, the folder must be locked so the name can not be taken meanwhile
+func checkNameFree(tx *gorm.DB, folderID uuid.UUID, name string) error {
+	var count int64
+	err := tx.Model(&model.Folder{}).Where("parent_id = ? AND name = ?", folderID, name).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		err = tx.Model(&model.File{}).Where("folder_id = ? AND name = ?", folderID, name).Count(&count).Error
+		if err != nil {
+			return err
+		}
+	}
+	if count > 0 {
+		return ErrNameTaken
+	}
+	return nil
+}
+
+// checkTrashed makes sure nothing outside the trash is left inside folders
+// that were just trashed, it returns ErrTreeChanged when something was moved
+// or uploaded into them after they were read
+func checkTrashed(tx *gorm.DB, folders []model.Folder) error {
+	if len(folders) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, 0, len(folders))
+	for _, folder := range folders {
+		ids = append(ids, folder.ID)
+	}
+	var count int64
+	if err := tx.Raw(liveChildrenQuery, ids, ids).Scan(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrTreeChanged
+	}
+	return nil
+}
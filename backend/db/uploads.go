@@ -0,0 +1,178 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	model "cascloud/models"
+	"cascloud/types"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// returned when a chunk is written at an offset that is no longer the confirmed one
+var ErrUploadOffsetConflict = errors.New("upload offset does not match")
+
+// returned when an upload is no longer in the status a change expects
+var ErrUploadStatusChanged = errors.New("upload status changed")
+
+func (c *DBClient) CreateUploadSession(session *model.UploadSession) error {
+	log.Info().Msg("Creating upload session")
+	return c.gorm.Create(session).Error
+}
+
+func (c *DBClient) GetUploadSessionByID(id string) (*model.UploadSession, error) {
+	var session model.UploadSession
+	err := c.gorm.Where("id = ?", id).First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// a function to let one request send the chunk at offset, it hands out the
+// next part number and holds the append lease until the part is recorded or
+// released. It fails with ErrUploadOffsetConflict when the offset is no longer
+// the confirmed one or another request holds a lease that has not run out.
+func (c *DBClient) ReserveUploadPart(session *model.UploadSession, offset int64, lease string, until time.Time, now time.Time) (int32, error) {
+	result := c.gorm.Model(&model.UploadSession{}).
+		Where("id = ? AND status = ? AND upload_offset = ? AND next_part = ?", session.ID, model.UploadStatusPending, offset, session.NextPart).
+		Where("append_lease = '' OR append_lease_until < ?", now).
+		Updates(map[string]interface{}{
+			"next_part":          gorm.Expr("next_part + 1"),
+			"append_lease":       lease,
+			"append_lease_until": until,
+		})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return 0, ErrUploadOffsetConflict
+	}
+	partNumber := session.NextPart
+	session.NextPart++
+	session.AppendLease = lease
+	return partNumber, nil
+}
+
+// a function to give up the append lease without recording a part
+func (c *DBClient) ReleaseUploadPart(session *model.UploadSession, lease string) error {
+	return c.gorm.Model(&model.UploadSession{}).
+		Where("id = ? AND append_lease = ?", session.ID, lease).
+		Updates(map[string]interface{}{"append_lease": "", "append_lease_until": nil}).Error
+}
+
+// a function to record an uploaded part and move the confirmed offset forward
+// together with the hash state, the offset only moves while the lease taken
+// with ReserveUploadPart is still held
+func (c *DBClient) AddUploadPart(session *model.UploadSession, part *model.UploadPart) error {
+	return c.gorm.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.UploadSession{}).
+			Where("id = ? AND upload_offset = ? AND status = ? AND append_lease = ?", session.ID, session.Offset, model.UploadStatusPending, session.AppendLease).
+			Updates(map[string]interface{}{
+				"upload_offset":      session.Offset + part.Size,
+				"hash_state":         session.HashState,
+				"append_lease":       "",
+				"append_lease_until": nil,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrUploadOffsetConflict
+		}
+		part.UploadSessionID = session.ID
+		if err := tx.Create(part).Error; err != nil {
+			return err
+		}
+		session.Offset += part.Size
+		session.AppendLease = ""
+		return nil
+	})
+}
+
+// a function to get the parts of an upload ordered by part number
+func (c *DBClient) GetUploadParts(sessionID string) ([]model.UploadPart, error) {
+	var parts []model.UploadPart
+	err := c.gorm.Where("upload_session_id = ?", sessionID).Order("part_number").Find(&parts).Error
+	if err != nil {
+		return nil, err
+	}
+	return parts, nil
+}
+
+// a function to move an upload from one status to another, it fails with
+// ErrUploadStatusChanged when the upload is no longer in the from status
+func (c *DBClient) UpdateUploadSessionStatus(session *model.UploadSession, from string, to string) error {
+	result := c.gorm.Model(&model.UploadSession{}).
+		Where("id = ? AND status = ?", session.ID, from).
+		Updates(map[string]interface{}{"status": to, "updated_at": types.NowTimestamp()})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrUploadStatusChanged
+	}
+	session.Status = to
+	return nil
+}
+
+// a function to start completing an upload that received every byte. A
+// completion that has been running since before staleBefore is taken to have
+// died and may be taken over. It fails with ErrUploadStatusChanged when the
+// upload can not be completed now.
+func (c *DBClient) ClaimUploadCompletion(session *model.UploadSession, staleBefore time.Time) error {
+	result := c.gorm.Model(&model.UploadSession{}).
+		Where("id = ? AND upload_offset = size AND append_lease = ''", session.ID).
+		Where("status = ? OR (status = ? AND updated_at < ?)", model.UploadStatusPending, model.UploadStatusCompleting, staleBefore).
+		Updates(map[string]interface{}{"status": model.UploadStatusCompleting, "updated_at": types.NowTimestamp()})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrUploadStatusChanged
+	}
+	session.Status = model.UploadStatusCompleting
+	return nil
+}
+
+// a function to get the uploads nobody touched since before, and the ones
+// whose expiry did not finish
+func (c *DBClient) GetExpiredUploadSessions(before time.Time) ([]model.UploadSession, error) {
+	var sessions []model.UploadSession
+	err := c.gorm.Where("updated_at < ? OR status = ?", before, model.UploadStatusExpired).
+		Order("updated_at").Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// a function to mark an upload nobody touched since before as expired, it
+// fails with ErrUploadStatusChanged when the upload was used in the meantime
+func (c *DBClient) ExpireUploadSession(session *model.UploadSession, before time.Time) error {
+	if session.Status == model.UploadStatusExpired {
+		return nil
+	}
+	result := c.gorm.Model(&model.UploadSession{}).
+		Where("id = ? AND status = ? AND updated_at < ?", session.ID, session.Status, before).
+		Update("status", model.UploadStatusExpired)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrUploadStatusChanged
+	}
+	return nil
+}
+
+// a function to delete an upload with its parts
+func (c *DBClient) DeleteUploadSession(session *model.UploadSession) error {
+	return c.gorm.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("upload_session_id = ?", session.ID).Delete(&model.UploadPart{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&model.UploadSession{}, "id = ?", session.ID).Error
+	})
+}
@@ -0,0 +1,142 @@
+package db
+
+import (
+	"errors"
+
+	model "cascloud/models"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// ErrFileChanged is returned when a version was added to a file by someone
+// else between reading the file and adding a version to it
+var ErrFileChanged = errors.New("file was changed concurrently")
+
+// a function to get the file with the given name in a folder, it returns nil
+// without an error when there is no such file
+func (c *DBClient) GetFileByName(folderID string, name string) (*model.File, error) {
+	var file model.File
+	err := c.gorm.Where("folder_id = ? AND name = ?", folderID, name).First(&file).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// a function to create a new file together with its first version, the
+// content is already stored at file.StorageKey. It fails with ErrNameTaken
+// when the folder already holds an item with the name.
+func (c *DBClient) CreateFileWithVersion(file *model.File, version *model.FileVersion) error {
+	log.Info().Msg("Creating file")
+	return nameErr(c.gorm.Transaction(func(tx *gorm.DB) error {
+		// the folder must not go to the trash while the file is added to it
+		if _, err := lockLiveFolder(tx, file.FolderID); err != nil {
+			return err
+		}
+		if err := checkNameFree(tx, file.FolderID, file.Name); err != nil {
+			return err
+		}
+		file.Version = 1
+		if err := tx.Create(file).Error; err != nil {
+			return err
+		}
+		version.FileID = file.ID
+		version.Version = 1
+		version.StorageKey = file.StorageKey
+		return tx.Create(version).Error
+	}))
+}
+
+// a function to make version, whose content is already stored under
+// version.StorageKey, the current version of a file. The file is pointed at
+// the new content in the same step that checks nobody else added a version
+// since the file was read, otherwise it fails with ErrFileChanged.
+func (c *DBClient) AddFileVersion(file *model.File, version *model.FileVersion) error {
+	log.Info().Msg("Adding file version")
+	return c.gorm.Transaction(func(tx *gorm.DB) error {
+		// the update also keeps other uploads of the file waiting until this commits
+		next := file.Version + 1
+		result := tx.Model(&model.File{}).
+			Where("id = ? AND version = ?", file.ID, file.Version).
+			Updates(map[string]interface{}{
+				"Version":    next,
+				"Size":       version.Size,
+				"StorageKey": version.StorageKey,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrFileChanged
+		}
+
+		// files uploaded before versioning have no version rows yet
+		var count int64
+		err := tx.Model(&model.FileVersion{}).
+			Where("file_id = ? AND version = ?", file.ID, file.Version).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			previous := model.FileVersion{
+				FileID:     file.ID,
+				Version:    file.Version,
+				StorageKey: file.StorageKey,
+				Size:       file.Size,
+				CreatedAt:  file.CreatedAt,
+			}
+			if err := tx.Create(&previous).Error; err != nil {
+				return err
+			}
+		}
+
+		version.FileID = file.ID
+		version.Version = next
+		if err := tx.Create(version).Error; err != nil {
+			return err
+		}
+		file.Version = version.Version
+		file.Size = version.Size
+		file.StorageKey = version.StorageKey
+		return nil
+	})
+}
+
+// a function to get the versions of a file, newest first
+func (c *DBClient) GetFileVersions(fileID string) ([]model.FileVersion, error) {
+	var versions []model.FileVersion
+	err := c.gorm.Where("file_id = ?", fileID).Order("version DESC").Find(&versions).Error
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+func (c *DBClient) GetFileVersion(fileID string, version int) (*model.FileVersion, error) {
+	var fileVersion model.FileVersion
+	err := c.gorm.Where("file_id = ? AND version = ?", fileID, version).First(&fileVersion).Error
+	if err != nil {
+		return nil, err
+	}
+	return &fileVersion, nil
+}
+
+// a function to get the storage keys of every version of some files
+func (c *DBClient) GetVersionKeys(fileIDs []string) ([]string, error) {
+	var keys []string
+	if len(fileIDs) == 0 {
+		return keys, nil
+	}
+	err := c.gorm.Model(&model.FileVersion{}).
+		Where("file_id IN ? AND storage_key <> ''", fileIDs).
+		Pluck("storage_key", &keys).Error
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
@@ -0,0 +1,81 @@
+package events
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// time allowed to write a message to the client
+	writeWait = 10 * time.Second
+	// time allowed between two pongs before the connection is considered dead
+	pongWait = 60 * time.Second
+	// pings are sent a bit more often than pongWait
+	pingPeriod = pongWait * 9 / 10
+	// clients have nothing big to say
+	maxMessageSize = 4096
+	// how often a quiet collaborator is checked for having gone away
+	presencePeriod = presenceTimeout / 3
+)
+
+// Serve pumps the events of a subscriber to a websocket connection until the
+// client goes away or the subscriber is dropped, then it cleans up both. What
+// the client sends is handled as presence.
+func Serve(conn *websocket.Conn, hub *Hub, sub *Subscriber) {
+	defer conn.Close()
+	defer hub.Unsubscribe(sub)
+
+	hub.sendSnapshot(sub)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn.SetReadLimit(maxMessageSize)
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(pongWait))
+		})
+		for {
+			// reading also processes pongs and close frames
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if messageType == websocket.TextMessage {
+				hub.HandleMessage(sub, data)
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	presenceTicker := time.NewTicker(presencePeriod)
+	defer presenceTicker.Stop()
+	for {
+		select {
+		case message, ok := <-sub.Messages():
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				closeMessage := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow")
+				if sub.revoked {
+					closeMessage = websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "no longer a member")
+				}
+				conn.WriteMessage(websocket.CloseMessage, closeMessage)
+				return
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-presenceTicker.C:
+			hub.expirePresence(sub, presenceTimeout)
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package events
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// the kinds of events that are pushed to the collaborators of a workspace
+const (
+	FileCreated    = "file.created"
+	FileUpdated    = "file.updated"
+	FileMoved      = "file.moved"
+	FileRenamed    = "file.renamed"
+	FileDeleted    = "file.deleted"
+	FileRestored   = "file.restored"
+	FolderCreated  = "folder.created"
+	FolderMoved    = "folder.moved"
+	FolderRenamed  = "folder.renamed"
+	FolderDeleted  = "folder.deleted"
+	FolderRestored = "folder.restored"
+	// every item of a folder got new coordinates at once
+	FolderArranged = "folder.arranged"
+	// notes, links and frames
+	ItemCreated = "item.created"
+	ItemUpdated = "item.updated"
+	ItemMoved   = "item.moved"
+	ItemDeleted = "item.deleted"
+	// arrows between the things on a canvas
+	ConnectorCreated = "connector.created"
+	ConnectorUpdated = "connector.updated"
+	ConnectorDeleted = "connector.deleted"
+)
+
+// how many encoded events may wait for a subscriber before it is considered
+// too slow and disconnected, so one stuck client never blocks a publish
+const subscriberBuffer = 64
+
+// Event is a change in a workspace, Data is the file or folder it is about
+type Event struct {
+	Type        string      `json:"type"`
+	WorkspaceID uuid.UUID   `json:"workspace_id"`
+	FolderID    uuid.UUID   `json:"folder_id"`
+	ActorID     uuid.UUID   `json:"actor_id"`
+	Data        interface{} `json:"data"`
+	Time        time.Time   `json:"time"`
+}
+
+// Subscriber receives the encoded events of one workspace, ID tells apart
+// the connections of a user
+type Subscriber struct {
+	ID          uuid.UUID
+	WorkspaceID uuid.UUID
+	UserID      uuid.UUID
+	send        chan []byte
+	// set before send is closed when the user lost access to the workspace
+	revoked bool
+
+	// mu guards the presence, it is taken after the lock of the hub
+	mu       sync.Mutex
+	active   bool
+	presence Presence
+}
+
+// Messages is closed once the subscriber is removed from the hub
+func (s *Subscriber) Messages() <-chan []byte {
+	return s.send
+}
+
+// Hub fans events out to the subscribers of each workspace. An event is
+// encoded once per publish and handed to every subscriber without blocking.
+type Hub struct {
+	mu         sync.RWMutex
+	workspaces map[uuid.UUID]map[*Subscriber]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{workspaces: make(map[uuid.UUID]map[*Subscriber]struct{})}
+}
+
+func (h *Hub) Subscribe(workspaceID uuid.UUID, userID uuid.UUID) *Subscriber {
+	id := uuid.New()
+	sub := &Subscriber{
+		ID:          id,
+		WorkspaceID: workspaceID,
+		UserID:      userID,
+		send:        make(chan []byte, subscriberBuffer),
+		presence:    Presence{SessionID: id, UserID: userID},
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	subs, ok := h.workspaces[workspaceID]
+	if !ok {
+		subs = make(map[*Subscriber]struct{})
+		h.workspaces[workspaceID] = subs
+	}
+	subs[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe removes a subscriber and closes its channel, it is safe to call
+// more than once
+func (h *Hub) Unsubscribe(sub *Subscriber) {
+	h.mu.Lock()
+	removed := h.remove(sub)
+	h.mu.Unlock()
+	if removed {
+		h.leave(sub)
+	}
+}
+
+// DisconnectUser removes every subscriber a user has in a workspace, their
+// connections are closed, and returns how many there were. It is used when
+// the user is no longer a member of the workspace.
+func (h *Hub) DisconnectUser(workspaceID uuid.UUID, userID uuid.UUID) int {
+	var removed []*Subscriber
+	h.mu.Lock()
+	for sub := range h.workspaces[workspaceID] {
+		if sub.UserID == userID {
+			sub.revoked = true
+			h.remove(sub)
+			removed = append(removed, sub)
+		}
+	}
+	h.mu.Unlock()
+	for _, sub := range removed {
+		h.leave(sub)
+	}
+	return len(removed)
+}
+
+// remove must be called with the write lock held, it reports whether the
+// subscriber was still there
+func (h *Hub) remove(sub *Subscriber) bool {
+	subs, ok := h.workspaces[sub.WorkspaceID]
+	if !ok {
+		return false
+	}
+	if _, ok := subs[sub]; !ok {
+		return false
+	}
+	delete(subs, sub)
+	close(sub.send)
+	if len(subs) == 0 {
+		delete(h.workspaces, sub.WorkspaceID)
+	}
+	return true
+}
+
+// Publish sends an event to every subscriber of its workspace
+func (h *Hub) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	message, err := json.Marshal(event)
+	if err != nil {
+		log.Error().Err(err).Str("type", event.Type).Msg("Error encoding event")
+		return
+	}
+	h.broadcast(event.WorkspaceID, message, nil)
+}
+
+// broadcast sends an encoded message to the subscribers of a workspace, only to
+// those to accepts when it is not nil. Subscribers that fell too far behind are
+// dropped.
+func (h *Hub) broadcast(workspaceID uuid.UUID, message []byte, to func(*Subscriber) bool) {
+	var slow []*Subscriber
+	h.mu.RLock()
+	for sub := range h.workspaces[workspaceID] {
+		if to != nil && !to(sub) {
+			continue
+		}
+		select {
+		case sub.send <- message:
+		default:
+			slow = append(slow, sub)
+		}
+	}
+	h.mu.RUnlock()
+
+	if len(slow) == 0 {
+		return
+	}
+	var removed []*Subscriber
+	h.mu.Lock()
+	for _, sub := range slow {
+		if h.remove(sub) {
+			log.Warn().Str("workspace", workspaceID.String()).Msg("Dropping slow event subscriber")
+			removed = append(removed, sub)
+		}
+	}
+	h.mu.Unlock()
+	for _, sub := range removed {
+		h.leave(sub)
+	}
+}
+
+// Subscribers is the number of subscribers of a workspace
+func (h *Hub) Subscribers(workspaceID uuid.UUID) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.workspaces[workspaceID])
+}
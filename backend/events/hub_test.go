@@ -0,0 +1,211 @@
+package events
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// received is an event as a client decodes it
+type received struct {
+	Type     string          `json:"type"`
+	FolderID uuid.UUID       `json:"folder_id"`
+	ActorID  uuid.UUID       `json:"actor_id"`
+	Data     json.RawMessage `json:"data"`
+	Time     time.Time       `json:"time"`
+}
+
+// next reads the message waiting for a subscriber, the hub sends before
+// Publish returns so nothing is waited for
+func next(t *testing.T, sub *Subscriber) received {
+	t.Helper()
+	select {
+	case message, ok := <-sub.Messages():
+		if !ok {
+			t.Fatal("the subscriber was closed")
+		}
+		var event received
+		if err := json.Unmarshal(message, &event); err != nil {
+			t.Fatal(err)
+		}
+		return event
+	default:
+		t.Fatal("no message was sent")
+	}
+	return received{}
+}
+
+func nothing(t *testing.T, sub *Subscriber) {
+	t.Helper()
+	select {
+	case message := <-sub.Messages():
+		t.Errorf("got %s, want nothing", message)
+	default:
+	}
+}
+
+func closed(t *testing.T, sub *Subscriber) {
+	t.Helper()
+	for range sub.Messages() {
+	}
+}
+
+func TestPublish(t *testing.T) {
+	h := NewHub()
+	workspace, other := uuid.New(), uuid.New()
+	alice := h.Subscribe(workspace, uuid.New())
+	bob := h.Subscribe(workspace, uuid.New())
+	outsider := h.Subscribe(other, uuid.New())
+	if n := h.Subscribers(workspace); n != 2 {
+		t.Errorf("the workspace has %d subscribers, want 2", n)
+	}
+
+	folder := uuid.New()
+	h.Publish(Event{Type: FileCreated, WorkspaceID: workspace, FolderID: folder, ActorID: alice.UserID})
+	for _, sub := range []*Subscriber{alice, bob} {
+		event := next(t, sub)
+		if event.Type != FileCreated || event.FolderID != folder || event.ActorID != alice.UserID || event.Time.IsZero() {
+			t.Errorf("got %+v", event)
+		}
+	}
+	nothing(t, outsider)
+}
+
+func TestUnsubscribe(t *testing.T) {
+	h := NewHub()
+	workspace := uuid.New()
+	sub := h.Subscribe(workspace, uuid.New())
+	h.Unsubscribe(sub)
+	h.Unsubscribe(sub)
+	closed(t, sub)
+	if n := h.Subscribers(workspace); n != 0 {
+		t.Errorf("the workspace has %d subscribers, want 0", n)
+	}
+	// publishing to a workspace without subscribers does nothing
+	h.Publish(Event{Type: FileCreated, WorkspaceID: workspace})
+}
+
+func TestDisconnectUser(t *testing.T) {
+	h := NewHub()
+	workspace, user := uuid.New(), uuid.New()
+	first := h.Subscribe(workspace, user)
+	second := h.Subscribe(workspace, user)
+	elsewhere := h.Subscribe(uuid.New(), user)
+	other := h.Subscribe(workspace, uuid.New())
+
+	if n := h.DisconnectUser(workspace, user); n != 2 {
+		t.Errorf("disconnected %d subscribers, want 2", n)
+	}
+	for _, sub := range []*Subscriber{first, second} {
+		closed(t, sub)
+		if !sub.revoked {
+			t.Error("a disconnected subscriber was not revoked")
+		}
+	}
+	h.Publish(Event{Type: FolderCreated, WorkspaceID: workspace})
+	next(t, other)
+	nothing(t, elsewhere)
+	if elsewhere.revoked || other.revoked {
+		t.Error("a subscriber that kept access was revoked")
+	}
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	h := NewHub()
+	workspace := uuid.New()
+	slow := h.Subscribe(workspace, uuid.New())
+	fast := h.Subscribe(workspace, uuid.New())
+	for i := 0; i <= subscriberBuffer; i++ {
+		h.Publish(Event{Type: FileUpdated, WorkspaceID: workspace})
+		next(t, fast)
+	}
+	// the buffered events are still delivered before the channel closes
+	count := 0
+	for range slow.Messages() {
+		count++
+	}
+	if count != subscriberBuffer {
+		t.Errorf("the slow subscriber got %d events, want %d", count, subscriberBuffer)
+	}
+	if n := h.Subscribers(workspace); n != 1 {
+		t.Errorf("the workspace has %d subscribers, want 1", n)
+	}
+}
+
+func TestPresence(t *testing.T) {
+	h := NewHub()
+	workspace, folder, otherFolder := uuid.New(), uuid.New(), uuid.New()
+	alice := h.Subscribe(workspace, uuid.New())
+	bob := h.Subscribe(workspace, uuid.New())
+	carol := h.Subscribe(workspace, uuid.New())
+
+	// nothing is shown before a folder is opened
+	h.HandleMessage(alice, []byte(`{"type":"presence.cursor","x":1,"y":2}`))
+	h.HandleMessage(alice, []byte(`not json`))
+	h.HandleMessage(alice, []byte(`{"type":"presence.unknown"}`))
+	nothing(t, bob)
+
+	h.HandleMessage(alice, []byte(`{"type":"presence.view","folder_id":"`+folder.String()+`"}`))
+	h.HandleMessage(bob, []byte(`{"type":"presence.view","folder_id":"`+folder.String()+`"}`))
+	h.HandleMessage(carol, []byte(`{"type":"presence.view","folder_id":"`+otherFolder.String()+`"}`))
+	// views go to the whole workspace but not back to the sender
+	if event := next(t, bob); event.Type != PresenceUpdate || event.ActorID != alice.UserID || event.FolderID != folder {
+		t.Errorf("bob got %+v", event)
+	}
+	next(t, carol)
+	next(t, alice)
+	next(t, carol)
+	next(t, alice)
+	next(t, bob)
+	nothing(t, alice)
+
+	// cursors and drags only go to the same folder
+	h.HandleMessage(alice, []byte(`{"type":"presence.cursor","x":1,"y":2}`))
+	event := next(t, bob)
+	var presence Presence
+	if err := json.Unmarshal(event.Data, &presence); err != nil {
+		t.Fatal(err)
+	}
+	if presence.Cursor == nil || presence.Cursor.X != 1 || presence.Cursor.Y != 2 || presence.SessionID != alice.ID {
+		t.Errorf("bob got %+v", presence)
+	}
+	nothing(t, carol)
+	h.HandleMessage(alice, []byte(`{"type":"presence.drag","item_type":"canvas","item_id":"`+uuid.NewString()+`"}`))
+	nothing(t, bob)
+	// a heartbeat of someone present is not passed on
+	h.HandleMessage(alice, []byte(`{"type":"presence.heartbeat"}`))
+	nothing(t, bob)
+
+	// a new subscriber learns who is there
+	dave := h.Subscribe(workspace, uuid.New())
+	h.sendSnapshot(dave)
+	event = next(t, dave)
+	var present []Presence
+	if err := json.Unmarshal(event.Data, &present); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != PresenceSnapshot || len(present) != 3 {
+		t.Errorf("the snapshot is %s with %d present, want 3", event.Type, len(present))
+	}
+	nothing(t, alice)
+
+	// leaving and going quiet are told to everyone
+	h.Unsubscribe(alice)
+	for _, sub := range []*Subscriber{bob, carol, dave} {
+		if event := next(t, sub); event.Type != PresenceLeave || event.ActorID != alice.UserID {
+			t.Errorf("got %+v", event)
+		}
+	}
+	h.expirePresence(bob, time.Hour)
+	nothing(t, carol)
+	h.expirePresence(bob, 0)
+	if event := next(t, carol); event.Type != PresenceLeave || event.ActorID != bob.UserID {
+		t.Errorf("carol got %+v", event)
+	}
+	next(t, dave)
+	// bob is gone already so closing his connection tells nobody
+	h.Unsubscribe(bob)
+	nothing(t, carol)
+}
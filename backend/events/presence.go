@@ -0,0 +1,216 @@
+package events
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Presence is who is looking at the canvas of a workspace and what they do on
+// it. It only lives in the hub, nothing of it is stored in the database.
+//
+// Clients send JSON messages over the events websocket:
+//   - {"type":"presence.view","folder_id":"..."} when they open a folder
+//   - {"type":"presence.cursor","x":1,"y":2} when their cursor moves
+//   - {"type":"presence.drag","item_type":"file","item_id":"...","x":1,"y":2}
+//     while they drag an item
+//   - {"type":"presence.drop"} when they let go of it
+//   - {"type":"presence.heartbeat"} when nothing else happened for a while
+//
+// Views and leaves go to the whole workspace, cursors and drags only to the
+// collaborators looking at the same folder. A collaborator that has not sent
+// anything for presenceTimeout is announced as gone. Clients are expected to
+// throttle cursor messages themselves.
+const (
+	PresenceView      = "presence.view"
+	PresenceCursor    = "presence.cursor"
+	PresenceDrag      = "presence.drag"
+	PresenceDrop      = "presence.drop"
+	PresenceHeartbeat = "presence.heartbeat"
+
+	// sent to the collaborators
+	PresenceUpdate   = "presence.update"
+	PresenceLeave    = "presence.leave"
+	PresenceSnapshot = "presence.snapshot"
+)
+
+// how long a collaborator stays present without sending anything
+const presenceTimeout = 30 * time.Second
+
+// Point is a position on the canvas
+type Point struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Drag is an item a collaborator is dragging and where it is right now
+type Drag struct {
+	ItemType string    `json:"item_type"`
+	ItemID   uuid.UUID `json:"item_id"`
+	Point
+}
+
+// Presence is the state of one connection, a user with two tabs open has two
+type Presence struct {
+	SessionID uuid.UUID `json:"session_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	FolderID  uuid.UUID `json:"folder_id"`
+	Cursor    *Point    `json:"cursor"`
+	Dragging  *Drag     `json:"dragging"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// clientMessage is what a client may send over the websocket
+type clientMessage struct {
+	Type     string    `json:"type"`
+	FolderID uuid.UUID `json:"folder_id"`
+	ItemType string    `json:"item_type"`
+	ItemID   uuid.UUID `json:"item_id"`
+	X        float64   `json:"x"`
+	Y        float64   `json:"y"`
+}
+
+// HandleMessage applies a message of a client to its presence and tells the
+// collaborators about it, messages that make no sense are ignored
+func (h *Hub) HandleMessage(sub *Subscriber, data []byte) {
+	var msg clientMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		log.Debug().Err(err).Msg("Ignoring malformed presence message")
+		return
+	}
+
+	sub.mu.Lock()
+	wasActive := sub.active
+	switch msg.Type {
+	case PresenceView:
+		if msg.FolderID == uuid.Nil {
+			sub.mu.Unlock()
+			return
+		}
+		// cursors and drags belong to the folder that was left
+		sub.presence.FolderID = msg.FolderID
+		sub.presence.Cursor = nil
+		sub.presence.Dragging = nil
+	case PresenceCursor:
+		sub.presence.Cursor = &Point{X: msg.X, Y: msg.Y}
+	case PresenceDrag:
+		if msg.ItemID == uuid.Nil || (msg.ItemType != "file" && msg.ItemType != "folder") {
+			sub.mu.Unlock()
+			return
+		}
+		sub.presence.Cursor = &Point{X: msg.X, Y: msg.Y}
+		sub.presence.Dragging = &Drag{ItemType: msg.ItemType, ItemID: msg.ItemID, Point: Point{X: msg.X, Y: msg.Y}}
+	case PresenceDrop:
+		sub.presence.Dragging = nil
+	case PresenceHeartbeat:
+		// only keeps the presence alive
+	default:
+		sub.mu.Unlock()
+		return
+	}
+	sub.presence.LastSeen = time.Now().UTC()
+	// nothing is shown before the client said which folder it looks at
+	if sub.presence.FolderID == uuid.Nil {
+		sub.mu.Unlock()
+		return
+	}
+	sub.active = true
+	presence := sub.presence
+	sub.mu.Unlock()
+
+	if msg.Type == PresenceHeartbeat && wasActive {
+		return
+	}
+	// the whole workspace learns where someone is, only their folder how they move
+	h.broadcastPresence(sub, PresenceUpdate, presence, msg.Type == PresenceView || !wasActive)
+}
+
+// expirePresence announces a collaborator as gone once it has been quiet for
+// longer than timeout, the next message it sends makes it present again
+func (h *Hub) expirePresence(sub *Subscriber, timeout time.Duration) {
+	sub.mu.Lock()
+	if !sub.active || time.Since(sub.presence.LastSeen) < timeout {
+		sub.mu.Unlock()
+		return
+	}
+	sub.active = false
+	sub.presence.Cursor = nil
+	sub.presence.Dragging = nil
+	presence := sub.presence
+	sub.mu.Unlock()
+	h.broadcastPresence(sub, PresenceLeave, presence, true)
+}
+
+// leave announces a removed subscriber as gone if it was present
+func (h *Hub) leave(sub *Subscriber) {
+	sub.mu.Lock()
+	wasActive := sub.active
+	sub.active = false
+	presence := sub.presence
+	sub.mu.Unlock()
+	if wasActive {
+		h.broadcastPresence(sub, PresenceLeave, presence, true)
+	}
+}
+
+// sendSnapshot tells a new subscriber who is already present in its workspace
+func (h *Hub) sendSnapshot(sub *Subscriber) {
+	present := []Presence{}
+	h.mu.RLock()
+	for other := range h.workspaces[sub.WorkspaceID] {
+		if other == sub {
+			continue
+		}
+		other.mu.Lock()
+		if other.active {
+			present = append(present, other.presence)
+		}
+		other.mu.Unlock()
+	}
+	h.mu.RUnlock()
+
+	message, err := json.Marshal(Event{
+		Type:        PresenceSnapshot,
+		WorkspaceID: sub.WorkspaceID,
+		ActorID:     sub.UserID,
+		Data:        present,
+		Time:        time.Now().UTC(),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error encoding presence snapshot")
+		return
+	}
+	h.broadcast(sub.WorkspaceID, message, func(to *Subscriber) bool {
+		return to == sub
+	})
+}
+
+// broadcastPresence sends the presence of sub to the other subscribers of its
+// workspace, to all of them or only to those looking at the same folder
+func (h *Hub) broadcastPresence(sub *Subscriber, eventType string, presence Presence, everyone bool) {
+	message, err := json.Marshal(Event{
+		Type:        eventType,
+		WorkspaceID: sub.WorkspaceID,
+		FolderID:    presence.FolderID,
+		ActorID:     sub.UserID,
+		Data:        presence,
+		Time:        time.Now().UTC(),
+	})
+	if err != nil {
+		log.Error().Err(err).Str("type", eventType).Msg("Error encoding presence")
+		return
+	}
+	h.broadcast(sub.WorkspaceID, message, func(to *Subscriber) bool {
+		if to == sub {
+			return false
+		}
+		if everyone {
+			return true
+		}
+		to.mu.Lock()
+		defer to.mu.Unlock()
+		return to.presence.FolderID == presence.FolderID
+	})
+}
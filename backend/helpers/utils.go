@@ -2,6 +2,12 @@ package helpers
 
 import (
 	"cascloud/models"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strings"
@@ -9,77 +15,190 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var secret = "victoria"
+// secret signs every token, it is set from the config at startup
+var secret []byte
+
+// ErrNoSecret is returned when a token is signed or checked before SetSecret
+var ErrNoSecret = errors.New("the JWT secret is not set")
+
+// the audiences of our tokens, a token is only accepted where it was meant to
+// be used so a share token can never pass as a login and the other way around
+const (
+	apiAudience   = "cascloud-api"
+	shareAudience = "cascloud-share"
+)
+
+// a function to set the secret that signs and checks tokens
+func SetSecret(value string) error {
+	if value == "" {
+		return ErrNoSecret
+	}
+	secret = []byte(value)
+	return nil
+}
+
+// signingKey checks that a token is signed with HMAC and returns the secret
+func signingKey(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+	if len(secret) == 0 {
+		return nil, ErrNoSecret
+	}
+	return secret, nil
+}
+
+// a function to sign claims with the secret
+func sign(claims jwt.Claims) (string, error) {
+	if len(secret) == 0 {
+		return "", ErrNoSecret
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+}
+
+// the authenticated user is stored in the echo context under this key
+const userContextKey = "user"
+
+// Claims are the claims of our tokens, the subject is the id of the user
+type Claims struct {
+	Email string `json:"email"`
+	jwt.StandardClaims
+}
 
 func GenerateJWT(user models.User) (string, error) {
-	log.Println(user.Email)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
-		Subject:   user.Email,
-		ExpiresAt: time.Now().Add(time.Hour * 72).Unix(),
+	return sign(Claims{
+		Email: user.Email,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  apiAudience,
+			Subject:   user.ID.String(),
+			ExpiresAt: time.Now().Add(time.Hour * 72).Unix(),
+		},
 	})
-	tokenStr, err := token.SignedString([]byte(secret))
+}
+
+// a function to parse and verify a JWT token
+func ParseJWT(token string) (*Claims, error) {
+	var claims Claims
+	parsedToken, err := jwt.ParseWithClaims(token, &claims, signingKey)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if !parsedToken.Valid {
+		return nil, errors.New("invalid token")
+	}
+	if !claims.VerifyAudience(apiAudience, true) {
+		return nil, errors.New("token is not meant for the API")
 	}
-	return tokenStr, nil
+	return &claims, nil
 }
 
-// a function to decode the JWT token
+// a function to decode the JWT token, it returns the id of the user
 func DecodeJWT(token string) (string, error) {
-	parsedToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		_, ok := token.Method.(*jwt.SigningMethodHMAC)
-		log.Println("Error parsing token1")
-		if !ok {
-			return "", nil
-		}
-		return []byte(secret), nil
-	})
+	claims, err := ParseJWT(token)
 	if err != nil {
 		log.Printf("Error parsing token: %T - %s\n", err, err) // Print error details
 		return "", err
 	}
-	if parsedToken.Valid {
-		return parsedToken.Claims.(jwt.MapClaims)["sub"].(string), nil
-	}
-	return "", err
+	return claims.Subject, nil
 }
 
-func ValidateJWT(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		token := c.Request().Header.Get("Authorization")
-		if token != "" {
+// ValidateJWT returns a middleware that only lets requests with a valid bearer
+// token through, the user of the token is looked up and stored in the context
+// where handlers get it with CurrentUser
+func ValidateJWT(lookup func(id string) (*models.User, error)) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token := c.Request().Header.Get("Authorization")
+			// browsers can not set headers on websockets, they pass the token in the query
+			if token == "" && isWebsocketUpgrade(c.Request()) && c.QueryParam("token") != "" {
+				token = "Bearer " + c.QueryParam("token")
+			}
+			if token == "" {
+				return echo.ErrUnauthorized
+			}
 			// we need to remove the Bearer prefix from the token
 			parts := strings.Split(token, " ")
 			if len(parts) != 2 || parts[0] != "Bearer" {
 				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token format")
 			}
-			token = parts[1]
-			parsedToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-				_, ok := token.Method.(*jwt.SigningMethodHMAC)
-				if !ok {
-					log.Println("Error parsing token1")
-					return "", nil
-				}
-				return []byte(secret), nil
-			})
+			userID, err := DecodeJWT(parts[1])
 			if err != nil {
-				log.Printf("Error parsing token: %T - %s\n", err, err) // Print error details
 				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token")
 			}
-			if parsedToken.Valid {
-				return next(c)
+			if _, parseErr := uuid.Parse(userID); parseErr != nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token")
+			}
+			user, userErr := lookup(userID)
+			if userErr != nil {
+				log.Printf("Error getting user of token: %s\n", userErr)
+				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token")
 			}
-			return echo.ErrUnauthorized
+			c.Set(userContextKey, user)
+			return next(c)
 		}
-		return echo.ErrUnauthorized
 	}
 }
 
+func isWebsocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// CurrentUser is the user authenticated by ValidateJWT, nil on routes that are
+// not behind it
+func CurrentUser(c echo.Context) *models.User {
+	user, _ := c.Get(userContextKey).(*models.User)
+	return user
+}
+
 func ComparePasswords(hashedPassword string, password string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 	return err == nil
 }
+
+// a function to generate an unguessable token for a share link, only the hash
+// should be stored
+func GenerateShareToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, HashShareToken(token), nil
+}
+
+func HashShareToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ShareClaims are the claims of the short lived tokens handed out by share
+// links, the subject is the id of the link and the scope what the token allows
+type ShareClaims struct {
+	Scope string `json:"scope"`
+	jwt.StandardClaims
+}
+
+// a function to sign a token that allows scope on a share link for ttl
+func GenerateShareClaim(linkID uuid.UUID, scope string, ttl time.Duration) (string, error) {
+	return sign(ShareClaims{
+		Scope: scope,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  shareAudience,
+			Subject:   linkID.String(),
+			ExpiresAt: time.Now().Add(ttl).Unix(),
+		},
+	})
+}
+
+// a function to check that a token allows scope on a share link and has not expired
+func VerifyShareClaim(token string, linkID uuid.UUID, scope string) bool {
+	var claims ShareClaims
+	parsedToken, err := jwt.ParseWithClaims(token, &claims, signingKey)
+	return err == nil && parsedToken.Valid && claims.VerifyAudience(shareAudience, true) &&
+		claims.Subject == linkID.String() && claims.Scope == scope
+}
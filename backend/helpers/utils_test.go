@@ -0,0 +1,76 @@
+package helpers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"cascloud/models"
+
+	"github.com/google/uuid"
+)
+
+// withSecret sets the secret for the rest of the test
+func withSecret(t *testing.T, value string) {
+	t.Helper()
+	old := secret
+	t.Cleanup(func() { secret = old })
+	secret = []byte(value)
+}
+
+func TestTokensNeedTheSecret(t *testing.T) {
+	withSecret(t, "")
+	if err := SetSecret(""); !errors.Is(err, ErrNoSecret) {
+		t.Errorf("setting an empty secret gave %v, want ErrNoSecret", err)
+	}
+	if _, err := GenerateJWT(models.User{ID: uuid.New()}); !errors.Is(err, ErrNoSecret) {
+		t.Errorf("signing without a secret gave %v, want ErrNoSecret", err)
+	}
+	if _, err := GenerateShareClaim(uuid.New(), "unlock", time.Hour); !errors.Is(err, ErrNoSecret) {
+		t.Errorf("signing a share claim without a secret gave %v, want ErrNoSecret", err)
+	}
+}
+
+func TestJWT(t *testing.T) {
+	withSecret(t, "the first secret of these tests")
+	user := models.User{ID: uuid.New(), Email: "alice@example.com"}
+	token, err := GenerateJWT(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := DecodeJWT(token); err != nil || id != user.ID.String() {
+		t.Errorf("the token decoded to %q (%v)", id, err)
+	}
+
+	withSecret(t, "the second secret of these tests")
+	if _, err := DecodeJWT(token); err == nil {
+		t.Error("a token signed with another secret was accepted")
+	}
+}
+
+func TestTokensKeepToTheirAudience(t *testing.T) {
+	withSecret(t, "the first secret of these tests")
+	linkID := uuid.New()
+	shareToken, err := GenerateShareClaim(linkID, "unlock", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyShareClaim(shareToken, linkID, "unlock") {
+		t.Fatal("the share claim was refused")
+	}
+	// the subject of a share claim is a uuid like the subject of a login
+	if _, err := DecodeJWT(shareToken); err == nil {
+		t.Error("a share claim was accepted as a login")
+	}
+
+	loginToken, err := GenerateJWT(models.User{ID: linkID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if VerifyShareClaim(loginToken, linkID, "") {
+		t.Error("a login was accepted as a share claim")
+	}
+	if VerifyShareClaim(shareToken, uuid.New(), "unlock") || VerifyShareClaim(shareToken, linkID, "download") {
+		t.Error("a share claim was accepted for another link or scope")
+	}
+}
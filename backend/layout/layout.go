@@ -0,0 +1,274 @@
+// Package layout places the items of a folder on its canvas. It only computes
+// coordinates, loading and saving the items is up to the caller.
+package layout
+
+import (
+	"errors"
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// the strategies an arrangement can use
+const (
+	// a grid in the current reading order, left to right and top to bottom
+	StrategyGrid = "grid"
+	// a grid ordered by SortBy
+	StrategySorted = "sorted"
+	// one block per file extension, folders first
+	StrategyClustered = "clustered"
+	// every item stays as close as possible to where it is without overlapping
+	StrategyPacked = "packed"
+)
+
+// what a sorted arrangement can be ordered by
+const (
+	SortByName = "name"
+	SortByDate = "date"
+	SortBySize = "size"
+	SortByType = "type"
+)
+
+// the size of an item on the canvas and the room left between items, matching
+// the cards the frontend draws
+const (
+	ItemWidth  = 110
+	ItemHeight = 130
+	Gap        = 20
+)
+
+// the most columns a grid may have
+const MaxColumns = 100
+
+var (
+	ErrUnknownStrategy = errors.New("unknown layout strategy")
+	ErrUnknownSort     = errors.New("unknown sort order")
+	ErrInvalidColumns  = errors.New("invalid number of columns")
+)
+
+// Item is a file or folder of the canvas, X and Y are its top left corner
+type Item struct {
+	ID        uuid.UUID
+	Folder    bool
+	Name      string
+	Size      int64
+	CreatedAt time.Time
+	X         float64
+	Y         float64
+}
+
+// Options choose how a folder is arranged, Columns is picked to make the grid
+// roughly square when it is zero
+type Options struct {
+	Strategy   string
+	SortBy     string
+	Descending bool
+	Columns    int
+}
+
+// Arrange returns the items with their new coordinates, in the order they were given
+func Arrange(items []Item, opts Options) ([]Item, error) {
+	if opts.Columns < 0 || opts.Columns > MaxColumns {
+		return nil, ErrInvalidColumns
+	}
+	arranged := make([]Item, len(items))
+	copy(arranged, items)
+	if len(arranged) == 0 {
+		return arranged, nil
+	}
+	columns := opts.Columns
+	if columns == 0 {
+		columns = int(math.Ceil(math.Sqrt(float64(len(arranged)))))
+	}
+
+	// the slots are filled in order, arranged keeps the order of the caller
+	order := make([]*Item, len(arranged))
+	for i := range arranged {
+		order[i] = &arranged[i]
+	}
+	switch opts.Strategy {
+	case StrategyGrid:
+		sort.SliceStable(order, func(i, j int) bool { return readingOrder(order[i], order[j]) })
+		placeGrid(order, columns, 0)
+	case StrategySorted:
+		less, err := sortOrder(opts.SortBy, opts.Descending)
+		if err != nil {
+			return nil, err
+		}
+		sort.SliceStable(order, func(i, j int) bool { return less(order[i], order[j]) })
+		placeGrid(order, columns, 0)
+	case StrategyClustered:
+		placeClusters(order, columns)
+	case StrategyPacked:
+		sort.SliceStable(order, func(i, j int) bool { return readingOrder(order[i], order[j]) })
+		placePacked(order)
+	default:
+		return nil, ErrUnknownStrategy
+	}
+	return arranged, nil
+}
+
+// cellX and cellY are the coordinates of a grid cell
+func cellX(column int) float64 {
+	return float64(Gap + column*(ItemWidth+Gap))
+}
+
+func cellY(row int) float64 {
+	return float64(Gap + row*(ItemHeight+Gap))
+}
+
+// placeGrid fills the grid row by row starting at firstRow and returns the
+// number of rows used
+func placeGrid(items []*Item, columns int, firstRow int) int {
+	for i, item := range items {
+		item.X = cellX(i % columns)
+		item.Y = cellY(firstRow + i/columns)
+	}
+	return (len(items) + columns - 1) / columns
+}
+
+// placeClusters puts folders first and then the files of each extension in a
+// block of their own, blocks are separated by an empty row
+func placeClusters(items []*Item, columns int) {
+	clusters := make(map[string][]*Item)
+	var keys []string
+	for _, item := range items {
+		key := clusterKey(item)
+		if _, ok := clusters[key]; !ok {
+			keys = append(keys, key)
+		}
+		clusters[key] = append(clusters[key], item)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		// folders have the empty key, files without an extension come last
+		if (keys[i] == ".") != (keys[j] == ".") {
+			return keys[j] == "."
+		}
+		return keys[i] < keys[j]
+	})
+
+	row := 0
+	for _, key := range keys {
+		cluster := clusters[key]
+		sort.SliceStable(cluster, func(i, j int) bool { return byName(cluster[i], cluster[j]) })
+		row += placeGrid(cluster, columns, row) + 1
+	}
+}
+
+// clusterKey is the lower case extension of a file, "." for files without one
+// and empty for folders
+func clusterKey(item *Item) string {
+	if item.Folder {
+		return ""
+	}
+	ext := strings.ToLower(path.Ext(item.Name))
+	if ext == "" || ext == item.Name {
+		return "."
+	}
+	return ext
+}
+
+// placePacked snaps every item to the free grid cell closest to where it is,
+// items earlier in the reading order get their cell first. Nothing is placed
+// left of or above the first cell.
+func placePacked(items []*Item) {
+	type cell struct{ column, row int }
+	taken := make(map[cell]bool, len(items))
+	for _, item := range items {
+		wantX := math.Max(0, (item.X-Gap)/(ItemWidth+Gap))
+		wantY := math.Max(0, (item.Y-Gap)/(ItemHeight+Gap))
+		center := cell{int(math.Round(wantX)), int(math.Round(wantY))}
+
+		// search rings around the closest cell, a free one turns up within len(items) rings
+		best, found := cell{}, false
+		bestDistance := math.Inf(1)
+		for ring := 0; !found; ring++ {
+			for column := center.column - ring; column <= center.column+ring; column++ {
+				for row := center.row - ring; row <= center.row+ring; row++ {
+					onRing := column == center.column-ring || column == center.column+ring ||
+						row == center.row-ring || row == center.row+ring
+					if !onRing || column < 0 || row < 0 || taken[cell{column, row}] {
+						continue
+					}
+					distance := math.Hypot(float64(column)-wantX, float64(row)-wantY)
+					if distance < bestDistance {
+						best, bestDistance, found = cell{column, row}, distance, true
+					}
+				}
+			}
+		}
+		taken[best] = true
+		item.X = cellX(best.column)
+		item.Y = cellY(best.row)
+	}
+}
+
+// readingOrder compares positions top to bottom, then left to right
+func readingOrder(a, b *Item) bool {
+	if a.Y != b.Y {
+		return a.Y < b.Y
+	}
+	if a.X != b.X {
+		return a.X < b.X
+	}
+	return byName(a, b)
+}
+
+func byName(a, b *Item) bool {
+	nameA, nameB := strings.ToLower(a.Name), strings.ToLower(b.Name)
+	if nameA != nameB {
+		return nameA < nameB
+	}
+	return a.ID.String() < b.ID.String()
+}
+
+// sortOrder returns the comparison of a sorted arrangement, ties are broken by
+// name in ascending order so the result does not depend on the input order
+func sortOrder(sortBy string, descending bool) (func(a, b *Item) bool, error) {
+	var compare func(a, b *Item) int
+	switch sortBy {
+	case SortByName, "":
+		compare = func(a, b *Item) int {
+			return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+		}
+	case SortByDate:
+		compare = func(a, b *Item) int { return a.CreatedAt.Compare(b.CreatedAt) }
+	case SortBySize:
+		compare = func(a, b *Item) int {
+			switch {
+			case a.Size < b.Size:
+				return -1
+			case a.Size > b.Size:
+				return 1
+			}
+			return 0
+		}
+	case SortByType:
+		compare = func(a, b *Item) int {
+			keyA, keyB := clusterKey(a), clusterKey(b)
+			if (keyA == ".") != (keyB == ".") {
+				if keyA == "." {
+					return 1
+				}
+				return -1
+			}
+			return strings.Compare(keyA, keyB)
+		}
+	default:
+		return nil, ErrUnknownSort
+	}
+	return func(a, b *Item) bool {
+		result := compare(a, b)
+		if descending {
+			result = -result
+		}
+		if result != 0 {
+			return result < 0
+		}
+		return byName(a, b)
+	}, nil
+}
@@ -3,14 +3,15 @@ package main
 import (
 	"cascloud/config"
 	"cascloud/db"
+	"cascloud/events"
+	"cascloud/helpers"
 	"cascloud/routes"
 	"cascloud/storage"
 	"context"
 	"fmt"
-	"net/http"
+	"os"
+	"time"
 
-	s3cfg "github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/rs/zerolog/log"
@@ -29,6 +30,17 @@ func main() {
 		fmt.Println("Error loading config:", err)
 		panic(err)
 	}
+	if err := helpers.SetSecret(cfg.JWTSecret); err != nil {
+		panic(err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(cfg, os.Args[2:]); err != nil {
+			fmt.Println("Error migrating database:", err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	dbInstance, err := db.DB(cfg)
 	if err != nil {
@@ -42,35 +54,26 @@ func main() {
 	}
 	defer sqlDB.Close()
 
-	s3config, err := s3cfg.LoadDefaultConfig(context.TODO(), s3cfg.WithRegion(cfg.S3Region))
+	storageClient, err := storage.Storage(context.TODO(), cfg)
 	if err != nil {
-		log.Error().Err(err).Msg("Error loading S3 config")
+		log.Error().Err(err).Msg("Error creating storage client")
 		return
 	}
 
-	s3Service := storage.S3Client{
-		Client:     s3.NewFromConfig(s3config),
-		BucketName: cfg.S3BucketName,
-	}
-
 	handler := &routes.HandlerClient{
 		DBClient: db.NewClient(dbInstance),
-		S3Client: &s3Service,
+		S3Client: storageClient,
+		Events:   events.NewHub(),
 	}
 
-	// Define routes and handlers here
-	e.GET("/", func(c echo.Context) error {
-		return c.String(http.StatusOK, "Hello, World!")
-	})
-	e.POST("/users", handler.RegisterUser)
-	e.POST("/login", handler.LoginUser)
-	e.POST("/upload", handler.UploadFile)
-	e.POST("/create-folder", handler.CreateFolder)
-	e.GET("/get-directory", handler.GetDirectory)
-	e.GET("/get-workspaces", handler.GetUsersWorkspaces)
-	e.GET("/get-files", handler.GetFilesByFolderID)
-	e.GET("/download", handler.DownloadFile)
-	e.GET("/get-user", handler.GetUser)
+	handler.RegisterRoutes(e)
+
+	// purge the trash, share downloads and abandoned uploads in the background
+	purgeCtx, stopPurge := context.WithCancel(context.Background())
+	defer stopPurge()
+	go handler.StartTrashPurger(purgeCtx, time.Duration(cfg.TrashRetentionDays)*24*time.Hour, time.Hour)
+	go handler.StartShareDownloadPurger(purgeCtx, time.Hour)
+	go handler.StartUploadPurger(purgeCtx, 24*time.Hour, time.Hour)
 
 	// Start the Echo server
 	e.Start(":8080")
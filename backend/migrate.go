@@ -0,0 +1,64 @@
+package main
+
+import (
+	"cascloud/config"
+	"cascloud/db"
+	"cascloud/migrations"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+const migrateUsage = "usage: app migrate up | down | status | to <version>"
+
+// a function to run the migrate command, it changes the schema and nothing
+// else, the server also applies pending migrations when it starts
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	dbInstance, err := db.Open(cfg)
+	if err != nil {
+		return err
+	}
+	sqlDB, err := dbInstance.DB()
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	migrator, err := migrations.New(dbInstance)
+	if err != nil {
+		return err
+	}
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		applied, err := migrator.Up()
+		fmt.Printf("applied %d migrations\n", applied)
+		return err
+	case args[0] == "down" && len(args) == 1:
+		return migrator.Down()
+	case args[0] == "to" && len(args) == 2:
+		version, err := strconv.Atoi(args[1])
+		if err != nil || version < 0 {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		ran, err := migrator.To(version)
+		fmt.Printf("ran %d migrations\n", ran)
+		return err
+	case args[0] == "status" && len(args) == 1:
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d %-24s %s\n", status.Version, status.Name, applied)
+		}
+		return nil
+	}
+	return errors.New(migrateUsage)
+}
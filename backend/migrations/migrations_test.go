@@ -0,0 +1,260 @@
+package migrations_test
+
+import (
+	"cascloud/config"
+	"cascloud/db"
+	"cascloud/migrations"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// openSQLite opens an empty in-memory SQLite database for the rest of the test
+func openSQLite(t *testing.T) *gorm.DB {
+	t.Helper()
+	path := fmt.Sprintf("file:%s?mode=memory&cache=shared", uuid.NewString())
+	gormDB, err := db.Open(&config.Config{DBDriver: config.DBDriverSQLite, DBPath: path})
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	sqlDB, err := gormDB.DB()
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return gormDB
+}
+
+func TestDialectsShareVersions(t *testing.T) {
+	postgres, err := migrations.Load("postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlite, err := migrations.Load("sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(postgres) != len(sqlite) {
+		t.Fatalf("postgres has %d migrations, sqlite %d", len(postgres), len(sqlite))
+	}
+	for i := range postgres {
+		if postgres[i].Version != sqlite[i].Version || postgres[i].Name != sqlite[i].Name {
+			t.Errorf("migration %d is %04d_%s on postgres and %04d_%s on sqlite", i,
+				postgres[i].Version, postgres[i].Name, sqlite[i].Version, sqlite[i].Name)
+		}
+	}
+	if _, err := migrations.Load("oracle"); err != migrations.ErrNoMigrations {
+		t.Errorf("an unknown dialect gave %v", err)
+	}
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	gormDB := openSQLite(t)
+	migrator, err := migrations.New(gormDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := migrations.Load("sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := all[len(all)-1].Version
+
+	applied, err := migrator.Up()
+	if err != nil || applied != len(all) {
+		t.Fatalf("up applied %d of %d migrations: %v", applied, len(all), err)
+	}
+	if version, err := migrator.Version(); err != nil || version != latest {
+		t.Errorf("version is %d after up, want %d (%v)", version, latest, err)
+	}
+	if applied, err := migrator.Up(); err != nil || applied != 0 {
+		t.Errorf("a second up applied %d migrations: %v", applied, err)
+	}
+
+	if err := migrator.Down(); err != nil {
+		t.Fatal(err)
+	}
+	statuses, err := migrator.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if pending := status.AppliedAt == nil; pending != (status.Version == latest) {
+			t.Errorf("after down migration %d is pending: %v", status.Version, pending)
+		}
+	}
+
+	if _, err := migrator.To(0); err != nil {
+		t.Fatal(err)
+	}
+	if gormDB.Migrator().HasTable("files") {
+		t.Error("the files table is left after reverting every migration")
+	}
+	if _, err := migrator.To(latest + 1); err != migrations.ErrUnknownVersion {
+		t.Errorf("migrating to an unknown version gave %v", err)
+	}
+	if applied, err := migrator.Up(); err != nil || applied != len(all) {
+		t.Errorf("up after reverting applied %d of %d migrations: %v", applied, len(all), err)
+	}
+}
+
+func TestOwnerCollaborationsBackfill(t *testing.T) {
+	gormDB := openSQLite(t)
+	migrator, err := migrations.New(gormDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.To(3); err != nil {
+		t.Fatal(err)
+	}
+	// a workspace from before its owner got a collaboration
+	ownerID, workspaceID := uuid.NewString(), uuid.NewString()
+	if err := gormDB.Exec(`INSERT INTO users (id, first_name, last_name, email, user_name, password_hash)
+		VALUES (?, 'alice', 'a', 'alice@example.com', 'alice', 'x')`, ownerID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := gormDB.Exec(`INSERT INTO workspaces (id, home_folder_id, name, owner_id)
+		VALUES (?, ?, 'home', ?)`, workspaceID, uuid.NewString(), ownerID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.To(4); err != nil {
+		t.Fatal(err)
+	}
+
+	role, err := db.NewClient(gormDB).GetWorkspaceRole(ownerID, workspaceID)
+	if err != nil || role == nil || role.Name != "owner" {
+		t.Fatalf("the owner has role %+v after the backfill (%v)", role, err)
+	}
+	var ids []string
+	if err := gormDB.Raw("SELECT id FROM collaborations").Scan(&ids).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 {
+		t.Fatalf("there are %d collaborations, want 1", len(ids))
+	}
+	if _, err := uuid.Parse(ids[0]); err != nil {
+		t.Errorf("the backfilled collaboration has id %q: %v", ids[0], err)
+	}
+}
+
+func TestUniqueCollaborations(t *testing.T) {
+	gormDB := openSQLite(t)
+	migrator, err := migrations.New(gormDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.To(4); err != nil {
+		t.Fatal(err)
+	}
+	userID, workspaceID, editorID := uuid.NewString(), uuid.NewString(), uuid.NewString()
+	statements := []string{
+		`INSERT INTO users (id, first_name, last_name, email, user_name, password_hash) VALUES (@user, 'bob', 'b', 'bob@example.com', 'bob', 'x')`,
+		`INSERT INTO workspaces (id, home_folder_id, name, owner_id) VALUES (@workspace, @workspace, 'home', @user)`,
+		`INSERT INTO roles (id, name) VALUES (@editor, 'editor')`,
+		// the owner joined last, the oldest collaborations are not the ones to keep
+		`INSERT INTO collaborations (id, user_id, role_id, workspace_id, created_at) SELECT lower(hex(randomblob(16))), @user, @editor, @workspace, '2000-01-01'`,
+		`INSERT INTO collaborations (id, user_id, role_id, workspace_id, created_at) SELECT lower(hex(randomblob(16))), @user, @editor, @workspace, '2000-01-02'`,
+		`INSERT INTO collaborations (id, user_id, role_id, workspace_id, created_at) SELECT lower(hex(randomblob(16))), @user, id, @workspace, '2000-01-03' FROM roles WHERE name = 'owner'`,
+	}
+	args := map[string]interface{}{"user": userID, "workspace": workspaceID, "editor": editorID}
+	for _, statement := range statements {
+		if err := gormDB.Exec(statement, args).Error; err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+	if _, err := migrator.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	role, err := db.NewClient(gormDB).GetWorkspaceRole(userID, workspaceID)
+	if err != nil || role == nil || role.Name != "owner" {
+		t.Errorf("the member kept role %+v (%v), want owner", role, err)
+	}
+	var count int64
+	if err := gormDB.Table("collaborations").Count(&count).Error; err != nil || count != 1 {
+		t.Errorf("%d collaborations are left (%v), want 1", count, err)
+	}
+	err = gormDB.Exec(statements[3], args).Error
+	if !errors.Is(err, gorm.ErrDuplicatedKey) {
+		t.Errorf("a second collaboration gave %v, want ErrDuplicatedKey", err)
+	}
+}
+
+func TestUniqueNames(t *testing.T) {
+	gormDB := openSQLite(t)
+	migrator, err := migrations.New(gormDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.To(8); err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]interface{}{}
+	for _, name := range []string{"user", "workspace", "home", "docs", "docs2", "sub", "notes", "notes2", "clash", "trashed"} {
+		ids[name] = uuid.NewString()
+	}
+	statements := []string{
+		`INSERT INTO users (id, first_name, last_name, email, user_name, password_hash) VALUES (@user, 'alice', 'a', 'alice@example.com', 'alice', 'x')`,
+		`INSERT INTO workspaces (id, home_folder_id, name, owner_id) VALUES (@workspace, @home, 'home', @user)`,
+		`INSERT INTO folders (id, name, workspace_id, x, y, path, created_at) VALUES (@home, 'home', @workspace, 0, 0, 'home', '2000-01-01')`,
+		// two folders named docs, the younger one and what is inside it get a new name
+		`INSERT INTO folders (id, name, workspace_id, parent_id, x, y, path, created_at) VALUES (@docs, 'docs', @workspace, @home, 0, 0, 'home/docs', '2000-01-01')`,
+		`INSERT INTO folders (id, name, workspace_id, parent_id, x, y, path, created_at) VALUES (@docs2, 'docs', @workspace, @home, 0, 0, 'home/docs', '2000-01-02')`,
+		`INSERT INTO folders (id, name, workspace_id, parent_id, x, y, path, created_at) VALUES (@sub, 'sub', @workspace, @docs2, 0, 0, 'home/docs/sub', '2000-01-02')`,
+		`INSERT INTO files (id, name, path, size, x, y, folder_id, created_at) VALUES (@notes, 'notes.txt', 'home/docs/sub/notes.txt', 1, 0, 0, @sub, '2000-01-01')`,
+		`INSERT INTO files (id, name, path, size, x, y, folder_id, created_at) VALUES (@notes2, 'notes.txt', 'home/docs/sub/notes.txt', 1, 0, 0, @sub, '2000-01-02')`,
+		// a file with the name of a folder next to it
+		`INSERT INTO files (id, name, path, size, x, y, folder_id, created_at) VALUES (@clash, 'docs', 'home/docs', 1, 0, 0, @home, '2000-01-01')`,
+		// items in the trash keep their names
+		`INSERT INTO files (id, name, path, size, x, y, folder_id, created_at, deleted_at) VALUES (@trashed, 'notes.txt', 'home/docs/sub/notes.txt', 1, 0, 0, @sub, '2000-01-03', '2000-01-04')`,
+	}
+	for _, statement := range statements {
+		if err := gormDB.Exec(statement, ids).Error; err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+	if _, err := migrator.To(9); err != nil {
+		t.Fatal(err)
+	}
+
+	// renamed items get the start of their id appended
+	renamed := func(name string, id string) string { return name + " (" + ids[id].(string)[:8] + ")" }
+	docs2 := "home/" + renamed("docs", "docs2")
+	rows := []struct {
+		table string
+		id    string
+		name  string
+		path  string
+	}{
+		{"folders", "docs", "docs", "home/docs"},
+		{"folders", "docs2", renamed("docs", "docs2"), docs2},
+		{"folders", "sub", "sub", docs2 + "/sub"},
+		{"files", "notes", "notes.txt", docs2 + "/sub/notes.txt"},
+		{"files", "notes2", renamed("notes.txt", "notes2"), docs2 + "/sub/" + renamed("notes.txt", "notes2")},
+		{"files", "clash", renamed("docs", "clash"), "home/" + renamed("docs", "clash")},
+		{"files", "trashed", "notes.txt", docs2 + "/sub/notes.txt"},
+	}
+	for _, want := range rows {
+		var got struct{ Name, Path string }
+		if err := gormDB.Table(want.table).Select("name, path").Where("id = ?", ids[want.id]).Scan(&got).Error; err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != want.name || got.Path != want.path {
+			t.Errorf("%s %s is %q at %q, want %q at %q", want.table, want.id, got.Name, got.Path, want.name, want.path)
+		}
+	}
+
+	err = gormDB.Exec(`INSERT INTO folders (id, name, workspace_id, parent_id, x, y, path) VALUES (?, 'docs', ?, ?, 0, 0, 'home/docs')`,
+		uuid.NewString(), ids["workspace"], ids["home"]).Error
+	if !errors.Is(err, gorm.ErrDuplicatedKey) {
+		t.Errorf("a second folder named docs gave %v, want ErrDuplicatedKey", err)
+	}
+	err = gormDB.Exec(`INSERT INTO files (id, name, path, size, x, y, folder_id, deleted_at) VALUES (?, 'notes.txt', 'x', 1, 0, 0, ?, '2000-01-05')`,
+		uuid.NewString(), ids["sub"]).Error
+	if err != nil {
+		t.Errorf("a second trashed notes.txt gave %v", err)
+	}
+}
@@ -0,0 +1,16 @@
+DROP TABLE IF EXISTS connectors;
+DROP TABLE IF EXISTS canvas_items;
+DROP TABLE IF EXISTS layout_changes;
+DROP TABLE IF EXISTS layout_steps;
+DROP TABLE IF EXISTS share_links;
+DROP TABLE IF EXISTS invitations;
+DROP TABLE IF EXISTS file_versions;
+DROP TABLE IF EXISTS trash_items;
+DROP TABLE IF EXISTS upload_parts;
+DROP TABLE IF EXISTS upload_sessions;
+DROP TABLE IF EXISTS folders;
+DROP TABLE IF EXISTS files;
+DROP TABLE IF EXISTS roles;
+DROP TABLE IF EXISTS collaborations;
+DROP TABLE IF EXISTS workspaces;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,240 @@
+-- The schema as AutoMigrate created it, so a database that was migrated by
+-- the models before picks up from here without changes.
+
+CREATE TABLE IF NOT EXISTS users (
+	id uuid DEFAULT gen_random_uuid(),
+	first_name text NOT NULL,
+	last_name text NOT NULL,
+	email text NOT NULL,
+	user_name text NOT NULL,
+	password_hash text NOT NULL,
+	workspaces uuid[],
+	created_at timestamptz,
+	PRIMARY KEY (id),
+	CONSTRAINT uni_users_email UNIQUE (email),
+	CONSTRAINT uni_users_user_name UNIQUE (user_name)
+);
+
+CREATE TABLE IF NOT EXISTS workspaces (
+	id uuid,
+	home_folder_id text NOT NULL,
+	name text NOT NULL,
+	owner_id text NOT NULL,
+	users uuid[],
+	created_at timestamptz,
+	PRIMARY KEY (id)
+);
+
+CREATE TABLE IF NOT EXISTS collaborations (
+	id uuid DEFAULT gen_random_uuid(),
+	user_id text NOT NULL,
+	role_id text,
+	workspace_id text,
+	created_at timestamptz,
+	PRIMARY KEY (id)
+);
+
+CREATE TABLE IF NOT EXISTS roles (
+	id uuid DEFAULT gen_random_uuid(),
+	name text NOT NULL,
+	created_at timestamptz,
+	PRIMARY KEY (id),
+	CONSTRAINT uni_roles_name UNIQUE (name)
+);
+
+CREATE TABLE IF NOT EXISTS files (
+	id uuid DEFAULT gen_random_uuid(),
+	name text NOT NULL,
+	path text NOT NULL,
+	size bigint NOT NULL,
+	x decimal NOT NULL,
+	y decimal NOT NULL,
+	folder_id text NOT NULL,
+	created_at timestamptz,
+	version bigint NOT NULL DEFAULT 1,
+	deleted_at timestamptz,
+	trash_id uuid,
+	PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_files_position ON files (folder_id, x, y);
+CREATE INDEX IF NOT EXISTS idx_files_deleted_at ON files (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_files_trash_id ON files (trash_id);
+
+CREATE TABLE IF NOT EXISTS folders (
+	id uuid DEFAULT gen_random_uuid(),
+	name text NOT NULL,
+	workspace_id text NOT NULL,
+	parent_id text NOT NULL,
+	x decimal NOT NULL,
+	y decimal NOT NULL,
+	created_at timestamptz,
+	children uuid[],
+	path text NOT NULL,
+	deleted_at timestamptz,
+	trash_id uuid,
+	PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_folders_position ON folders (parent_id, x, y);
+CREATE INDEX IF NOT EXISTS idx_folders_deleted_at ON folders (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_folders_trash_id ON folders (trash_id);
+
+CREATE TABLE IF NOT EXISTS upload_sessions (
+	id uuid DEFAULT gen_random_uuid(),
+	folder_id text NOT NULL,
+	name text NOT NULL,
+	path text NOT NULL,
+	size bigint NOT NULL,
+	upload_offset bigint NOT NULL DEFAULT 0,
+	x decimal NOT NULL,
+	y decimal NOT NULL,
+	storage_upload_id text NOT NULL,
+	hash_state bytea,
+	status text NOT NULL,
+	created_at timestamptz,
+	updated_at timestamptz,
+	PRIMARY KEY (id)
+);
+
+CREATE TABLE IF NOT EXISTS upload_parts (
+	id uuid DEFAULT gen_random_uuid(),
+	upload_session_id text NOT NULL,
+	part_number integer NOT NULL,
+	etag text NOT NULL,
+	size bigint NOT NULL,
+	created_at timestamptz,
+	PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_upload_part_number ON upload_parts (upload_session_id, part_number);
+
+CREATE TABLE IF NOT EXISTS trash_items (
+	id uuid DEFAULT gen_random_uuid(),
+	workspace_id text NOT NULL,
+	item_type text NOT NULL,
+	item_id text NOT NULL,
+	name text NOT NULL,
+	path text NOT NULL,
+	original_parent_id text NOT NULL,
+	deleted_by text,
+	deleted_at timestamptz,
+	PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_trash_items_workspace_id ON trash_items (workspace_id);
+CREATE INDEX IF NOT EXISTS idx_trash_items_deleted_at ON trash_items (deleted_at);
+
+CREATE TABLE IF NOT EXISTS file_versions (
+	id uuid DEFAULT gen_random_uuid(),
+	file_id text NOT NULL,
+	version bigint NOT NULL,
+	storage_key text,
+	size bigint NOT NULL,
+	checksum text,
+	author_id text,
+	created_at timestamptz,
+	PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_file_version ON file_versions (file_id, version);
+
+CREATE TABLE IF NOT EXISTS invitations (
+	id uuid DEFAULT gen_random_uuid(),
+	workspace_id text NOT NULL,
+	email text NOT NULL,
+	role text NOT NULL,
+	invited_by text NOT NULL,
+	status text NOT NULL,
+	created_at timestamptz,
+	updated_at timestamptz,
+	PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_invitations_workspace_id ON invitations (workspace_id);
+CREATE INDEX IF NOT EXISTS idx_invitations_email ON invitations (email);
+
+CREATE TABLE IF NOT EXISTS share_links (
+	id uuid DEFAULT gen_random_uuid(),
+	token_hash text NOT NULL,
+	workspace_id text NOT NULL,
+	item_type text NOT NULL,
+	item_id text NOT NULL,
+	created_by text,
+	expires_at timestamptz,
+	password_hash text,
+	password_protected boolean NOT NULL DEFAULT false,
+	max_downloads bigint,
+	download_count bigint NOT NULL DEFAULT 0,
+	created_at timestamptz,
+	PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_share_links_token_hash ON share_links (token_hash);
+CREATE INDEX IF NOT EXISTS idx_share_links_workspace_id ON share_links (workspace_id);
+CREATE INDEX IF NOT EXISTS idx_share_links_item_id ON share_links (item_id);
+
+CREATE TABLE IF NOT EXISTS layout_steps (
+	id uuid DEFAULT gen_random_uuid(),
+	folder_id text NOT NULL,
+	actor_id text,
+	action text NOT NULL,
+	undone boolean NOT NULL DEFAULT false,
+	created_at timestamptz,
+	PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_layout_steps_folder_id ON layout_steps (folder_id);
+CREATE INDEX IF NOT EXISTS idx_layout_steps_created_at ON layout_steps (created_at);
+
+-- step_id takes the type of layout_steps.id, AutoMigrate also added the
+-- constraint for the relation
+CREATE TABLE IF NOT EXISTS layout_changes (
+	id uuid DEFAULT gen_random_uuid(),
+	step_id uuid NOT NULL,
+	item_type text NOT NULL,
+	item_id text NOT NULL,
+	from_folder_id text NOT NULL,
+	to_folder_id text NOT NULL,
+	from_x decimal,
+	from_y decimal,
+	to_x decimal,
+	to_y decimal,
+	PRIMARY KEY (id),
+	CONSTRAINT fk_layout_steps_changes FOREIGN KEY (step_id) REFERENCES layout_steps (id)
+);
+CREATE INDEX IF NOT EXISTS idx_layout_changes_step_id ON layout_changes (step_id);
+CREATE INDEX IF NOT EXISTS idx_layout_changes_item_id ON layout_changes (item_id);
+CREATE INDEX IF NOT EXISTS idx_layout_changes_from_folder_id ON layout_changes (from_folder_id);
+CREATE INDEX IF NOT EXISTS idx_layout_changes_to_folder_id ON layout_changes (to_folder_id);
+
+CREATE TABLE IF NOT EXISTS canvas_items (
+	id uuid DEFAULT gen_random_uuid(),
+	folder_id text NOT NULL,
+	kind text NOT NULL,
+	x decimal NOT NULL,
+	y decimal NOT NULL,
+	width decimal NOT NULL,
+	height decimal NOT NULL,
+	title text,
+	text text,
+	format text,
+	url text,
+	color text,
+	created_by text,
+	created_at timestamptz,
+	updated_at timestamptz,
+	PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_canvas_items_position ON canvas_items (folder_id, x, y);
+
+CREATE TABLE IF NOT EXISTS connectors (
+	id uuid DEFAULT gen_random_uuid(),
+	folder_id text NOT NULL,
+	from_type text NOT NULL,
+	from_id text NOT NULL,
+	to_type text NOT NULL,
+	to_id text NOT NULL,
+	label text,
+	style text NOT NULL,
+	direction text NOT NULL,
+	created_by text,
+	created_at timestamptz,
+	updated_at timestamptz,
+	PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_connectors_folder_id ON connectors (folder_id);
+CREATE INDEX IF NOT EXISTS idx_connectors_from_id ON connectors (from_id);
+CREATE INDEX IF NOT EXISTS idx_connectors_to_id ON connectors (to_id);
@@ -0,0 +1,75 @@
+DROP INDEX IF EXISTS idx_trash_items_item_id;
+DROP INDEX IF EXISTS idx_upload_sessions_folder_id;
+DROP INDEX IF EXISTS idx_collaborations_workspace_id;
+DROP INDEX IF EXISTS idx_collaborations_user_id;
+DROP INDEX IF EXISTS idx_folders_workspace_id;
+
+ALTER TABLE layout_changes
+	DROP CONSTRAINT fk_layout_steps_changes,
+	ADD CONSTRAINT fk_layout_steps_changes FOREIGN KEY (step_id) REFERENCES layout_steps (id);
+ALTER TABLE upload_parts DROP CONSTRAINT fk_upload_parts_session;
+ALTER TABLE file_versions DROP CONSTRAINT fk_file_versions_file;
+ALTER TABLE trash_items DROP CONSTRAINT fk_trash_items_workspace;
+ALTER TABLE share_links DROP CONSTRAINT fk_share_links_workspace;
+ALTER TABLE invitations DROP CONSTRAINT fk_invitations_workspace;
+ALTER TABLE collaborations
+	DROP CONSTRAINT fk_collaborations_user,
+	DROP CONSTRAINT fk_collaborations_role,
+	DROP CONSTRAINT fk_collaborations_workspace;
+ALTER TABLE workspaces DROP CONSTRAINT fk_workspaces_owner;
+ALTER TABLE connectors DROP CONSTRAINT fk_connectors_folder;
+ALTER TABLE canvas_items DROP CONSTRAINT fk_canvas_items_folder;
+ALTER TABLE files DROP CONSTRAINT fk_files_folder;
+ALTER TABLE folders
+	DROP CONSTRAINT fk_folders_parent,
+	DROP CONSTRAINT fk_folders_workspace;
+
+UPDATE folders SET parent_id = '00000000-0000-0000-0000-000000000000' WHERE parent_id IS NULL;
+ALTER TABLE folders ALTER COLUMN parent_id SET NOT NULL;
+
+ALTER TABLE connectors
+	ALTER COLUMN folder_id TYPE text,
+	ALTER COLUMN from_id TYPE text,
+	ALTER COLUMN to_id TYPE text,
+	ALTER COLUMN created_by TYPE text;
+ALTER TABLE canvas_items
+	ALTER COLUMN folder_id TYPE text,
+	ALTER COLUMN created_by TYPE text;
+ALTER TABLE layout_changes
+	ALTER COLUMN item_id TYPE text,
+	ALTER COLUMN from_folder_id TYPE text,
+	ALTER COLUMN to_folder_id TYPE text;
+ALTER TABLE layout_steps
+	ALTER COLUMN folder_id TYPE text,
+	ALTER COLUMN actor_id TYPE text;
+ALTER TABLE share_links
+	ALTER COLUMN workspace_id TYPE text,
+	ALTER COLUMN item_id TYPE text,
+	ALTER COLUMN created_by TYPE text;
+ALTER TABLE invitations
+	ALTER COLUMN workspace_id TYPE text,
+	ALTER COLUMN invited_by TYPE text;
+ALTER TABLE file_versions
+	ALTER COLUMN file_id TYPE text,
+	ALTER COLUMN author_id TYPE text;
+ALTER TABLE trash_items
+	ALTER COLUMN workspace_id TYPE text,
+	ALTER COLUMN item_id TYPE text,
+	ALTER COLUMN original_parent_id TYPE text,
+	ALTER COLUMN deleted_by TYPE text;
+ALTER TABLE upload_parts
+	ALTER COLUMN upload_session_id TYPE text;
+ALTER TABLE upload_sessions
+	ALTER COLUMN folder_id TYPE text;
+ALTER TABLE folders
+	ALTER COLUMN workspace_id TYPE text,
+	ALTER COLUMN parent_id TYPE text;
+ALTER TABLE files
+	ALTER COLUMN folder_id TYPE text;
+ALTER TABLE collaborations
+	ALTER COLUMN user_id TYPE text,
+	ALTER COLUMN role_id TYPE text,
+	ALTER COLUMN workspace_id TYPE text;
+ALTER TABLE workspaces
+	ALTER COLUMN home_folder_id TYPE text,
+	ALTER COLUMN owner_id TYPE text;
@@ -0,0 +1,91 @@
+-- AutoMigrate stored the ids of other rows as text, they have to be uuids
+-- like the ids they point to before they can reference them.
+ALTER TABLE workspaces
+	ALTER COLUMN home_folder_id TYPE uuid USING home_folder_id::uuid,
+	ALTER COLUMN owner_id TYPE uuid USING owner_id::uuid;
+ALTER TABLE collaborations
+	ALTER COLUMN user_id TYPE uuid USING user_id::uuid,
+	ALTER COLUMN role_id TYPE uuid USING NULLIF(role_id, '')::uuid,
+	ALTER COLUMN workspace_id TYPE uuid USING NULLIF(workspace_id, '')::uuid;
+ALTER TABLE files
+	ALTER COLUMN folder_id TYPE uuid USING folder_id::uuid;
+ALTER TABLE folders
+	ALTER COLUMN workspace_id TYPE uuid USING workspace_id::uuid,
+	ALTER COLUMN parent_id TYPE uuid USING parent_id::uuid;
+ALTER TABLE upload_sessions
+	ALTER COLUMN folder_id TYPE uuid USING folder_id::uuid;
+ALTER TABLE upload_parts
+	ALTER COLUMN upload_session_id TYPE uuid USING upload_session_id::uuid;
+ALTER TABLE trash_items
+	ALTER COLUMN workspace_id TYPE uuid USING workspace_id::uuid,
+	ALTER COLUMN item_id TYPE uuid USING item_id::uuid,
+	ALTER COLUMN original_parent_id TYPE uuid USING original_parent_id::uuid,
+	ALTER COLUMN deleted_by TYPE uuid USING NULLIF(deleted_by, '')::uuid;
+ALTER TABLE file_versions
+	ALTER COLUMN file_id TYPE uuid USING file_id::uuid,
+	ALTER COLUMN author_id TYPE uuid USING NULLIF(author_id, '')::uuid;
+ALTER TABLE invitations
+	ALTER COLUMN workspace_id TYPE uuid USING workspace_id::uuid,
+	ALTER COLUMN invited_by TYPE uuid USING invited_by::uuid;
+ALTER TABLE share_links
+	ALTER COLUMN workspace_id TYPE uuid USING workspace_id::uuid,
+	ALTER COLUMN item_id TYPE uuid USING item_id::uuid,
+	ALTER COLUMN created_by TYPE uuid USING NULLIF(created_by, '')::uuid;
+ALTER TABLE layout_steps
+	ALTER COLUMN folder_id TYPE uuid USING folder_id::uuid,
+	ALTER COLUMN actor_id TYPE uuid USING NULLIF(actor_id, '')::uuid;
+ALTER TABLE layout_changes
+	ALTER COLUMN item_id TYPE uuid USING item_id::uuid,
+	ALTER COLUMN from_folder_id TYPE uuid USING from_folder_id::uuid,
+	ALTER COLUMN to_folder_id TYPE uuid USING to_folder_id::uuid;
+ALTER TABLE canvas_items
+	ALTER COLUMN folder_id TYPE uuid USING folder_id::uuid,
+	ALTER COLUMN created_by TYPE uuid USING NULLIF(created_by, '')::uuid;
+ALTER TABLE connectors
+	ALTER COLUMN folder_id TYPE uuid USING folder_id::uuid,
+	ALTER COLUMN from_id TYPE uuid USING from_id::uuid,
+	ALTER COLUMN to_id TYPE uuid USING to_id::uuid,
+	ALTER COLUMN created_by TYPE uuid USING NULLIF(created_by, '')::uuid;
+
+-- home folders have no parent, that used to be the nil uuid which no folder has
+ALTER TABLE folders ALTER COLUMN parent_id DROP NOT NULL;
+UPDATE folders SET parent_id = NULL WHERE parent_id = '00000000-0000-0000-0000-000000000000';
+
+-- the rows of a folder go away with it when the trash is purged, which
+-- deletes them first. Upload sessions and layout steps are kept on purpose
+-- and only point at their folder by id.
+ALTER TABLE folders
+	ADD CONSTRAINT fk_folders_parent FOREIGN KEY (parent_id) REFERENCES folders (id),
+	ADD CONSTRAINT fk_folders_workspace FOREIGN KEY (workspace_id) REFERENCES workspaces (id);
+ALTER TABLE files
+	ADD CONSTRAINT fk_files_folder FOREIGN KEY (folder_id) REFERENCES folders (id);
+ALTER TABLE canvas_items
+	ADD CONSTRAINT fk_canvas_items_folder FOREIGN KEY (folder_id) REFERENCES folders (id);
+ALTER TABLE connectors
+	ADD CONSTRAINT fk_connectors_folder FOREIGN KEY (folder_id) REFERENCES folders (id);
+ALTER TABLE workspaces
+	ADD CONSTRAINT fk_workspaces_owner FOREIGN KEY (owner_id) REFERENCES users (id);
+ALTER TABLE collaborations
+	ADD CONSTRAINT fk_collaborations_user FOREIGN KEY (user_id) REFERENCES users (id),
+	ADD CONSTRAINT fk_collaborations_role FOREIGN KEY (role_id) REFERENCES roles (id),
+	ADD CONSTRAINT fk_collaborations_workspace FOREIGN KEY (workspace_id) REFERENCES workspaces (id);
+ALTER TABLE invitations
+	ADD CONSTRAINT fk_invitations_workspace FOREIGN KEY (workspace_id) REFERENCES workspaces (id);
+ALTER TABLE share_links
+	ADD CONSTRAINT fk_share_links_workspace FOREIGN KEY (workspace_id) REFERENCES workspaces (id);
+ALTER TABLE trash_items
+	ADD CONSTRAINT fk_trash_items_workspace FOREIGN KEY (workspace_id) REFERENCES workspaces (id);
+ALTER TABLE file_versions
+	ADD CONSTRAINT fk_file_versions_file FOREIGN KEY (file_id) REFERENCES files (id) ON DELETE CASCADE;
+ALTER TABLE upload_parts
+	ADD CONSTRAINT fk_upload_parts_session FOREIGN KEY (upload_session_id) REFERENCES upload_sessions (id) ON DELETE CASCADE;
+ALTER TABLE layout_changes
+	DROP CONSTRAINT IF EXISTS fk_layout_steps_changes,
+	ADD CONSTRAINT fk_layout_steps_changes FOREIGN KEY (step_id) REFERENCES layout_steps (id) ON DELETE CASCADE;
+
+-- lookups that had no index
+CREATE INDEX IF NOT EXISTS idx_folders_workspace_id ON folders (workspace_id);
+CREATE INDEX IF NOT EXISTS idx_collaborations_user_id ON collaborations (user_id);
+CREATE INDEX IF NOT EXISTS idx_collaborations_workspace_id ON collaborations (workspace_id, user_id);
+CREATE INDEX IF NOT EXISTS idx_upload_sessions_folder_id ON upload_sessions (folder_id);
+CREATE INDEX IF NOT EXISTS idx_trash_items_item_id ON trash_items (item_id);
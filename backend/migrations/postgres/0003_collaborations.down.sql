@@ -0,0 +1,10 @@
+ALTER TABLE folders ADD COLUMN children uuid[];
+ALTER TABLE workspaces ADD COLUMN users uuid[];
+ALTER TABLE users ADD COLUMN workspaces uuid[];
+
+UPDATE users SET workspaces = ARRAY(
+	SELECT workspace_id FROM collaborations WHERE user_id = users.id ORDER BY created_at
+);
+UPDATE workspaces SET users = ARRAY(
+	SELECT user_id FROM collaborations WHERE workspace_id = workspaces.id ORDER BY created_at
+);
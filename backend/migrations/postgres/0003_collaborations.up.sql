@@ -0,0 +1,27 @@
+-- The members of a workspace were kept twice, in collaborations and in uuid
+-- arrays on users and workspaces. Collaborations is the one that stays, it
+-- works on every database. Members only the arrays knew about get a
+-- collaboration first, as owner of their own workspace and editor of others.
+INSERT INTO roles (id, name, created_at)
+VALUES (gen_random_uuid(), 'owner', now()), (gen_random_uuid(), 'editor', now())
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO collaborations (id, user_id, role_id, workspace_id, created_at)
+SELECT gen_random_uuid(), members.user_id, roles.id, members.workspace_id, now()
+FROM (
+	SELECT users.id AS user_id, unnest(users.workspaces) AS workspace_id FROM users
+	UNION
+	SELECT unnest(workspaces.users), workspaces.id FROM workspaces
+) AS members
+JOIN users ON users.id = members.user_id
+JOIN workspaces ON workspaces.id = members.workspace_id
+JOIN roles ON roles.name = CASE WHEN workspaces.owner_id = members.user_id THEN 'owner' ELSE 'editor' END
+WHERE NOT EXISTS (
+	SELECT 1 FROM collaborations
+	WHERE collaborations.user_id = members.user_id AND collaborations.workspace_id = members.workspace_id
+);
+
+ALTER TABLE users DROP COLUMN workspaces;
+ALTER TABLE workspaces DROP COLUMN users;
+-- never filled in, the children of a folder are found by parent_id
+ALTER TABLE folders DROP COLUMN children;
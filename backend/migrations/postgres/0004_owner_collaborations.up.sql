@@ -0,0 +1,15 @@
+-- Every workspace owner gets an owner collaboration, workspaces created before
+-- roles existed only knew their owner through workspaces.owner_id. Access is
+-- checked against collaborations alone from here on.
+INSERT INTO roles (id, name, created_at)
+VALUES (gen_random_uuid(), 'owner', now())
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO collaborations (id, user_id, role_id, workspace_id, created_at)
+SELECT gen_random_uuid(), workspaces.owner_id, roles.id, workspaces.id, now()
+FROM workspaces
+JOIN roles ON roles.name = 'owner'
+WHERE workspaces.owner_id IS NOT NULL AND NOT EXISTS (
+	SELECT 1 FROM collaborations
+	WHERE collaborations.user_id = workspaces.owner_id AND collaborations.workspace_id = workspaces.id
+);
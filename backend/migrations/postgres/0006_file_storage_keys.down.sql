@@ -0,0 +1,5 @@
+UPDATE file_versions SET storage_key = '' WHERE storage_key = (
+	SELECT files.storage_key FROM files WHERE files.id = file_versions.file_id
+);
+
+ALTER TABLE files DROP COLUMN storage_key;
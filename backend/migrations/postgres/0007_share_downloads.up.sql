@@ -0,0 +1,17 @@
+-- A started download of a shared file. It may send the bytes of the file once,
+-- so a download that is resumed or split into ranges is only counted once.
+CREATE TABLE IF NOT EXISTS share_downloads (
+	id uuid DEFAULT gen_random_uuid(),
+	share_link_id uuid NOT NULL,
+	file_id uuid NOT NULL,
+	size bigint NOT NULL,
+	served bigint NOT NULL DEFAULT 0,
+	expires_at timestamptz NOT NULL,
+	created_at timestamptz,
+	PRIMARY KEY (id),
+	CONSTRAINT fk_share_downloads_link FOREIGN KEY (share_link_id) REFERENCES share_links (id) ON DELETE CASCADE,
+	CONSTRAINT fk_share_downloads_file FOREIGN KEY (file_id) REFERENCES files (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_share_downloads_share_link_id ON share_downloads (share_link_id);
+CREATE INDEX IF NOT EXISTS idx_share_downloads_file_id ON share_downloads (file_id);
+CREATE INDEX IF NOT EXISTS idx_share_downloads_expires_at ON share_downloads (expires_at);
@@ -0,0 +1,13 @@
+-- Resumable uploads assemble their parts under a key of their own, hand out
+-- every part number once and only let one request at a time send a chunk.
+ALTER TABLE upload_sessions ADD COLUMN storage_key text NOT NULL DEFAULT '';
+ALTER TABLE upload_sessions ADD COLUMN next_part integer NOT NULL DEFAULT 1;
+ALTER TABLE upload_sessions ADD COLUMN append_lease text NOT NULL DEFAULT '';
+ALTER TABLE upload_sessions ADD COLUMN append_lease_until timestamptz;
+
+-- Sessions started before assemble at the path of the file, where the content
+-- of a file with that name may live. They can not be completed safely, so the
+-- running ones expire and only their multipart uploads are aborted.
+UPDATE upload_sessions SET status = 'expired' WHERE status = 'pending';
+
+CREATE INDEX IF NOT EXISTS idx_upload_sessions_updated_at ON upload_sessions (updated_at);
@@ -0,0 +1,4 @@
+-- btree_gist is left installed, other indexes may use it
+DROP INDEX IF EXISTS idx_canvas_items_viewport;
+DROP INDEX IF EXISTS idx_files_viewport;
+DROP INDEX IF EXISTS idx_folders_viewport;
@@ -0,0 +1,10 @@
+-- The viewport queries look for the items of one folder inside a box. A GiST
+-- index answers that from the folder and the position together, btree_gist
+-- lets it hold the folder id. It is a trusted extension, the owner of the
+-- database can create it.
+CREATE EXTENSION IF NOT EXISTS btree_gist;
+
+CREATE INDEX idx_folders_viewport ON folders USING gist (parent_id, point(x, y)) WHERE deleted_at IS NULL;
+CREATE INDEX idx_files_viewport ON files USING gist (folder_id, point(x, y)) WHERE deleted_at IS NULL;
+-- canvas items have a size, their box has to overlap the viewport
+CREATE INDEX idx_canvas_items_viewport ON canvas_items USING gist (folder_id, box(point(x, y), point(x + width, y + height)));
@@ -0,0 +1,16 @@
+DROP TABLE connectors;
+DROP TABLE canvas_items;
+DROP TABLE layout_changes;
+DROP TABLE layout_steps;
+DROP TABLE share_links;
+DROP TABLE invitations;
+DROP TABLE file_versions;
+DROP TABLE trash_items;
+DROP TABLE upload_parts;
+DROP TABLE upload_sessions;
+DROP TABLE files;
+DROP TABLE folders;
+DROP TABLE collaborations;
+DROP TABLE workspaces;
+DROP TABLE roles;
+DROP TABLE users;
@@ -0,0 +1,221 @@
+-- SQLite starts from the schema Postgres has after its third migration, its
+-- second and third migrations are empty.
+-- Ids are uuids written as text by the application, times are datetimes.
+
+CREATE TABLE users (
+	id text PRIMARY KEY,
+	first_name text NOT NULL,
+	last_name text NOT NULL,
+	email text NOT NULL UNIQUE,
+	user_name text NOT NULL UNIQUE,
+	password_hash text NOT NULL,
+	created_at datetime
+);
+
+CREATE TABLE roles (
+	id text PRIMARY KEY,
+	name text NOT NULL UNIQUE,
+	created_at datetime
+);
+
+CREATE TABLE workspaces (
+	id text PRIMARY KEY,
+	home_folder_id text NOT NULL,
+	name text NOT NULL,
+	owner_id text NOT NULL REFERENCES users (id),
+	created_at datetime
+);
+
+CREATE TABLE collaborations (
+	id text PRIMARY KEY,
+	user_id text NOT NULL REFERENCES users (id),
+	role_id text REFERENCES roles (id),
+	workspace_id text REFERENCES workspaces (id),
+	created_at datetime
+);
+CREATE INDEX idx_collaborations_user_id ON collaborations (user_id);
+CREATE INDEX idx_collaborations_workspace_id ON collaborations (workspace_id, user_id);
+
+CREATE TABLE folders (
+	id text PRIMARY KEY,
+	name text NOT NULL,
+	workspace_id text NOT NULL REFERENCES workspaces (id),
+	parent_id text REFERENCES folders (id),
+	x real NOT NULL,
+	y real NOT NULL,
+	created_at datetime,
+	path text NOT NULL,
+	deleted_at datetime,
+	trash_id text
+);
+CREATE INDEX idx_folders_position ON folders (parent_id, x, y);
+CREATE INDEX idx_folders_deleted_at ON folders (deleted_at);
+CREATE INDEX idx_folders_trash_id ON folders (trash_id);
+CREATE INDEX idx_folders_workspace_id ON folders (workspace_id);
+
+CREATE TABLE files (
+	id text PRIMARY KEY,
+	name text NOT NULL,
+	path text NOT NULL,
+	size integer NOT NULL,
+	x real NOT NULL,
+	y real NOT NULL,
+	folder_id text NOT NULL REFERENCES folders (id),
+	created_at datetime,
+	version integer NOT NULL DEFAULT 1,
+	deleted_at datetime,
+	trash_id text
+);
+CREATE INDEX idx_files_position ON files (folder_id, x, y);
+CREATE INDEX idx_files_deleted_at ON files (deleted_at);
+CREATE INDEX idx_files_trash_id ON files (trash_id);
+
+CREATE TABLE upload_sessions (
+	id text PRIMARY KEY,
+	folder_id text NOT NULL,
+	name text NOT NULL,
+	path text NOT NULL,
+	size integer NOT NULL,
+	upload_offset integer NOT NULL DEFAULT 0,
+	x real NOT NULL,
+	y real NOT NULL,
+	storage_upload_id text NOT NULL,
+	hash_state blob,
+	status text NOT NULL,
+	created_at datetime,
+	updated_at datetime
+);
+CREATE INDEX idx_upload_sessions_folder_id ON upload_sessions (folder_id);
+
+CREATE TABLE upload_parts (
+	id text PRIMARY KEY,
+	upload_session_id text NOT NULL REFERENCES upload_sessions (id) ON DELETE CASCADE,
+	part_number integer NOT NULL,
+	etag text NOT NULL,
+	size integer NOT NULL,
+	created_at datetime
+);
+CREATE UNIQUE INDEX idx_upload_part_number ON upload_parts (upload_session_id, part_number);
+
+CREATE TABLE trash_items (
+	id text PRIMARY KEY,
+	workspace_id text NOT NULL REFERENCES workspaces (id),
+	item_type text NOT NULL,
+	item_id text NOT NULL,
+	name text NOT NULL,
+	path text NOT NULL,
+	original_parent_id text NOT NULL,
+	deleted_by text,
+	deleted_at datetime
+);
+CREATE INDEX idx_trash_items_workspace_id ON trash_items (workspace_id);
+CREATE INDEX idx_trash_items_deleted_at ON trash_items (deleted_at);
+CREATE INDEX idx_trash_items_item_id ON trash_items (item_id);
+
+CREATE TABLE file_versions (
+	id text PRIMARY KEY,
+	file_id text NOT NULL REFERENCES files (id) ON DELETE CASCADE,
+	version integer NOT NULL,
+	storage_key text,
+	size integer NOT NULL,
+	checksum text,
+	author_id text,
+	created_at datetime
+);
+CREATE UNIQUE INDEX idx_file_version ON file_versions (file_id, version);
+
+CREATE TABLE invitations (
+	id text PRIMARY KEY,
+	workspace_id text NOT NULL REFERENCES workspaces (id),
+	email text NOT NULL,
+	role text NOT NULL,
+	invited_by text NOT NULL,
+	status text NOT NULL,
+	created_at datetime,
+	updated_at datetime
+);
+CREATE INDEX idx_invitations_workspace_id ON invitations (workspace_id);
+CREATE INDEX idx_invitations_email ON invitations (email);
+
+CREATE TABLE share_links (
+	id text PRIMARY KEY,
+	token_hash text NOT NULL,
+	workspace_id text NOT NULL REFERENCES workspaces (id),
+	item_type text NOT NULL,
+	item_id text NOT NULL,
+	created_by text,
+	expires_at datetime,
+	password_hash text,
+	password_protected boolean NOT NULL DEFAULT false,
+	max_downloads integer,
+	download_count integer NOT NULL DEFAULT 0,
+	created_at datetime
+);
+CREATE UNIQUE INDEX idx_share_links_token_hash ON share_links (token_hash);
+CREATE INDEX idx_share_links_workspace_id ON share_links (workspace_id);
+CREATE INDEX idx_share_links_item_id ON share_links (item_id);
+
+CREATE TABLE layout_steps (
+	id text PRIMARY KEY,
+	folder_id text NOT NULL,
+	actor_id text,
+	action text NOT NULL,
+	undone boolean NOT NULL DEFAULT false,
+	created_at datetime
+);
+CREATE INDEX idx_layout_steps_folder_id ON layout_steps (folder_id);
+CREATE INDEX idx_layout_steps_created_at ON layout_steps (created_at);
+
+CREATE TABLE layout_changes (
+	id text PRIMARY KEY,
+	step_id text NOT NULL REFERENCES layout_steps (id) ON DELETE CASCADE,
+	item_type text NOT NULL,
+	item_id text NOT NULL,
+	from_folder_id text NOT NULL,
+	to_folder_id text NOT NULL,
+	from_x real,
+	from_y real,
+	to_x real,
+	to_y real
+);
+CREATE INDEX idx_layout_changes_step_id ON layout_changes (step_id);
+CREATE INDEX idx_layout_changes_item_id ON layout_changes (item_id);
+CREATE INDEX idx_layout_changes_from_folder_id ON layout_changes (from_folder_id);
+CREATE INDEX idx_layout_changes_to_folder_id ON layout_changes (to_folder_id);
+
+CREATE TABLE canvas_items (
+	id text PRIMARY KEY,
+	folder_id text NOT NULL REFERENCES folders (id),
+	kind text NOT NULL,
+	x real NOT NULL,
+	y real NOT NULL,
+	width real NOT NULL,
+	height real NOT NULL,
+	title text,
+	text text,
+	format text,
+	url text,
+	color text,
+	created_by text,
+	created_at datetime,
+	updated_at datetime
+);
+CREATE INDEX idx_canvas_items_position ON canvas_items (folder_id, x, y);
+
+CREATE TABLE connectors (
+	id text PRIMARY KEY,
+	folder_id text NOT NULL REFERENCES folders (id),
+	from_type text NOT NULL,
+	from_id text NOT NULL,
+	to_type text NOT NULL,
+	to_id text NOT NULL,
+	label text,
+	style text NOT NULL,
+	direction text NOT NULL,
+	created_by text,
+	created_at datetime,
+	updated_at datetime
+);
+CREATE INDEX idx_connectors_folder_id ON connectors (folder_id);
+CREATE INDEX idx_connectors_from_id ON connectors (from_id);
+CREATE INDEX idx_connectors_to_id ON connectors (to_id);
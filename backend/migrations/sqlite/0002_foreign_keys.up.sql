@@ -0,0 +1,3 @@
+-- The SQLite schema started out with uuid columns and foreign keys, there is
+-- nothing to convert. The version is kept so both dialects count the same.
+SELECT 1;
@@ -0,0 +1,2 @@
+-- nothing was changed
+SELECT 1;
@@ -0,0 +1,3 @@
+-- The SQLite schema never had the member arrays, collaborations are the only
+-- record of the members. The version is kept so both dialects count the same.
+SELECT 1;
@@ -0,0 +1,3 @@
+-- the backfilled collaborations can not be told apart from the others and
+-- are what gives owners access, they are kept
+SELECT 1;
@@ -0,0 +1,20 @@
+-- Every workspace owner gets an owner collaboration, access is checked against
+-- collaborations alone. SQLite has no uuid function, the ids are random v4
+-- uuids put together from random bytes.
+INSERT INTO roles (id, name, created_at)
+SELECT lower(hex(randomblob(4))) || '-' || lower(hex(randomblob(2))) || '-4' ||
+	substr(lower(hex(randomblob(2))), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) ||
+	substr(lower(hex(randomblob(2))), 2) || '-' || lower(hex(randomblob(6))),
+	'owner', CURRENT_TIMESTAMP
+WHERE NOT EXISTS (SELECT 1 FROM roles WHERE name = 'owner');
+
+INSERT INTO collaborations (id, user_id, role_id, workspace_id, created_at)
+SELECT lower(hex(randomblob(4))) || '-' || lower(hex(randomblob(2))) || '-4' ||
+	substr(lower(hex(randomblob(2))), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) ||
+	substr(lower(hex(randomblob(2))), 2) || '-' || lower(hex(randomblob(6))),
+	workspaces.owner_id, (SELECT id FROM roles WHERE name = 'owner'), workspaces.id, CURRENT_TIMESTAMP
+FROM workspaces
+WHERE workspaces.owner_id IS NOT NULL AND NOT EXISTS (
+	SELECT 1 FROM collaborations
+	WHERE collaborations.user_id = workspaces.owner_id AND collaborations.workspace_id = workspaces.id
+);
@@ -0,0 +1,15 @@
+-- A user collaborates on a workspace at most once. Members that ended up with
+-- more than one collaboration keep the one with the highest role.
+DELETE FROM collaborations WHERE id IN (
+	SELECT id FROM (
+		SELECT collaborations.id, row_number() OVER (
+			PARTITION BY collaborations.user_id, collaborations.workspace_id
+			ORDER BY CASE roles.name WHEN 'owner' THEN 0 WHEN 'editor' THEN 1 WHEN 'commenter' THEN 2 ELSE 3 END,
+				collaborations.created_at, collaborations.id
+		) AS n
+		FROM collaborations LEFT JOIN roles ON roles.id = collaborations.role_id
+	) AS ranked
+	WHERE n > 1
+);
+
+CREATE UNIQUE INDEX idx_collaborations_member ON collaborations (user_id, workspace_id);
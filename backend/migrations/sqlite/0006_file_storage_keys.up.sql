@@ -0,0 +1,17 @@
+-- The content of a file is stored under a key of its own that does not follow
+-- its path. Existing files keep the object they already have: live files sit
+-- at their path and trashed ones under the trash item that took them.
+ALTER TABLE files ADD COLUMN storage_key text NOT NULL DEFAULT '';
+
+UPDATE files SET storage_key = CASE
+	WHEN trash_id IS NULL THEN path
+	ELSE '.trash/' || trash_id || '/' || path
+END;
+
+-- the current version points at the same object as its file
+UPDATE file_versions SET storage_key = (
+	SELECT files.storage_key FROM files WHERE files.id = file_versions.file_id
+)
+WHERE (storage_key IS NULL OR storage_key = '') AND version = (
+	SELECT files.version FROM files WHERE files.id = file_versions.file_id
+);
@@ -0,0 +1,14 @@
+-- A started download of a shared file. It may send the bytes of the file once,
+-- so a download that is resumed or split into ranges is only counted once.
+CREATE TABLE share_downloads (
+	id text PRIMARY KEY,
+	share_link_id text NOT NULL REFERENCES share_links (id) ON DELETE CASCADE,
+	file_id text NOT NULL REFERENCES files (id) ON DELETE CASCADE,
+	size integer NOT NULL,
+	served integer NOT NULL DEFAULT 0,
+	expires_at datetime NOT NULL,
+	created_at datetime
+);
+CREATE INDEX idx_share_downloads_share_link_id ON share_downloads (share_link_id);
+CREATE INDEX idx_share_downloads_file_id ON share_downloads (file_id);
+CREATE INDEX idx_share_downloads_expires_at ON share_downloads (expires_at);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_upload_sessions_updated_at;
+
+ALTER TABLE upload_sessions DROP COLUMN append_lease_until;
+ALTER TABLE upload_sessions DROP COLUMN append_lease;
+ALTER TABLE upload_sessions DROP COLUMN next_part;
+ALTER TABLE upload_sessions DROP COLUMN storage_key;
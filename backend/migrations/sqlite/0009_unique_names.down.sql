@@ -0,0 +1,3 @@
+-- the renamed items keep their new names
+DROP INDEX IF EXISTS idx_files_name;
+DROP INDEX IF EXISTS idx_folders_name;
@@ -0,0 +1,35 @@
+-- Files and folders share one namespace per folder, a name is taken by at most
+-- one item that is not in the trash. Items that ended up with the name of an
+-- older sibling get the start of their id appended to it.
+UPDATE folders SET name = name || ' (' || substr(id, 1, 8) || ')' WHERE id IN (
+	SELECT id FROM (
+		SELECT id, row_number() OVER (PARTITION BY parent_id, name ORDER BY created_at, id) AS n
+		FROM folders WHERE parent_id IS NOT NULL AND deleted_at IS NULL
+	) AS ranked
+	WHERE n > 1
+);
+
+UPDATE files SET name = name || ' (' || substr(id, 1, 8) || ')' WHERE id IN (
+	SELECT id FROM (
+		SELECT id, row_number() OVER (PARTITION BY folder_id, name ORDER BY created_at, id) AS n
+		FROM files WHERE deleted_at IS NULL
+	) AS ranked
+	WHERE n > 1
+) OR (deleted_at IS NULL AND EXISTS (
+	SELECT 1 FROM folders
+	WHERE folders.parent_id = files.folder_id AND folders.name = files.name AND folders.deleted_at IS NULL
+));
+
+-- the paths follow the names again, starting from the home folders
+WITH RECURSIVE tree (id, path) AS (
+	SELECT id, path FROM folders WHERE parent_id IS NULL
+	UNION
+	SELECT folders.id, tree.path || '/' || folders.name FROM folders JOIN tree ON folders.parent_id = tree.id
+)
+UPDATE folders SET path = tree.path FROM tree WHERE tree.id = folders.id AND folders.path <> tree.path;
+
+UPDATE files SET path = folders.path || '/' || files.name FROM folders
+WHERE folders.id = files.folder_id AND files.path <> folders.path || '/' || files.name;
+
+CREATE UNIQUE INDEX idx_folders_name ON folders (parent_id, name) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX idx_files_name ON files (folder_id, name) WHERE deleted_at IS NULL;
@@ -0,0 +1,6 @@
+-- SQLite has no GiST. Its R*Tree tables are keyed by integers, which can not
+-- hold the uuid of an item or of its folder, and a tree over the positions of
+-- every folder would match the items of all of them since every canvas starts
+-- around the origin. The viewport queries keep using the B-tree position
+-- indexes on (folder, x, y) here.
+SELECT 1;
@@ -4,7 +4,7 @@ import (
 	"cascloud/types"
 
 	"github.com/google/uuid"
-	"github.com/lib/pq"
+	"gorm.io/gorm"
 )
 
 type User struct {
@@ -14,8 +14,8 @@ type User struct {
 	Email        string    `json:"email" gorm:"not null;unique"`
 	UserName     string    `json:"username" gorm:"not null;unique"`
 	PasswordHash string    `json:"password" gorm:"not null"`
-	// why is uuid.UUID not working here?
-	Workspaces pq.StringArray  `json:"workspaces" gorm:"type:uuid[]"`
+	// the workspaces the user collaborates on, read from collaborations
+	Workspaces []uuid.UUID     `json:"workspaces" gorm:"-"`
 	CreatedAt  types.Timestamp `json:"created_at" gorm:"type:timestamptz;autoCreateTime"`
 }
 
@@ -24,46 +24,322 @@ type Workspace struct {
 	HomeFolderID uuid.UUID       `json:"home_folder_id" gorm:"not null"`
 	Name         string          `json:"name" gorm:"not null"`
 	OwnerID      uuid.UUID       `json:"owner_id" gorm:"not null"`
-	Users        pq.StringArray  `json:"users" gorm:"type:uuid[]"`
+	Users        []uuid.UUID     `json:"users" gorm:"-"`
 	CreatedAt    types.Timestamp `json:"created_at" gorm:"type:timestamptz;autoCreateTime"`
 }
 
 type Collaborations struct {
 	ID          uuid.UUID       `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
-	UserID      uuid.UUID       `json:"user_id" gorm:"not null"`
+	UserID      uuid.UUID       `json:"user_id" gorm:"not null;uniqueIndex:idx_collaborations_member"`
 	RoleID      uuid.UUID       `json:"role_id"`
-	WorkspaceID uuid.UUID       `json:"workspace_id"`
+	WorkspaceID uuid.UUID       `json:"workspace_id" gorm:"uniqueIndex:idx_collaborations_member"`
 	CreatedAt   types.Timestamp `json:"created_at" gorm:"type:timestamptz;autoCreateTime"`
 }
 
 type Role struct {
 	ID        uuid.UUID       `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
-	Name      string          `json:"name" gorm:"not null"`
+	Name      string          `json:"name" gorm:"not null;unique"`
 	CreatedAt types.Timestamp `json:"created_at" gorm:"type:timestamptz;autoCreateTime"`
 }
 
-// Hierarchical file system
+const (
+	RoleOwner     = "owner"
+	RoleEditor    = "editor"
+	RoleCommenter = "commenter"
+	RoleViewer    = "viewer"
+)
+
+// Roles lists the role names from the least to the most privileged, every role
+// can do everything the roles before it can
+var Roles = []string{RoleViewer, RoleCommenter, RoleEditor, RoleOwner}
+
+// Hierarchical file system. Files and folders are indexed by their folder and
+// position so the part of a canvas on screen can be loaded on its own.
 type File struct {
 	ID        uuid.UUID       `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
-	Name      string          `json:"name" gorm:"not null"`
+	Name      string          `json:"name" gorm:"not null;uniqueIndex:idx_files_name,priority:2,where:deleted_at IS NULL"`
 	Path      string          `json:"path" gorm:"not null"`
 	Size      int64           `json:"size" gorm:"not null"`
-	X         float64         `json:"x" gorm:"not null"`
-	Y         float64         `json:"y" gorm:"not null"`
-	FolderID  uuid.UUID       `json:"folder_id" gorm:"not null"`
+	X         float64         `json:"x" gorm:"not null;index:idx_files_position,priority:2"`
+	Y         float64         `json:"y" gorm:"not null;index:idx_files_position,priority:3"`
+	FolderID  uuid.UUID       `json:"folder_id" gorm:"not null;index:idx_files_position,priority:1;uniqueIndex:idx_files_name,priority:1,where:deleted_at IS NULL"`
 	CreatedAt types.Timestamp `json:"created_at" gorm:"type:timestamptz;autoCreateTime"`
+	// the number of the current version and where its content is stored, the
+	// key does not follow the path so renaming or moving never touches storage
+	Version    int    `json:"version" gorm:"not null;default:1"`
+	StorageKey string `json:"-" gorm:"not null;default:''"`
+	// set while the file sits in the trash, trashed rows are hidden from queries
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+	TrashID   *uuid.UUID     `json:"-" gorm:"type:uuid;index"`
 }
 
 type Folder struct {
 	ID          uuid.UUID       `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
-	Name        string          `json:"name" gorm:"not null"`
+	Name        string          `json:"name" gorm:"not null;uniqueIndex:idx_folders_name,priority:2,where:deleted_at IS NULL"`
 	WorkspaceID uuid.UUID       `json:"workspace_id" gorm:"not null"`
-	ParentID    uuid.UUID       `json:"parent_id" gorm:"not null"`
-	X           float64         `json:"x" gorm:"not null"`
-	Y           float64         `json:"y" gorm:"not null"`
+	ParentID    uuid.UUID       `json:"parent_id" gorm:"index:idx_folders_position,priority:1;uniqueIndex:idx_folders_name,priority:1,where:deleted_at IS NULL"`
+	X           float64         `json:"x" gorm:"not null;index:idx_folders_position,priority:2"`
+	Y           float64         `json:"y" gorm:"not null;index:idx_folders_position,priority:3"`
 	CreatedAt   types.Timestamp `json:"created_at" gorm:"type:timestamptz;autoCreateTime"`
-	Children    []uuid.UUID     `json:"children" gorm:"type:uuid[]"`
 	Path        string          `json:"path" gorm:"not null"`
+	// set while the folder sits in the trash, trashed rows are hidden from queries
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+	TrashID   *uuid.UUID     `json:"-" gorm:"type:uuid;index"`
+}
+
+// the kinds of canvas items
+const (
+	CanvasItemNote  = "note"
+	CanvasItemLink  = "link"
+	CanvasItemFrame = "frame"
+)
+
+// how the text of a note is written
+const (
+	NoteFormatText     = "text"
+	NoteFormatMarkdown = "markdown"
+)
+
+// Something on the canvas of a folder that is neither a file nor a folder: a
+// sticky note, a bookmark of a URL or a frame, a named rectangle that groups
+// the items inside it. Like files and folders X and Y are the top left corner.
+type CanvasItem struct {
+	ID        uuid.UUID       `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	FolderID  uuid.UUID       `json:"folder_id" gorm:"not null;index:idx_canvas_items_position,priority:1"`
+	Kind      string          `json:"kind" gorm:"not null"`
+	X         float64         `json:"x" gorm:"not null;index:idx_canvas_items_position,priority:2"`
+	Y         float64         `json:"y" gorm:"not null;index:idx_canvas_items_position,priority:3"`
+	Width     float64         `json:"width" gorm:"not null"`
+	Height    float64         `json:"height" gorm:"not null"`
+	Title     string          `json:"title"`
+	Text      string          `json:"text" gorm:"type:text"`
+	Format    string          `json:"format"`
+	URL       string          `json:"url"`
+	Color     string          `json:"color"`
+	CreatedBy uuid.UUID       `json:"created_by"`
+	CreatedAt types.Timestamp `json:"created_at" gorm:"type:timestamptz;autoCreateTime"`
+	UpdatedAt types.Timestamp `json:"updated_at" gorm:"type:timestamptz;autoUpdateTime"`
+}
+
+// what the ends of a connector can be attached to
+const (
+	ConnectorEndFile   = "file"
+	ConnectorEndFolder = "folder"
+	ConnectorEndItem   = "item"
+)
+
+// how a connector is drawn
+const (
+	ConnectorStyleSolid  = "solid"
+	ConnectorStyleDashed = "dashed"
+	ConnectorStyleDotted = "dotted"
+)
+
+// which ends of a connector get an arrow head
+const (
+	ConnectorDirectionForward  = "forward"
+	ConnectorDirectionBackward = "backward"
+	ConnectorDirectionBoth     = "both"
+	ConnectorDirectionNone     = "none"
+)
+
+// An arrow between two things on the canvas of a folder, each end is a file,
+// a folder or a canvas item in that folder. A connector is removed when one of
+// its ends is deleted or leaves the folder.
+type Connector struct {
+	ID        uuid.UUID       `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	FolderID  uuid.UUID       `json:"folder_id" gorm:"not null;index"`
+	FromType  string          `json:"from_type" gorm:"not null"`
+	FromID    uuid.UUID       `json:"from_id" gorm:"not null;index"`
+	ToType    string          `json:"to_type" gorm:"not null"`
+	ToID      uuid.UUID       `json:"to_id" gorm:"not null;index"`
+	Label     string          `json:"label"`
+	Style     string          `json:"style" gorm:"not null"`
+	Direction string          `json:"direction" gorm:"not null"`
+	CreatedBy uuid.UUID       `json:"created_by"`
+	CreatedAt types.Timestamp `json:"created_at" gorm:"type:timestamptz;autoCreateTime"`
+	UpdatedAt types.Timestamp `json:"updated_at" gorm:"type:timestamptz;autoUpdateTime"`
+}
+
+// One version of a file. Every version is stored under a StorageKey of its
+// own, the current one is the key the file points to.
+type FileVersion struct {
+	ID         uuid.UUID       `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	FileID     uuid.UUID       `json:"file_id" gorm:"not null;uniqueIndex:idx_file_version"`
+	Version    int             `json:"version" gorm:"not null;uniqueIndex:idx_file_version"`
+	StorageKey string          `json:"-"`
+	Size       int64           `json:"size" gorm:"not null"`
+	Checksum   string          `json:"checksum"`
+	AuthorID   uuid.UUID       `json:"author_id"`
+	CreatedAt  types.Timestamp `json:"created_at" gorm:"type:timestamptz;autoCreateTime"`
+}
+
+const (
+	TrashItemFile   = "file"
+	TrashItemFolder = "folder"
+)
+
+// A deleted file or folder, a folder is one item together with everything
+// that was inside it. The rows keep their data and point back here through
+// TrashID until they are restored or purged.
+type TrashItem struct {
+	ID               uuid.UUID       `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	WorkspaceID      uuid.UUID       `json:"workspace_id" gorm:"not null;index"`
+	ItemType         string          `json:"item_type" gorm:"not null"`
+	ItemID           uuid.UUID       `json:"item_id" gorm:"not null"`
+	Name             string          `json:"name" gorm:"not null"`
+	Path             string          `json:"path" gorm:"not null"`
+	OriginalParentID uuid.UUID       `json:"original_parent_id" gorm:"not null"`
+	DeletedBy        uuid.UUID       `json:"deleted_by"`
+	DeletedAt        types.Timestamp `json:"deleted_at" gorm:"type:timestamptz;autoCreateTime;index"`
+}
+
+// what made a layout step
+const (
+	LayoutActionEdit    = "edit"
+	LayoutActionMove    = "move"
+	LayoutActionLayout  = "layout"
+	LayoutActionArrange = "arrange"
+	LayoutActionRestore = "restore"
+)
+
+// One step of the layout journal of a folder, the position and parent changes
+// made by one request to the canvas of FolderID. Undone steps can be redone
+// until a new step is recorded in the folder.
+type LayoutStep struct {
+	ID        uuid.UUID       `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	FolderID  uuid.UUID       `json:"folder_id" gorm:"not null;index"`
+	ActorID   uuid.UUID       `json:"actor_id"`
+	Action    string          `json:"action" gorm:"not null"`
+	Undone    bool            `json:"undone" gorm:"not null;default:false"`
+	CreatedAt types.Timestamp `json:"created_at" gorm:"type:timestamptz;autoCreateTime;index"`
+	Changes   []LayoutChange  `json:"changes" gorm:"foreignKey:StepID"`
+}
+
+// Where an item was before and after a layout step
+type LayoutChange struct {
+	ID           uuid.UUID `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	StepID       uuid.UUID `json:"step_id" gorm:"not null;index"`
+	ItemType     string    `json:"item_type" gorm:"not null"`
+	ItemID       uuid.UUID `json:"item_id" gorm:"not null;index"`
+	FromFolderID uuid.UUID `json:"from_folder_id" gorm:"not null;index"`
+	ToFolderID   uuid.UUID `json:"to_folder_id" gorm:"not null;index"`
+	FromX        float64   `json:"from_x"`
+	FromY        float64   `json:"from_y"`
+	ToX          float64   `json:"to_x"`
+	ToY          float64   `json:"to_y"`
+}
+
+const (
+	UploadStatusPending = "pending"
+	// the parts are being turned into a file, a completion that fails goes
+	// back to pending so it can be retried
+	UploadStatusCompleting = "completing"
+	UploadStatusCompleted  = "completed"
+	UploadStatusAborted    = "aborted"
+	// the upload was abandoned and its storage is being freed
+	UploadStatusExpired = "expired"
+)
+
+// A resumable upload, the bytes are sent in chunks that become parts of a
+// multipart upload in storage. Offset is the number of bytes confirmed so far
+// and HashState the sha256 state after them, so the checksum is known on completion.
+type UploadSession struct {
+	ID              uuid.UUID `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	FolderID        uuid.UUID `json:"folder_id" gorm:"not null"`
+	Name            string    `json:"name" gorm:"not null"`
+	Path            string    `json:"path" gorm:"not null"`
+	Size            int64     `json:"size" gorm:"not null"`
+	Offset          int64     `json:"offset" gorm:"column:upload_offset;not null;default:0"`
+	X               float64   `json:"x" gorm:"not null"`
+	Y               float64   `json:"y" gorm:"not null"`
+	StorageUploadID string    `json:"-" gorm:"not null"`
+	// where the parts are assembled, it does not follow the path of the folder
+	StorageKey string `json:"-" gorm:"not null;default:''"`
+	HashState  []byte `json:"-"`
+	// the number the next part gets, numbers are never handed out twice
+	NextPart int32 `json:"-" gorm:"not null;default:1"`
+	// a chunk is only sent to storage while its request holds the append lease
+	AppendLease      string           `json:"-" gorm:"not null;default:''"`
+	AppendLeaseUntil *types.Timestamp `json:"-" gorm:"type:timestamptz"`
+	Status           string           `json:"status" gorm:"not null"`
+	CreatedAt        types.Timestamp  `json:"created_at" gorm:"type:timestamptz;autoCreateTime"`
+	UpdatedAt        types.Timestamp  `json:"updated_at" gorm:"type:timestamptz;autoUpdateTime"`
+}
+
+type UploadPart struct {
+	ID              uuid.UUID       `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	UploadSessionID uuid.UUID       `json:"upload_session_id" gorm:"not null;uniqueIndex:idx_upload_part_number"`
+	PartNumber      int32           `json:"part_number" gorm:"not null;uniqueIndex:idx_upload_part_number"`
+	ETag            string          `json:"etag" gorm:"column:etag;not null"`
+	Size            int64           `json:"size" gorm:"not null"`
+	CreatedAt       types.Timestamp `json:"created_at" gorm:"type:timestamptz;autoCreateTime"`
+}
+
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationDeclined = "declined"
+)
+
+// An invitation to join a workspace with a role. It is addressed to an email
+// so people can be invited before they register.
+type Invitation struct {
+	ID          uuid.UUID       `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	WorkspaceID uuid.UUID       `json:"workspace_id" gorm:"not null;index"`
+	Email       string          `json:"email" gorm:"not null;index"`
+	Role        string          `json:"role" gorm:"not null"`
+	InvitedBy   uuid.UUID       `json:"invited_by" gorm:"not null"`
+	Status      string          `json:"status" gorm:"not null"`
+	CreatedAt   types.Timestamp `json:"created_at" gorm:"type:timestamptz;autoCreateTime"`
+	UpdatedAt   types.Timestamp `json:"updated_at" gorm:"type:timestamptz;autoUpdateTime"`
+}
+
+// A user of a workspace together with their role, built from the collaborations
+type Member struct {
+	UserID    uuid.UUID       `json:"user_id"`
+	Email     string          `json:"email"`
+	UserName  string          `json:"username"`
+	FirstName string          `json:"first_name"`
+	LastName  string          `json:"last_name"`
+	Role      string          `json:"role"`
+	JoinedAt  types.Timestamp `json:"joined_at"`
+}
+
+const (
+	ShareItemFile   = "file"
+	ShareItemFolder = "folder"
+)
+
+// A public link to a file or folder. Only the sha256 of the token is stored,
+// the token itself is shown once when the link is created.
+type ShareLink struct {
+	ID                uuid.UUID        `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	TokenHash         string           `json:"-" gorm:"not null;uniqueIndex"`
+	WorkspaceID       uuid.UUID        `json:"workspace_id" gorm:"not null;index"`
+	ItemType          string           `json:"item_type" gorm:"not null"`
+	ItemID            uuid.UUID        `json:"item_id" gorm:"not null;index"`
+	CreatedBy         uuid.UUID        `json:"created_by"`
+	ExpiresAt         *types.Timestamp `json:"expires_at" gorm:"type:timestamptz"`
+	PasswordHash      string           `json:"-"`
+	PasswordProtected bool             `json:"password_protected" gorm:"not null;default:false"`
+	// nil means the link can be downloaded from any number of times
+	MaxDownloads  *int            `json:"max_downloads"`
+	DownloadCount int             `json:"download_count" gorm:"not null;default:0"`
+	CreatedAt     types.Timestamp `json:"created_at" gorm:"type:timestamptz;autoCreateTime"`
+}
+
+// A download of a shared file that was counted. Until it expires the requests
+// that resume or split it are free as long as together they send no more than
+// Size bytes, asking for more is a new download.
+type ShareDownload struct {
+	ID          uuid.UUID       `json:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	ShareLinkID uuid.UUID       `json:"share_link_id" gorm:"not null;index"`
+	FileID      uuid.UUID       `json:"file_id" gorm:"not null;index"`
+	Size        int64           `json:"size" gorm:"not null"`
+	Served      int64           `json:"served" gorm:"not null;default:0"`
+	ExpiresAt   types.Timestamp `json:"expires_at" gorm:"type:timestamptz;not null;index"`
+	CreatedAt   types.Timestamp `json:"created_at" gorm:"type:timestamptz;autoCreateTime"`
 }
 
 type UserLogin struct {
@@ -77,10 +353,123 @@ type CreateFolderRequest struct {
 	ParentID    string `json:"parent_id"`
 }
 
+// used to edit a file, every field is optional and left out ones are kept
 type EditFileRequest struct {
-	ID       string `json:"id"`
-	Name     string `json:"name"`
+	Name     string   `json:"name"`
+	FolderID string   `json:"folder_id"`
+	X        *float64 `json:"x"`
+	Y        *float64 `json:"y"`
+}
+
+// used to move or copy a file, Name is optional and renames the file
+type MoveFileRequest struct {
 	FolderID string `json:"folder_id"`
-	X        string `json:"x"`
-	Y        string `json:"y"`
+	Name     string `json:"name"`
+}
+
+// used to edit a folder, every field is optional and left out ones are kept
+type EditFolderRequest struct {
+	Name     string   `json:"name"`
+	ParentID string   `json:"parent_id"`
+	X        *float64 `json:"x"`
+	Y        *float64 `json:"y"`
+}
+
+// used to move or copy a folder under a new parent
+type MoveFolderRequest struct {
+	ParentID string `json:"parent_id"`
+}
+
+const (
+	LayoutItemFile   = "file"
+	LayoutItemFolder = "folder"
+)
+
+// where one file or folder should sit on the canvas, FolderID is optional and
+// moves the item into another folder
+type LayoutItem struct {
+	ItemType string   `json:"item_type"`
+	ID       string   `json:"id"`
+	X        *float64 `json:"x"`
+	Y        *float64 `json:"y"`
+	FolderID string   `json:"folder_id"`
+}
+
+type UpdateLayoutRequest struct {
+	Items []LayoutItem `json:"items"`
+}
+
+// used to create a canvas item or to edit one, left out fields are kept when
+// editing and the kind can not change
+type CanvasItemRequest struct {
+	FolderID string   `json:"folder_id"`
+	Kind     string   `json:"kind"`
+	X        *float64 `json:"x"`
+	Y        *float64 `json:"y"`
+	Width    *float64 `json:"width"`
+	Height   *float64 `json:"height"`
+	Title    *string  `json:"title"`
+	Text     *string  `json:"text"`
+	Format   *string  `json:"format"`
+	URL      *string  `json:"url"`
+	Color    *string  `json:"color"`
+}
+
+// used to connect two items of a folder or to edit a connector, the ends of a
+// connector can not change and left out fields are kept when editing
+type ConnectorRequest struct {
+	FromType  string  `json:"from_type"`
+	FromID    string  `json:"from_id"`
+	ToType    string  `json:"to_type"`
+	ToID      string  `json:"to_id"`
+	Label     *string `json:"label"`
+	Style     *string `json:"style"`
+	Direction *string `json:"direction"`
+}
+
+// a rectangle of a canvas, the corners are included
+type Viewport struct {
+	MinX float64 `json:"min_x"`
+	MinY float64 `json:"min_y"`
+	MaxX float64 `json:"max_x"`
+	MaxY float64 `json:"max_y"`
+}
+
+// used to put a canvas back the way it was at a point in time
+type RestoreLayoutRequest struct {
+	At types.Timestamp `json:"at"`
+}
+
+// used to re-arrange the items of a folder, see the layout package for the
+// strategies and sort orders
+type ArrangeFolderRequest struct {
+	Strategy string `json:"strategy"`
+	SortBy   string `json:"sort_by"`
+	Order    string `json:"order"`
+	Columns  int    `json:"columns"`
+}
+
+type CreateUploadRequest struct {
+	FolderID string  `json:"folder_id"`
+	Name     string  `json:"name"`
+	Size     int64   `json:"size"`
+	X        float64 `json:"x"`
+	Y        float64 `json:"y"`
+}
+
+type InviteMemberRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type UpdateMemberRequest struct {
+	Role string `json:"role"`
+}
+
+type CreateShareLinkRequest struct {
+	ItemType     string           `json:"item_type"`
+	ItemID       string           `json:"item_id"`
+	ExpiresAt    *types.Timestamp `json:"expires_at"`
+	Password     string           `json:"password"`
+	MaxDownloads *int             `json:"max_downloads"`
 }
@@ -0,0 +1,190 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// below this scale the text of a PNG is left out, the font does not scale
+// and would spill out of the cards
+const minTextScale = 0.75
+
+// PNG rasterizes the canvas, the image is MaxPixels wide and high at most
+func PNG(w io.Writer, canvas Canvas, opts Options) error {
+	view, scale, err := frame(canvas, opts)
+	if err != nil {
+		return err
+	}
+	width := math.Ceil((view.MaxX - view.MinX) * scale)
+	height := math.Ceil((view.MaxY - view.MinY) * scale)
+	if width > MaxPixels || height > MaxPixels {
+		return ErrTooLarge
+	}
+	r := raster{
+		img:   image.NewRGBA(image.Rect(0, 0, max(int(width), 1), max(int(height), 1))),
+		view:  view,
+		scale: scale,
+	}
+	draw.Draw(r.img, r.img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	for _, s := range scene(canvas, view) {
+		switch s.kind {
+		case shapeRect:
+			r.rect(s)
+		case shapePolygon:
+			r.polygon(s.points, s.fill)
+		case shapeLine:
+			r.line(s)
+		case shapeText:
+			if scale >= minTextScale {
+				r.text(s)
+			}
+		}
+	}
+	return png.Encode(w, r.img)
+}
+
+// raster draws shapes given in canvas coordinates onto an image
+type raster struct {
+	img   *image.RGBA
+	view  Rect
+	scale float64
+}
+
+// at is where a point of the canvas lands in the image
+func (r raster) at(p Point) (float64, float64) {
+	return (p.X - r.view.MinX) * r.scale, (p.Y - r.view.MinY) * r.scale
+}
+
+// thickness is how many pixels a stroke of the given width takes
+func (r raster) thickness(width float64) int {
+	return max(int(math.Round(width*r.scale)), 1)
+}
+
+// fill paints a rectangle of pixels, it is clipped to the image first so huge
+// coordinates far outside of it cost nothing
+func (r raster) fill(minX, minY, maxX, maxY float64, c color.RGBA) {
+	bounds := r.img.Bounds()
+	clamp := func(v float64, limit int) int {
+		return int(math.Max(0, math.Min(v, float64(limit))))
+	}
+	area := image.Rect(clamp(math.Floor(minX), bounds.Max.X), clamp(math.Floor(minY), bounds.Max.Y),
+		clamp(math.Ceil(maxX), bounds.Max.X), clamp(math.Ceil(maxY), bounds.Max.Y))
+	draw.Draw(r.img, area, image.NewUniform(c), image.Point{}, draw.Over)
+}
+
+func (r raster) rect(s shape) {
+	x0, y0 := r.at(s.points[0])
+	x1, y1 := r.at(s.points[1])
+	if s.fill.A != 0 {
+		r.fill(x0, y0, x1, y1, s.fill)
+	}
+	if s.stroke.A != 0 {
+		t := float64(r.thickness(s.width))
+		r.fill(x0, y0, x1, y0+t, s.stroke)
+		r.fill(x0, y1-t, x1, y1, s.stroke)
+		r.fill(x0, y0, x0+t, y1, s.stroke)
+		r.fill(x1-t, y0, x1, y1, s.stroke)
+	}
+}
+
+// polygon fills a polygon one row of pixels at a time, sampling the middle of
+// each row and filling between pairs of edge crossings
+func (r raster) polygon(points []Point, c color.RGBA) {
+	xs := make([]float64, len(points))
+	ys := make([]float64, len(points))
+	minY, maxY := math.Inf(1), math.Inf(-1)
+	for i, p := range points {
+		xs[i], ys[i] = r.at(p)
+		minY, maxY = math.Min(minY, ys[i]), math.Max(maxY, ys[i])
+	}
+	bounds := r.img.Bounds()
+	first := int(math.Max(math.Floor(minY), 0))
+	last := int(math.Min(math.Ceil(maxY), float64(bounds.Max.Y)))
+	var crossings []float64
+	for row := first; row < last; row++ {
+		y := float64(row) + 0.5
+		crossings = crossings[:0]
+		for i := range points {
+			j := (i + 1) % len(points)
+			if (ys[i] <= y) != (ys[j] <= y) {
+				crossings = append(crossings, xs[i]+(y-ys[i])/(ys[j]-ys[i])*(xs[j]-xs[i]))
+			}
+		}
+		sort.Float64s(crossings)
+		for k := 0; k+1 < len(crossings); k += 2 {
+			r.fill(math.Round(crossings[k]), float64(row), math.Round(crossings[k+1]), float64(row+1), c)
+		}
+	}
+}
+
+// line walks along a line half a pixel at a time, the dash pattern is in
+// canvas units so it looks the same at every scale
+func (r raster) line(s shape) {
+	x0, y0 := r.at(s.points[0])
+	x1, y1 := r.at(s.points[1])
+	t := r.thickness(s.width)
+	bounds := r.img.Bounds()
+	start, end, ok := clip(x0, y0, x1, y1, float64(-t), float64(-t), float64(bounds.Max.X+t), float64(bounds.Max.Y+t))
+	if !ok {
+		return
+	}
+	length := math.Hypot(x1-x0, y1-y0)
+	half := float64(t) / 2
+	for d := start * length; d <= end*length; d += 0.5 {
+		if len(s.dash) == 2 && math.Mod(d/r.scale, s.dash[0]+s.dash[1]) >= s.dash[0] {
+			continue
+		}
+		x := x0 + (x1-x0)*d/length
+		y := y0 + (y1-y0)*d/length
+		r.fill(x-half, y-half, x+half, y+half, s.stroke)
+	}
+}
+
+func (r raster) text(s shape) {
+	x, y := r.at(s.points[0])
+	drawer := font.Drawer{
+		Dst:  r.img,
+		Src:  image.NewUniform(s.fill),
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(int(math.Round(x)), int(math.Round(y))),
+	}
+	drawer.DrawString(s.text)
+}
+
+// clip cuts the line from x0, y0 to x1, y1 down to the part inside a
+// rectangle and returns where that part starts and ends as fractions of the
+// line, it is the Liang-Barsky algorithm
+func clip(x0, y0, x1, y1, minX, minY, maxX, maxY float64) (float64, float64, bool) {
+	start, end := 0.0, 1.0
+	dx, dy := x1-x0, y1-y0
+	for _, edge := range [4][2]float64{
+		{-dx, x0 - minX},
+		{dx, maxX - x0},
+		{-dy, y0 - minY},
+		{dy, maxY - y0},
+	} {
+		p, q := edge[0], edge[1]
+		if p == 0 {
+			if q < 0 {
+				return 0, 0, false
+			}
+			continue
+		}
+		t := q / p
+		if p < 0 {
+			start = math.Max(start, t)
+		} else {
+			end = math.Min(end, t)
+		}
+	}
+	return start, end, start <= end
+}
@@ -0,0 +1,469 @@
+// Package render draws the canvas of a folder as an SVG or PNG image. The
+// canvas is first turned into a list of shapes in canvas coordinates, both
+// formats then draw the same shapes so they look alike.
+package render
+
+import (
+	"errors"
+	"image/color"
+	"math"
+	"path"
+	"strings"
+
+	"cascloud/layout"
+
+	"github.com/google/uuid"
+)
+
+// the kinds of nodes on a canvas
+const (
+	KindFile   = "file"
+	KindFolder = "folder"
+	KindNote   = "note"
+	KindLink   = "link"
+	KindFrame  = "frame"
+)
+
+// how an edge is drawn
+const (
+	StyleSolid  = "solid"
+	StyleDashed = "dashed"
+	StyleDotted = "dotted"
+)
+
+// the largest scale an image can be drawn at and the most pixels a PNG may
+// have on either side
+const (
+	MaxScale  = 4
+	MaxPixels = 8192
+)
+
+// the room left around the items when the whole canvas is drawn
+const Margin = layout.Gap
+
+var (
+	ErrInvalidScale = errors.New("invalid scale")
+	ErrTooLarge     = errors.New("image too large")
+)
+
+// Rect is a rectangle of the canvas, in canvas coordinates
+type Rect struct {
+	MinX float64
+	MinY float64
+	MaxX float64
+	MaxY float64
+}
+
+// Node is something on the canvas, X and Y are its top left corner. Files
+// and folders always have the size of a card so Width and Height are only
+// used by notes, links and frames.
+type Node struct {
+	ID     uuid.UUID
+	Kind   string
+	Name   string
+	Text   string
+	X      float64
+	Y      float64
+	Width  float64
+	Height float64
+	Color  string
+}
+
+// Edge is an arrow between two nodes, it is left out when either end is not
+// on the canvas
+type Edge struct {
+	From       uuid.UUID
+	To         uuid.UUID
+	Label      string
+	Style      string
+	StartArrow bool
+	EndArrow   bool
+}
+
+type Canvas struct {
+	Nodes []Node
+	Edges []Edge
+}
+
+// Options choose what part of the canvas is drawn and how large, the whole
+// canvas is drawn at a scale of 1 when they are left empty
+type Options struct {
+	Viewport *Rect
+	Scale    float64
+}
+
+// the size of the text, the PNG font is 7 by 13 pixels and the SVG uses a
+// monospace font of about the same size
+const (
+	charWidth  = 7
+	lineHeight = 14
+	fontSize   = 12
+)
+
+// the look of the canvas
+var (
+	background   = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	textColor    = color.RGBA{0x33, 0x33, 0x33, 0xff}
+	folderFill   = color.RGBA{0xf5, 0xc5, 0x42, 0xff}
+	folderStroke = color.RGBA{0xc9, 0x9a, 0x1e, 0xff}
+	fileFill     = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	fileStroke   = color.RGBA{0x8a, 0x94, 0xa6, 0xff}
+	fileFold     = color.RGBA{0xdf, 0xe3, 0xea, 0xff}
+	noteFill     = color.RGBA{0xff, 0xf3, 0xa8, 0xff}
+	noteStroke   = color.RGBA{0xd9, 0xc6, 0x5a, 0xff}
+	linkFill     = color.RGBA{0xe8, 0xf0, 0xfe, 0xff}
+	linkStroke   = color.RGBA{0x4a, 0x7b, 0xd0, 0xff}
+	frameFill    = color.RGBA{0xf4, 0xf6, 0xf9, 0xff}
+	frameStroke  = color.RGBA{0x9a, 0xa3, 0xb2, 0xff}
+	edgeColor    = color.RGBA{0x55, 0x5d, 0x6b, 0xff}
+)
+
+// dash patterns of edges, in canvas units
+var dashes = map[string][]float64{
+	StyleDashed: {8, 6},
+	StyleDotted: {2, 4},
+}
+
+// the width of outlines and edges and the size of arrow heads
+const (
+	outlineWidth = 1
+	edgeWidth    = 1.5
+	arrowLength  = 10
+	arrowWidth   = 5
+)
+
+type Point struct {
+	X float64
+	Y float64
+}
+
+const (
+	shapeRect = iota
+	shapePolygon
+	shapeLine
+	shapeText
+)
+
+// shape is one thing to draw: a rectangle between two corners, a polygon, a
+// line between two points or a line of text starting at its baseline. A fill
+// or stroke with no alpha is not drawn.
+type shape struct {
+	kind   int
+	points []Point
+	fill   color.RGBA
+	stroke color.RGBA
+	width  float64
+	dash   []float64
+	text   string
+}
+
+// frame works out the part of the canvas to draw and the scale to draw it at
+func frame(canvas Canvas, opts Options) (Rect, float64, error) {
+	scale := opts.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	if math.IsNaN(scale) || scale <= 0 || scale > MaxScale {
+		return Rect{}, 0, ErrInvalidScale
+	}
+	if opts.Viewport != nil {
+		return *opts.Viewport, scale, nil
+	}
+	if len(canvas.Nodes) == 0 {
+		return Rect{-Margin, -Margin, Margin, Margin}, scale, nil
+	}
+	view := Rect{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	for _, node := range canvas.Nodes {
+		box := bounds(node)
+		view.MinX = math.Min(view.MinX, box.MinX)
+		view.MinY = math.Min(view.MinY, box.MinY)
+		view.MaxX = math.Max(view.MaxX, box.MaxX)
+		view.MaxY = math.Max(view.MaxY, box.MaxY)
+	}
+	// the title of a frame sits above it
+	view.MinX -= Margin
+	view.MinY -= Margin + lineHeight
+	view.MaxX += Margin
+	view.MaxY += Margin
+	return view, scale, nil
+}
+
+// bounds is the rectangle a node takes up on the canvas
+func bounds(node Node) Rect {
+	if node.Kind == KindFile || node.Kind == KindFolder {
+		return Rect{node.X, node.Y, node.X + layout.ItemWidth, node.Y + layout.ItemHeight}
+	}
+	return Rect{node.X, node.Y, node.X + node.Width, node.Y + node.Height}
+}
+
+func (r Rect) overlaps(other Rect) bool {
+	return r.MinX <= other.MaxX && r.MaxX >= other.MinX && r.MinY <= other.MaxY && r.MaxY >= other.MinY
+}
+
+// scene turns the canvas into the shapes to draw, frames go below the edges
+// and everything else above them
+func scene(canvas Canvas, view Rect) []shape {
+	var frames, nodes, edges []shape
+	boxes := make(map[uuid.UUID]Rect, len(canvas.Nodes))
+	for _, node := range canvas.Nodes {
+		box := bounds(node)
+		boxes[node.ID] = box
+		if !box.overlaps(view) && node.Kind != KindFrame {
+			continue
+		}
+		switch node.Kind {
+		case KindFolder:
+			nodes = append(nodes, folderShapes(node)...)
+		case KindFile:
+			nodes = append(nodes, fileShapes(node)...)
+		case KindNote:
+			nodes = append(nodes, cardShapes(node, box, noteFill, noteStroke)...)
+		case KindLink:
+			nodes = append(nodes, cardShapes(node, box, linkFill, linkStroke)...)
+		case KindFrame:
+			// the title of a frame can be in view when the frame is not
+			titled := box
+			titled.MinY -= lineHeight
+			if titled.overlaps(view) {
+				frames = append(frames, frameShapes(node, box)...)
+			}
+		}
+	}
+	for _, edge := range canvas.Edges {
+		from, fromOK := boxes[edge.From]
+		to, toOK := boxes[edge.To]
+		if fromOK && toOK {
+			edges = append(edges, edgeShapes(edge, from, to)...)
+		}
+	}
+	shapes := make([]shape, 0, len(frames)+len(edges)+len(nodes))
+	shapes = append(shapes, frames...)
+	shapes = append(shapes, edges...)
+	return append(shapes, nodes...)
+}
+
+func rect(minX, minY, maxX, maxY float64, fill color.RGBA, stroke color.RGBA) shape {
+	return shape{kind: shapeRect, points: []Point{{minX, minY}, {maxX, maxY}}, fill: fill, stroke: stroke, width: outlineWidth}
+}
+
+func polygon(fill color.RGBA, points ...Point) shape {
+	return shape{kind: shapePolygon, points: points, fill: fill}
+}
+
+func text(x, y float64, value string) shape {
+	return shape{kind: shapeText, points: []Point{{x, y}}, fill: textColor, text: value}
+}
+
+// centered is a line of text centered on x
+func centered(x, y float64, value string) shape {
+	return text(x-float64(len([]rune(value)))*charWidth/2, y, value)
+}
+
+// the icon of a folder is a folder with a tab, its name goes below it
+func folderShapes(node Node) []shape {
+	x, y := node.X, node.Y
+	shapes := []shape{
+		rect(x+23, y+20, x+51, y+30, folderFill, folderStroke),
+		rect(x+23, y+26, x+87, y+74, folderFill, folderStroke),
+	}
+	return append(shapes, nameShapes(node)...)
+}
+
+// the icon of a file is a page with a folded corner and its extension
+func fileShapes(node Node) []shape {
+	x, y := node.X, node.Y
+	shapes := []shape{
+		polygon(fileStroke, Point{x + 31, y + 14}, Point{x + 66, y + 14}, Point{x + 80, y + 28}, Point{x + 80, y + 78}, Point{x + 31, y + 78}),
+		polygon(fileFill, Point{x + 32, y + 15}, Point{x + 65, y + 15}, Point{x + 79, y + 29}, Point{x + 79, y + 77}, Point{x + 32, y + 77}),
+		polygon(fileFold, Point{x + 65, y + 15}, Point{x + 79, y + 29}, Point{x + 65, y + 29}),
+	}
+	if ext := strings.ToUpper(strings.TrimPrefix(path.Ext(node.Name), ".")); ext != "" {
+		shapes = append(shapes, centered(x+55, y+58, truncate(ext, 5)))
+	}
+	return append(shapes, nameShapes(node)...)
+}
+
+// the name of a file or folder takes up to two lines below its icon
+func nameShapes(node Node) []shape {
+	var shapes []shape
+	for i, line := range wrap(node.Name, layout.ItemWidth/charWidth, 2) {
+		shapes = append(shapes, centered(node.X+layout.ItemWidth/2, node.Y+98+float64(i)*lineHeight, line))
+	}
+	return shapes
+}
+
+// a note or link shows its title and as much of its text, or of the address
+// it points to, as fits
+func cardShapes(node Node, box Rect, fill color.RGBA, stroke color.RGBA) []shape {
+	shapes := []shape{rect(box.MinX, box.MinY, box.MaxX, box.MaxY, parseColor(node.Color, fill), stroke)}
+	content := node.Text
+	if node.Name != "" {
+		content = node.Name + "\n" + content
+	}
+	return append(shapes, boxText(box, content)...)
+}
+
+// a frame is an outlined rectangle with its title above the top left corner
+func frameShapes(node Node, box Rect) []shape {
+	fill := parseColor(node.Color, frameFill)
+	return []shape{
+		rect(box.MinX, box.MinY, box.MaxX, box.MaxY, fill, frameStroke),
+		text(box.MinX, box.MinY-4, truncate(node.Name, int((box.MaxX-box.MinX)/charWidth))),
+	}
+}
+
+// boxText fills a box with as many wrapped lines of content as fit
+func boxText(box Rect, content string) []shape {
+	const padding = 8
+	columns := int((box.MaxX - box.MinX - 2*padding) / charWidth)
+	rows := int((box.MaxY - box.MinY - padding) / lineHeight)
+	var shapes []shape
+	for i, line := range wrap(content, columns, rows) {
+		shapes = append(shapes, text(box.MinX+padding, box.MinY+padding+lineHeight*float64(i+1)-3, line))
+	}
+	return shapes
+}
+
+// an edge runs between the borders of two boxes, the label sits in the middle
+func edgeShapes(edge Edge, from Rect, to Rect) []shape {
+	start := border(from, center(to))
+	end := border(to, center(from))
+	dx, dy := end.X-start.X, end.Y-start.Y
+	length := math.Hypot(dx, dy)
+	if length < 1 {
+		return nil
+	}
+	shapes := []shape{{kind: shapeLine, points: []Point{start, end}, stroke: edgeColor, width: edgeWidth, dash: dashes[edge.Style]}}
+	ux, uy := dx/length, dy/length
+	if edge.EndArrow {
+		shapes = append(shapes, arrow(end, ux, uy))
+	}
+	if edge.StartArrow {
+		shapes = append(shapes, arrow(start, -ux, -uy))
+	}
+	if edge.Label != "" {
+		label := truncate(edge.Label, 30)
+		mid := Point{(start.X + end.X) / 2, (start.Y + end.Y) / 2}
+		half := float64(len([]rune(label)))*charWidth/2 + 3
+		shapes = append(shapes,
+			rect(mid.X-half, mid.Y-lineHeight/2, mid.X+half, mid.Y+lineHeight/2, background, color.RGBA{}),
+			centered(mid.X, mid.Y+4, label))
+	}
+	return shapes
+}
+
+// arrow is an arrow head with its tip at tip, pointing along ux, uy
+func arrow(tip Point, ux, uy float64) shape {
+	baseX, baseY := tip.X-ux*arrowLength, tip.Y-uy*arrowLength
+	return polygon(edgeColor,
+		tip,
+		Point{baseX - uy*arrowWidth, baseY + ux*arrowWidth},
+		Point{baseX + uy*arrowWidth, baseY - ux*arrowWidth})
+}
+
+func center(r Rect) Point {
+	return Point{(r.MinX + r.MaxX) / 2, (r.MinY + r.MaxY) / 2}
+}
+
+// border is where the line from the center of a box towards a point leaves the box
+func border(r Rect, toward Point) Point {
+	c := center(r)
+	dx, dy := toward.X-c.X, toward.Y-c.Y
+	t := math.Inf(1)
+	if dx != 0 {
+		t = math.Min(t, (r.MaxX-r.MinX)/2/math.Abs(dx))
+	}
+	if dy != 0 {
+		t = math.Min(t, (r.MaxY-r.MinY)/2/math.Abs(dy))
+	}
+	if math.IsInf(t, 1) || t > 1 {
+		return c
+	}
+	return Point{c.X + dx*t, c.Y + dy*t}
+}
+
+// truncate shortens text to at most n characters, marking that it was cut
+func truncate(value string, n int) string {
+	runes := []rune(value)
+	if len(runes) <= n {
+		return value
+	}
+	if n <= 3 {
+		return string(runes[:max(n, 0)])
+	}
+	return string(runes[:n-3]) + "..."
+}
+
+// wrap breaks text into at most rows lines of at most columns characters,
+// between words where it can, the last line is cut when there is more
+func wrap(value string, columns int, rows int) []string {
+	if columns <= 0 || rows <= 0 {
+		return nil
+	}
+	var lines []string
+	for _, paragraph := range strings.Split(value, "\n") {
+		line := []rune{}
+		for _, word := range strings.Fields(paragraph) {
+			runes := []rune(word)
+			if len(line) > 0 && len(line)+1+len(runes) > columns {
+				lines = append(lines, string(line))
+				line = []rune{}
+			}
+			if len(line) > 0 {
+				line = append(line, ' ')
+			}
+			line = append(line, runes...)
+			for len(line) > columns {
+				lines = append(lines, string(line[:columns]))
+				line = line[columns:]
+			}
+		}
+		lines = append(lines, string(line))
+	}
+	// blank lines at the end take room without showing anything
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) > rows {
+		lines = lines[:rows]
+		last := []rune(lines[rows-1])
+		if len(last)+3 > columns {
+			last = last[:max(columns-3, 0)]
+		}
+		lines[rows-1] = string(last) + "..."
+	}
+	return lines
+}
+
+// parseColor reads a #rgb or #rrggbb color, fallback is used for anything else
+func parseColor(value string, fallback color.RGBA) color.RGBA {
+	value = strings.TrimPrefix(value, "#")
+	if len(value) == 3 {
+		value = string([]byte{value[0], value[0], value[1], value[1], value[2], value[2]})
+	}
+	if len(value) != 6 {
+		return fallback
+	}
+	var rgb [3]uint8
+	for i := range rgb {
+		high, highOK := hexDigit(value[2*i])
+		low, lowOK := hexDigit(value[2*i+1])
+		if !highOK || !lowOK {
+			return fallback
+		}
+		rgb[i] = high<<4 | low
+	}
+	return color.RGBA{rgb[0], rgb[1], rgb[2], 0xff}
+}
+
+func hexDigit(b byte) (uint8, bool) {
+	switch {
+	case b >= '0' && b <= '9':
+		return b - '0', true
+	case b >= 'a' && b <= 'f':
+		return b - 'a' + 10, true
+	case b >= 'A' && b <= 'F':
+		return b - 'A' + 10, true
+	}
+	return 0, false
+}
@@ -0,0 +1,150 @@
+package render
+
+import (
+	"bytes"
+	"errors"
+	"image/color"
+	"image/png"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestWrap(t *testing.T) {
+	cases := []struct {
+		name    string
+		value   string
+		columns int
+		rows    int
+		want    []string
+	}{
+		{"fits", "hello", 10, 3, []string{"hello"}},
+		{"between words", "hello world foo", 11, 5, []string{"hello world", "foo"}},
+		{"long word", "abcdefghij", 4, 5, []string{"abcd", "efgh", "ij"}},
+		{"paragraphs", "a\n\nb\n\n", 10, 5, []string{"a", "", "b"}},
+		{"runes", "héllo wörld", 5, 5, []string{"héllo", "wörld"}},
+		{"more rows", "one two three four", 9, 2, []string{"one two", "three..."}},
+		{"full last row", "abcdefgh ijk", 8, 1, []string{"abcde..."}},
+		{"no columns", "hello", 0, 3, nil},
+		{"no rows", "hello", 3, 0, nil},
+	}
+	for _, c := range cases {
+		if got := wrap(c.value, c.columns, c.rows); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: wrap(%q, %d, %d) = %q, want %q", c.name, c.value, c.columns, c.rows, got, c.want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	cases := []struct {
+		value string
+		n     int
+		want  string
+	}{
+		{"hello", 5, "hello"},
+		{"hello world", 8, "hello..."},
+		{"hello", 2, "he"},
+		{"héllo wörld", 6, "hél..."},
+	}
+	for _, c := range cases {
+		if got := truncate(c.value, c.n); got != c.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", c.value, c.n, got, c.want)
+		}
+	}
+}
+
+func TestClip(t *testing.T) {
+	cases := []struct {
+		name           string
+		x0, y0, x1, y1 float64
+		start, end     float64
+		ok             bool
+	}{
+		{"inside", 1, 1, 9, 9, 0, 1, true},
+		{"across", -5, 5, 15, 5, 0.25, 0.75, true},
+		{"into a corner", 5, 5, 15, 15, 0, 0.5, true},
+		{"outside", -5, -5, -1, 20, 0, 0, false},
+		{"along an edge outside", 0, -1, 10, -1, 0, 0, false},
+		{"past a corner", -10, 5, 5, -10, 0, 0, false},
+	}
+	for _, c := range cases {
+		start, end, ok := clip(c.x0, c.y0, c.x1, c.y1, 0, 0, 10, 10)
+		if ok != c.ok || (ok && (math.Abs(start-c.start) > 1e-9 || math.Abs(end-c.end) > 1e-9)) {
+			t.Errorf("%s: clip gave %v, %v, %v, want %v, %v, %v", c.name, start, end, ok, c.start, c.end, c.ok)
+		}
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	fallback := color.RGBA{1, 2, 3, 0xff}
+	cases := []struct {
+		value string
+		want  color.RGBA
+	}{
+		{"#fff", color.RGBA{0xff, 0xff, 0xff, 0xff}},
+		{"#1a2B3c", color.RGBA{0x1a, 0x2b, 0x3c, 0xff}},
+		{"1a2b3c", color.RGBA{0x1a, 0x2b, 0x3c, 0xff}},
+		{"red", fallback},
+		{"#12345", fallback},
+		{"#gggggg", fallback},
+		{"", fallback},
+	}
+	for _, c := range cases {
+		if got := parseColor(c.value, fallback); got != c.want {
+			t.Errorf("parseColor(%q) = %v, want %v", c.value, got, c.want)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	note, file := uuid.New(), uuid.New()
+	canvas := Canvas{
+		Nodes: []Node{
+			{ID: note, Kind: KindNote, Text: "a <b> & c", X: 0, Y: 0, Width: 200, Height: 100},
+			{ID: file, Kind: KindFile, Name: "notes.txt", X: 300, Y: 0},
+		},
+		Edges: []Edge{
+			{From: note, To: file, Style: StyleDashed, EndArrow: true},
+			{From: note, To: uuid.New()},
+		},
+	}
+
+	var svg bytes.Buffer
+	if err := SVG(&svg, canvas, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	out := svg.String()
+	if !strings.Contains(out, "a &lt;b&gt; &amp; c") || !strings.Contains(out, "notes.txt") {
+		t.Errorf("the text is missing or not escaped:\n%s", out)
+	}
+	// the edge to a node that is not on the canvas is left out
+	if n := strings.Count(out, "<line "); n != 1 {
+		t.Errorf("drew %d lines, want 1", n)
+	}
+
+	var img bytes.Buffer
+	viewport := &Rect{MinX: 0, MinY: 0, MaxX: 100, MaxY: 50}
+	if err := PNG(&img, canvas, Options{Viewport: viewport, Scale: 2}); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := png.Decode(&img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := decoded.Bounds().Size(); size.X != 200 || size.Y != 100 {
+		t.Errorf("the image is %v, want 200x100", size)
+	}
+
+	for _, scale := range []float64{-1, MaxScale + 1, math.NaN()} {
+		if err := SVG(&svg, canvas, Options{Scale: scale}); !errors.Is(err, ErrInvalidScale) {
+			t.Errorf("a scale of %v gave %v, want ErrInvalidScale", scale, err)
+		}
+	}
+	huge := &Rect{MinX: 0, MinY: 0, MaxX: MaxPixels, MaxY: 10}
+	if err := PNG(&img, canvas, Options{Viewport: huge, Scale: 2}); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("an image too wide gave %v, want ErrTooLarge", err)
+	}
+}
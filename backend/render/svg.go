@@ -0,0 +1,71 @@
+package render
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// SVG writes the canvas as an SVG image, its viewBox is in canvas coordinates
+// so the image can be scaled further without losing detail
+func SVG(w io.Writer, canvas Canvas, opts Options) error {
+	view, scale, err := frame(canvas, opts)
+	if err != nil {
+		return err
+	}
+	width, height := view.MaxX-view.MinX, view.MaxY-view.MinY
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, `<svg xmlns="http://www.w3.org/2000/svg" width="%s" height="%s" viewBox="%s %s %s %s" font-family="monospace" font-size="%d">`+"\n",
+		number(width*scale), number(height*scale), number(view.MinX), number(view.MinY), number(width), number(height), fontSize)
+	fmt.Fprintf(&out, `<rect x="%s" y="%s" width="%s" height="%s" fill="%s"/>`+"\n",
+		number(view.MinX), number(view.MinY), number(width), number(height), hex(background))
+	for _, s := range scene(canvas, view) {
+		switch s.kind {
+		case shapeRect:
+			fmt.Fprintf(&out, `<rect x="%s" y="%s" width="%s" height="%s" fill="%s" stroke="%s" stroke-width="%s"/>`+"\n",
+				number(s.points[0].X), number(s.points[0].Y), number(s.points[1].X-s.points[0].X), number(s.points[1].Y-s.points[0].Y),
+				hex(s.fill), hex(s.stroke), number(s.width))
+		case shapePolygon:
+			points := make([]string, len(s.points))
+			for i, p := range s.points {
+				points[i] = number(p.X) + "," + number(p.Y)
+			}
+			fmt.Fprintf(&out, `<polygon points="%s" fill="%s"/>`+"\n", strings.Join(points, " "), hex(s.fill))
+		case shapeLine:
+			dash := ""
+			if len(s.dash) == 2 {
+				dash = fmt.Sprintf(` stroke-dasharray="%s %s"`, number(s.dash[0]), number(s.dash[1]))
+			}
+			fmt.Fprintf(&out, `<line x1="%s" y1="%s" x2="%s" y2="%s" stroke="%s" stroke-width="%s"%s/>`+"\n",
+				number(s.points[0].X), number(s.points[0].Y), number(s.points[1].X), number(s.points[1].Y),
+				hex(s.stroke), number(s.width), dash)
+		case shapeText:
+			fmt.Fprintf(&out, `<text x="%s" y="%s" fill="%s" xml:space="preserve">`, number(s.points[0].X), number(s.points[0].Y), hex(s.fill))
+			if err := xml.EscapeText(&out, []byte(s.text)); err != nil {
+				return err
+			}
+			out.WriteString("</text>\n")
+		}
+	}
+	out.WriteString("</svg>\n")
+	_, err = out.WriteTo(w)
+	return err
+}
+
+// number writes a coordinate with at most two decimals
+func number(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
+
+func hex(c color.RGBA) string {
+	if c.A == 0 {
+		return "none"
+	}
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
@@ -0,0 +1,110 @@
+package routes
+
+import (
+	"cascloud/models"
+
+	"archive/zip"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// a function to download a folder with everything below it as a ZIP archive.
+// The archive is written while the files are read from storage, so nothing is
+// held in memory or on disk and the size is not known up front.
+func (h *HandlerClient) DownloadFolder(c echo.Context) error {
+	folders, files, err := h.DBClient.GetFolderTree(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting folder from database")
+		return c.JSON(404, "Folder not found")
+	}
+	root := folders[0]
+	if err := h.authorizeFolder(c, &root, models.RoleViewer); err != nil {
+		return err
+	}
+
+	// every folder is a directory of the archive named after the folders above it
+	dirs := map[uuid.UUID]string{root.ID: archiveName(root.Name)}
+	for _, folder := range folders[1:] {
+		// parents have shorter paths so they come first
+		if parent, ok := dirs[folder.ParentID]; ok {
+			dirs[folder.ID] = path.Join(parent, archiveName(folder.Name))
+		}
+	}
+	entries := make([]archiveEntry, 0, len(files))
+	for i := range files {
+		if dir, ok := dirs[files[i].FolderID]; ok {
+			entries = append(entries, archiveEntry{name: path.Join(dir, archiveName(files[i].Name)), file: &files[i]})
+		}
+	}
+	for _, dir := range dirs {
+		entries = append(entries, archiveEntry{name: dir + "/"})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, "application/zip")
+	header.Set(echo.HeaderContentDisposition, contentDisposition(c, root.Name+".zip", "application/zip"))
+	c.Response().WriteHeader(http.StatusOK)
+
+	// once the archive has started the status can not change anymore, when a
+	// file fails the archive is cut off without its directory so it does not
+	// pass for a complete one
+	archive := zip.NewWriter(c.Response())
+	for _, entry := range entries {
+		if err := h.writeArchiveEntry(c, archive, entry); err != nil {
+			log.Error().Err(err).Str("entry", entry.name).Msg("Error writing folder archive")
+			return nil
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Error().Err(err).Msg("Error writing folder archive")
+	}
+	return nil
+}
+
+// archiveEntry is a file of a folder archive, or a directory when file is nil
+type archiveEntry struct {
+	name string
+	file *models.File
+}
+
+func (h *HandlerClient) writeArchiveEntry(c echo.Context, archive *zip.Writer, entry archiveEntry) error {
+	if entry.file == nil {
+		_, err := archive.CreateHeader(&zip.FileHeader{Name: entry.name, Method: zip.Store})
+		return err
+	}
+	data, info, err := h.S3Client.DownloadFile(c.Request().Context(), entry.file.StorageKey, nil)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+	writer, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     entry.name,
+		Method:   zip.Deflate,
+		Modified: info.LastModified,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, data)
+	return err
+}
+
+// archiveName keeps a name from escaping its directory in the archive
+func archiveName(name string) string {
+	if validFileName(name) {
+		return name
+	}
+	name = strings.NewReplacer("/", "_", "\\", "_", "\x00", "_").Replace(name)
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+	return name
+}
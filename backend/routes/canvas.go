@@ -0,0 +1,250 @@
+package routes
+
+import (
+	"cascloud/events"
+	"cascloud/models"
+
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// limits of what a canvas item may hold
+const (
+	maxTitleLength = 200
+	maxNoteLength  = 10000
+	maxURLLength   = 2048
+)
+
+// colors are given as #rgb or #rrggbb
+var colorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// the size a note or link gets when none is given, frames always need one
+var defaultCanvasItemSizes = map[string][2]float64{
+	models.CanvasItemNote: {200, 200},
+	models.CanvasItemLink: {240, 80},
+}
+
+// a function to put a note, link or frame on the canvas of a folder
+func (h *HandlerClient) CreateCanvasItem(c echo.Context) error {
+	var itemReq models.CanvasItemRequest
+	bindErr := c.Bind(&itemReq)
+	if bindErr != nil {
+		return bindErr
+	}
+	if itemReq.FolderID == "" {
+		log.Error().Msg("Folder ID not provided")
+		return c.JSON(400, "Folder ID not provided")
+	}
+	switch itemReq.Kind {
+	case models.CanvasItemNote, models.CanvasItemLink, models.CanvasItemFrame:
+	default:
+		return c.JSON(400, "Kind must be note, link or frame")
+	}
+	folder, err := h.DBClient.GetFolderByID(itemReq.FolderID)
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting folder from database")
+		return c.JSON(404, "Folder not found")
+	}
+
+	item := models.CanvasItem{
+		FolderID: folder.ID,
+		Kind:     itemReq.Kind,
+	}
+	if err := h.authorizeCanvasItem(c, folder, &item); err != nil {
+		return err
+	}
+	if size, ok := defaultCanvasItemSizes[item.Kind]; ok {
+		item.Width, item.Height = size[0], size[1]
+	}
+	if item.Kind == models.CanvasItemNote {
+		item.Format = models.NoteFormatText
+	}
+	if err := applyCanvasItemRequest(&item, itemReq); err != nil {
+		return c.JSON(400, err.Error())
+	}
+	item.CreatedBy = callerID(c)
+
+	createErr := h.DBClient.CreateCanvasItem(&item)
+	if createErr != nil {
+		log.Error().Err(createErr).Msg("Error creating canvas item in database")
+		return c.JSON(400, "Error creating canvas item in database")
+	}
+
+	h.publish(c, events.ItemCreated, folder.WorkspaceID, folder.ID, item)
+	return c.JSON(http.StatusCreated, item)
+}
+
+// a function to get a note, link or frame
+func (h *HandlerClient) GetCanvasItem(c echo.Context) error {
+	item, err := h.DBClient.GetCanvasItemByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting canvas item from database")
+		return c.JSON(404, "Canvas item not found")
+	}
+	if err := h.authorizeFolderID(c, item.FolderID.String(), models.RoleViewer); err != nil {
+		return err
+	}
+	return c.JSON(200, item)
+}
+
+// a function to edit a note, link or frame, it can also move to another folder
+func (h *HandlerClient) UpdateCanvasItem(c echo.Context) error {
+	var itemReq models.CanvasItemRequest
+	bindErr := c.Bind(&itemReq)
+	if bindErr != nil {
+		return bindErr
+	}
+	item, err := h.DBClient.GetCanvasItemByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting canvas item from database")
+		return c.JSON(404, "Canvas item not found")
+	}
+	if itemReq.Kind != "" && itemReq.Kind != item.Kind {
+		return c.JSON(400, "The kind of a canvas item cannot change")
+	}
+	source, folderErr := h.DBClient.GetFolderByID(item.FolderID.String())
+	if folderErr != nil {
+		log.Error().Err(folderErr).Msg("Error getting folder from database")
+		return c.JSON(400, "Error getting folder from database")
+	}
+	if err := h.authorizeCanvasItem(c, source, item); err != nil {
+		return err
+	}
+	dest := source
+	if itemReq.FolderID != "" && itemReq.FolderID != source.ID.String() {
+		dest, folderErr = h.DBClient.GetFolderByID(itemReq.FolderID)
+		if folderErr != nil {
+			log.Error().Err(folderErr).Msg("Error getting folder from database")
+			return c.JSON(404, "Folder not found")
+		}
+		if err := h.authorizeCanvasItem(c, dest, item); err != nil {
+			return err
+		}
+	}
+
+	item.FolderID = dest.ID
+	if err := applyCanvasItemRequest(item, itemReq); err != nil {
+		return c.JSON(400, err.Error())
+	}
+	updateErr := h.DBClient.UpdateCanvasItem(item)
+	if updateErr != nil {
+		log.Error().Err(updateErr).Msg("Error updating canvas item in database")
+		return c.JSON(400, "Error updating canvas item in database")
+	}
+
+	if dest.ID != source.ID {
+		h.publishMove(c, events.ItemMoved, source.ID, dest, item)
+	} else {
+		h.publish(c, events.ItemUpdated, dest.WorkspaceID, dest.ID, item)
+	}
+	return c.JSON(200, item)
+}
+
+// a function to remove a note, link or frame from its canvas
+func (h *HandlerClient) DeleteCanvasItem(c echo.Context) error {
+	item, err := h.DBClient.GetCanvasItemByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting canvas item from database")
+		return c.JSON(404, "Canvas item not found")
+	}
+	folder, folderErr := h.DBClient.GetFolderByID(item.FolderID.String())
+	if folderErr != nil {
+		log.Error().Err(folderErr).Msg("Error getting folder from database")
+		return c.JSON(400, "Error getting folder from database")
+	}
+	if err := h.authorizeCanvasItem(c, folder, item); err != nil {
+		return err
+	}
+	deleteErr := h.DBClient.DeleteCanvasItem(item)
+	if deleteErr != nil {
+		log.Error().Err(deleteErr).Msg("Error deleting canvas item from database")
+		return c.JSON(400, "Error deleting canvas item from database")
+	}
+
+	h.publish(c, events.ItemDeleted, folder.WorkspaceID, folder.ID, item)
+	return c.JSON(200, item)
+}
+
+// applyCanvasItemRequest copies the given fields of a request onto an item and
+// checks that the result makes sense for its kind
+func applyCanvasItemRequest(item *models.CanvasItem, itemReq models.CanvasItemRequest) error {
+	if itemReq.X != nil {
+		item.X = *itemReq.X
+	}
+	if itemReq.Y != nil {
+		item.Y = *itemReq.Y
+	}
+	if itemReq.Width != nil {
+		item.Width = *itemReq.Width
+	}
+	if itemReq.Height != nil {
+		item.Height = *itemReq.Height
+	}
+	if itemReq.Title != nil {
+		item.Title = *itemReq.Title
+	}
+	if itemReq.Text != nil {
+		item.Text = *itemReq.Text
+	}
+	if itemReq.Format != nil {
+		item.Format = *itemReq.Format
+	}
+	if itemReq.URL != nil {
+		item.URL = *itemReq.URL
+	}
+	if itemReq.Color != nil {
+		item.Color = *itemReq.Color
+	}
+
+	if !validCoordinate(item.X) || !validCoordinate(item.Y) {
+		return errors.New("Invalid coordinates")
+	}
+	if !(item.Width > 0) || !(item.Height > 0) || item.Width > maxCoordinate || item.Height > maxCoordinate {
+		return errors.New("Width and height must be greater than zero")
+	}
+	if utf8.RuneCountInString(item.Title) > maxTitleLength {
+		return fmt.Errorf("The title can be at most %d characters", maxTitleLength)
+	}
+	if item.Color != "" && !colorPattern.MatchString(item.Color) {
+		return errors.New("Color must look like #rrggbb")
+	}
+
+	switch item.Kind {
+	case models.CanvasItemNote:
+		if item.Format != models.NoteFormatText && item.Format != models.NoteFormatMarkdown {
+			return errors.New("Format must be text or markdown")
+		}
+		if utf8.RuneCountInString(item.Text) > maxNoteLength {
+			return fmt.Errorf("A note can be at most %d characters", maxNoteLength)
+		}
+	case models.CanvasItemLink:
+		if !validLinkURL(item.URL) {
+			return errors.New("A link needs an http or https URL")
+		}
+	case models.CanvasItemFrame:
+		if item.Title == "" {
+			return errors.New("A frame needs a title")
+		}
+	}
+	return nil
+}
+
+// validLinkURL only accepts absolute web addresses so a link can not run
+// scripts when it is opened
+func validLinkURL(raw string) bool {
+	if raw == "" || len(raw) > maxURLLength {
+		return false
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
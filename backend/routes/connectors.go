@@ -0,0 +1,209 @@
+package routes
+
+import (
+	"cascloud/events"
+	"cascloud/models"
+
+	"errors"
+	"fmt"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// how long the label of a connector may be
+const maxLabelLength = 200
+
+// a function to connect two files, folders or canvas items of the same folder
+func (h *HandlerClient) CreateConnector(c echo.Context) error {
+	var connectorReq models.ConnectorRequest
+	bindErr := c.Bind(&connectorReq)
+	if bindErr != nil {
+		return bindErr
+	}
+	fromID, fromErr := uuid.Parse(connectorReq.FromID)
+	toID, toErr := uuid.Parse(connectorReq.ToID)
+	if fromErr != nil || toErr != nil {
+		return c.JSON(400, "Both ends of a connector are required")
+	}
+	if fromID == toID {
+		return c.JSON(400, "A connector needs two different ends")
+	}
+	fromFolderID, err := h.connectorEndFolder(connectorReq.FromType, fromID)
+	if err != nil {
+		return err
+	}
+	toFolderID, err := h.connectorEndFolder(connectorReq.ToType, toID)
+	if err != nil {
+		return err
+	}
+	if fromFolderID != toFolderID {
+		return c.JSON(400, "Both ends of a connector must be in the same folder")
+	}
+	folder, folderErr := h.DBClient.GetFolderByID(fromFolderID.String())
+	if folderErr != nil {
+		log.Error().Err(folderErr).Msg("Error getting folder from database")
+		return c.JSON(404, "Folder not found")
+	}
+	if err := h.authorizeFolder(c, folder, models.RoleEditor); err != nil {
+		return err
+	}
+
+	connector := models.Connector{
+		FolderID:  folder.ID,
+		FromType:  connectorReq.FromType,
+		FromID:    fromID,
+		ToType:    connectorReq.ToType,
+		ToID:      toID,
+		Style:     models.ConnectorStyleSolid,
+		Direction: models.ConnectorDirectionForward,
+		CreatedBy: callerID(c),
+	}
+	if err := applyConnectorRequest(&connector, connectorReq); err != nil {
+		return c.JSON(400, err.Error())
+	}
+
+	createErr := h.DBClient.CreateConnector(&connector)
+	if createErr != nil {
+		log.Error().Err(createErr).Msg("Error creating connector in database")
+		return c.JSON(400, "Error creating connector in database")
+	}
+
+	h.publish(c, events.ConnectorCreated, folder.WorkspaceID, folder.ID, connector)
+	return c.JSON(http.StatusCreated, connector)
+}
+
+// a function to get a connector
+func (h *HandlerClient) GetConnector(c echo.Context) error {
+	connector, err := h.DBClient.GetConnectorByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting connector from database")
+		return c.JSON(404, "Connector not found")
+	}
+	if err := h.authorizeFolderID(c, connector.FolderID.String(), models.RoleViewer); err != nil {
+		return err
+	}
+	return c.JSON(200, connector)
+}
+
+// a function to change the label, style or direction of a connector
+func (h *HandlerClient) UpdateConnector(c echo.Context) error {
+	var connectorReq models.ConnectorRequest
+	bindErr := c.Bind(&connectorReq)
+	if bindErr != nil {
+		return bindErr
+	}
+	connector, err := h.DBClient.GetConnectorByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting connector from database")
+		return c.JSON(404, "Connector not found")
+	}
+	if connectorReq.FromID != "" || connectorReq.ToID != "" || connectorReq.FromType != "" || connectorReq.ToType != "" {
+		return c.JSON(400, "The ends of a connector cannot change")
+	}
+	folder, folderErr := h.DBClient.GetFolderByID(connector.FolderID.String())
+	if folderErr != nil {
+		log.Error().Err(folderErr).Msg("Error getting folder from database")
+		return c.JSON(400, "Error getting folder from database")
+	}
+	if err := h.authorizeFolder(c, folder, models.RoleEditor); err != nil {
+		return err
+	}
+	if err := applyConnectorRequest(connector, connectorReq); err != nil {
+		return c.JSON(400, err.Error())
+	}
+	updateErr := h.DBClient.UpdateConnector(connector)
+	if updateErr != nil {
+		log.Error().Err(updateErr).Msg("Error updating connector in database")
+		return c.JSON(400, "Error updating connector in database")
+	}
+
+	h.publish(c, events.ConnectorUpdated, folder.WorkspaceID, folder.ID, connector)
+	return c.JSON(200, connector)
+}
+
+// a function to remove a connector, its ends stay where they are
+func (h *HandlerClient) DeleteConnector(c echo.Context) error {
+	connector, err := h.DBClient.GetConnectorByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting connector from database")
+		return c.JSON(404, "Connector not found")
+	}
+	folder, folderErr := h.DBClient.GetFolderByID(connector.FolderID.String())
+	if folderErr != nil {
+		log.Error().Err(folderErr).Msg("Error getting folder from database")
+		return c.JSON(400, "Error getting folder from database")
+	}
+	if err := h.authorizeFolder(c, folder, models.RoleEditor); err != nil {
+		return err
+	}
+	deleteErr := h.DBClient.DeleteConnector(connector)
+	if deleteErr != nil {
+		log.Error().Err(deleteErr).Msg("Error deleting connector from database")
+		return c.JSON(400, "Error deleting connector from database")
+	}
+
+	h.publish(c, events.ConnectorDeleted, folder.WorkspaceID, folder.ID, connector)
+	return c.JSON(200, connector)
+}
+
+// connectorEndFolder is the folder on whose canvas one end of a connector sits
+func (h *HandlerClient) connectorEndFolder(endType string, id uuid.UUID) (uuid.UUID, error) {
+	switch endType {
+	case models.ConnectorEndFile:
+		file, err := h.DBClient.GetFileByID(id.String())
+		if err != nil {
+			log.Error().Err(err).Msg("Error getting file from database")
+			return uuid.Nil, echo.NewHTTPError(http.StatusNotFound, "File not found")
+		}
+		return file.FolderID, nil
+	case models.ConnectorEndFolder:
+		folder, err := h.DBClient.GetFolderByID(id.String())
+		if err != nil {
+			log.Error().Err(err).Msg("Error getting folder from database")
+			return uuid.Nil, echo.NewHTTPError(http.StatusNotFound, "Folder not found")
+		}
+		return folder.ParentID, nil
+	case models.ConnectorEndItem:
+		item, err := h.DBClient.GetCanvasItemByID(id.String())
+		if err != nil {
+			log.Error().Err(err).Msg("Error getting canvas item from database")
+			return uuid.Nil, echo.NewHTTPError(http.StatusNotFound, "Canvas item not found")
+		}
+		return item.FolderID, nil
+	}
+	return uuid.Nil, echo.NewHTTPError(http.StatusBadRequest, "The ends of a connector must be a file, folder or item")
+}
+
+// applyConnectorRequest copies the given fields of a request onto a connector
+// and checks them
+func applyConnectorRequest(connector *models.Connector, connectorReq models.ConnectorRequest) error {
+	if connectorReq.Label != nil {
+		connector.Label = *connectorReq.Label
+	}
+	if connectorReq.Style != nil {
+		connector.Style = *connectorReq.Style
+	}
+	if connectorReq.Direction != nil {
+		connector.Direction = *connectorReq.Direction
+	}
+
+	if utf8.RuneCountInString(connector.Label) > maxLabelLength {
+		return fmt.Errorf("The label can be at most %d characters", maxLabelLength)
+	}
+	switch connector.Style {
+	case models.ConnectorStyleSolid, models.ConnectorStyleDashed, models.ConnectorStyleDotted:
+	default:
+		return errors.New("Style must be solid, dashed or dotted")
+	}
+	switch connector.Direction {
+	case models.ConnectorDirectionForward, models.ConnectorDirectionBackward,
+		models.ConnectorDirectionBoth, models.ConnectorDirectionNone:
+	default:
+		return errors.New("Direction must be forward, backward, both or none")
+	}
+	return nil
+}
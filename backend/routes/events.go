@@ -0,0 +1,72 @@
+package routes
+
+import (
+	"cascloud/events"
+	"cascloud/models"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// a function to subscribe to the changes of a workspace over a websocket,
+// every message is a JSON encoded events.Event. Clients share their presence
+// on the canvas over the same connection, see events/presence.go
+func (h *HandlerClient) WorkspaceEvents(c echo.Context) error {
+	workspace, err := h.DBClient.GetWorkspaceByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting workspace from database")
+		return c.JSON(404, "Workspace not found")
+	}
+	if err := h.authorizeWorkspace(c, workspace.ID, models.RoleViewer); err != nil {
+		return err
+	}
+	if h.Events == nil {
+		return c.JSON(503, "Events are not enabled")
+	}
+
+	conn, upgradeErr := upgrader.Upgrade(c.Response(), c.Request(), nil)
+	if upgradeErr != nil {
+		// the upgrader already answered the request
+		log.Error().Err(upgradeErr).Msg("Error upgrading to websocket")
+		return nil
+	}
+	sub := h.Events.Subscribe(workspace.ID, callerID(c))
+	events.Serve(conn, h.Events, sub)
+	return nil
+}
+
+// publish pushes a change to the collaborators of a workspace
+func (h *HandlerClient) publish(c echo.Context, eventType string, workspaceID uuid.UUID, folderID uuid.UUID, data interface{}) {
+	if h.Events == nil {
+		return
+	}
+	h.Events.Publish(events.Event{
+		Type:        eventType,
+		WorkspaceID: workspaceID,
+		FolderID:    folderID,
+		ActorID:     callerID(c),
+		Data:        data,
+	})
+}
+
+// publishMove pushes a move into dest, collaborators of the workspace the item
+// came from are told as well when it left their workspace
+func (h *HandlerClient) publishMove(c echo.Context, eventType string, sourceFolderID uuid.UUID, dest *models.Folder, data interface{}) {
+	if h.Events == nil {
+		return
+	}
+	h.publish(c, eventType, dest.WorkspaceID, dest.ID, data)
+	source, err := h.DBClient.GetFolderByID(sourceFolderID.String())
+	if err == nil && source.WorkspaceID != dest.WorkspaceID {
+		h.publish(c, eventType, source.WorkspaceID, dest.ID, data)
+	}
+}
+
+// commitEvent is the event of a committed file, its first version makes it new
+func commitEvent(file *models.File) string {
+	if file.Version > 1 {
+		return events.FileUpdated
+	}
+	return events.FileCreated
+}
@@ -0,0 +1,154 @@
+package routes
+
+import (
+	"cascloud/layout"
+	"cascloud/models"
+	"cascloud/render"
+
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// a function to draw the canvas of a folder as an SVG or PNG image, the whole
+// canvas or only a viewport of it, at an optional scale
+func (h *HandlerClient) ExportCanvas(c echo.Context) error {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "svg"
+	}
+	if format != "svg" && format != "png" {
+		return c.JSON(400, "Format must be svg or png")
+	}
+	var scale float64
+	if raw := c.QueryParam("scale"); raw != "" {
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return c.JSON(400, "Invalid scale")
+		}
+		scale = value
+	}
+	viewport, viewportErr := readViewport(c)
+	if viewportErr != nil {
+		return viewportErr
+	}
+	folder, err := h.DBClient.GetFolderByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting folder from database")
+		return c.JSON(404, "Folder not found")
+	}
+	if err := h.authorizeFolder(c, folder, models.RoleViewer); err != nil {
+		return err
+	}
+
+	canvas, canvasErr := h.loadCanvas(folder, viewport)
+	if canvasErr != nil {
+		log.Error().Err(canvasErr).Msg("Error getting folder contents from database")
+		return c.JSON(400, "Error getting folder contents from database")
+	}
+	opts := render.Options{Scale: scale}
+	if viewport != nil {
+		opts.Viewport = &render.Rect{MinX: viewport.MinX, MinY: viewport.MinY, MaxX: viewport.MaxX, MaxY: viewport.MaxY}
+	}
+	var image bytes.Buffer
+	contentType := "image/svg+xml"
+	var renderErr error
+	if format == "png" {
+		contentType = "image/png"
+		renderErr = render.PNG(&image, canvas, opts)
+	} else {
+		renderErr = render.SVG(&image, canvas, opts)
+	}
+	switch {
+	case errors.Is(renderErr, render.ErrInvalidScale):
+		return c.JSON(400, fmt.Sprintf("Scale must be greater than 0 and at most %d", render.MaxScale))
+	case errors.Is(renderErr, render.ErrTooLarge):
+		return c.JSON(400, fmt.Sprintf("The image can be at most %d pixels wide and high, use a smaller viewport or scale", render.MaxPixels))
+	case renderErr != nil:
+		log.Error().Err(renderErr).Msg("Error rendering canvas")
+		return c.JSON(400, "Error rendering canvas")
+	}
+
+	c.Response().Header().Set("Content-Disposition", contentDisposition(c, folder.Name+"."+format, contentType))
+	c.Response().Header().Set(echo.HeaderXContentTypeOptions, "nosniff")
+	return c.Blob(200, contentType, image.Bytes())
+}
+
+// loadCanvas gets what is on the canvas of a folder, or with a viewport only
+// what can be seen in it, a connector is drawn when both of its ends are
+func (h *HandlerClient) loadCanvas(folder *models.Folder, viewport *models.Viewport) (render.Canvas, error) {
+	folderID := folder.ID.String()
+	var folders []models.Folder
+	var files []models.File
+	var items []models.CanvasItem
+	var connectors []models.Connector
+	var err error
+	if viewport == nil {
+		if folders, files, err = h.DBClient.GetFoldersAndFilesInFolder(folderID); err != nil {
+			return render.Canvas{}, err
+		}
+		if items, err = h.DBClient.GetCanvasItemsInFolder(folderID); err != nil {
+			return render.Canvas{}, err
+		}
+		if connectors, err = h.DBClient.GetConnectorsInFolder(folderID); err != nil {
+			return render.Canvas{}, err
+		}
+	} else {
+		// files and folders are found by their top left corner
+		cards := *viewport
+		cards.MinX -= layout.ItemWidth
+		cards.MinY -= layout.ItemHeight
+		if folders, files, err = h.DBClient.GetFoldersAndFilesInViewport(folderID, cards); err != nil {
+			return render.Canvas{}, err
+		}
+		if items, err = h.DBClient.GetCanvasItemsInViewport(folderID, *viewport); err != nil {
+			return render.Canvas{}, err
+		}
+	}
+
+	var canvas render.Canvas
+	for _, child := range folders {
+		canvas.Nodes = append(canvas.Nodes, render.Node{ID: child.ID, Kind: render.KindFolder, Name: child.Name, X: child.X, Y: child.Y})
+	}
+	for _, file := range files {
+		canvas.Nodes = append(canvas.Nodes, render.Node{ID: file.ID, Kind: render.KindFile, Name: file.Name, X: file.X, Y: file.Y})
+	}
+	for _, item := range items {
+		node := render.Node{ID: item.ID, Name: item.Title, Text: item.Text, X: item.X, Y: item.Y, Width: item.Width, Height: item.Height, Color: item.Color}
+		switch item.Kind {
+		case models.CanvasItemNote:
+			node.Kind = render.KindNote
+		case models.CanvasItemLink:
+			node.Kind = render.KindLink
+			node.Text = item.URL
+		case models.CanvasItemFrame:
+			node.Kind = render.KindFrame
+		}
+		canvas.Nodes = append(canvas.Nodes, node)
+	}
+
+	if viewport != nil {
+		ids := make([]string, 0, len(canvas.Nodes))
+		for _, node := range canvas.Nodes {
+			ids = append(ids, node.ID.String())
+		}
+		if connectors, err = h.DBClient.GetConnectorsOfItems(folderID, ids); err != nil {
+			return render.Canvas{}, err
+		}
+	}
+	for _, connector := range connectors {
+		canvas.Edges = append(canvas.Edges, render.Edge{
+			From:       connector.FromID,
+			To:         connector.ToID,
+			Label:      connector.Label,
+			Style:      connector.Style,
+			StartArrow: connector.Direction == models.ConnectorDirectionBackward || connector.Direction == models.ConnectorDirectionBoth,
+			EndArrow:   connector.Direction == models.ConnectorDirectionForward || connector.Direction == models.ConnectorDirectionBoth,
+		})
+	}
+	return canvas, nil
+}
@@ -0,0 +1,248 @@
+package routes
+
+import (
+	"cascloud/models"
+	"cascloud/types"
+
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// Every position and parent change of files and folders is recorded in the
+// layout journal of a folder as a step, one per request. Steps can be undone
+// and redone in order, and a canvas can be put back the way it was at a point
+// in time, which is recorded as a step of its own.
+
+// how many steps of the journal are listed by default and at most
+const (
+	defaultJournalLimit = 50
+	maxJournalLimit     = 500
+)
+
+// a function to list the layout journal of a folder, the newest steps first
+func (h *HandlerClient) GetLayoutHistory(c echo.Context) error {
+	folder, err := h.DBClient.GetFolderByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting folder from database")
+		return c.JSON(404, "Folder not found")
+	}
+	if err := h.authorizeFolder(c, folder, models.RoleViewer); err != nil {
+		return err
+	}
+	limit := defaultJournalLimit
+	if raw := c.QueryParam("limit"); raw != "" {
+		parsed, parseErr := strconv.Atoi(raw)
+		if parseErr != nil || parsed < 1 || parsed > maxJournalLimit {
+			return c.JSON(400, "Invalid limit")
+		}
+		limit = parsed
+	}
+	steps, stepsErr := h.DBClient.GetLayoutSteps(folder.ID.String(), limit)
+	if stepsErr != nil {
+		log.Error().Err(stepsErr).Msg("Error getting layout journal from database")
+		return c.JSON(400, "Error getting layout journal from database")
+	}
+	return c.JSON(200, steps)
+}
+
+// a function to undo the newest step of the layout journal of a folder
+func (h *HandlerClient) UndoLayout(c echo.Context) error {
+	return h.replayLayoutStep(c, false)
+}
+
+// a function to redo the step of the layout journal of a folder that was undone last
+func (h *HandlerClient) RedoLayout(c echo.Context) error {
+	return h.replayLayoutStep(c, true)
+}
+
+// replayLayoutStep puts the items of a step back where they were before it, or
+// for a redo where the step put them
+func (h *HandlerClient) replayLayoutStep(c echo.Context, redo bool) error {
+	folder, err := h.DBClient.GetFolderByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting folder from database")
+		return c.JSON(404, "Folder not found")
+	}
+	if err := h.authorizeFolder(c, folder, models.RoleEditor); err != nil {
+		return err
+	}
+	step, stepErr := h.DBClient.GetNextLayoutStep(folder.ID.String(), redo)
+	if stepErr != nil {
+		log.Error().Err(stepErr).Msg("Error getting layout journal from database")
+		return c.JSON(400, "Error getting layout journal from database")
+	}
+	if step == nil && redo {
+		return c.JSON(400, "Nothing to redo")
+	}
+	if step == nil {
+		return c.JSON(400, "Nothing to undo")
+	}
+
+	items := make([]models.LayoutItem, 0, len(step.Changes))
+	for _, change := range step.Changes {
+		if redo {
+			items = append(items, layoutItem(change.ItemType, change.ItemID, change.ToFolderID, change.ToX, change.ToY))
+		} else {
+			items = append(items, layoutItem(change.ItemType, change.ItemID, change.FromFolderID, change.FromX, change.FromY))
+		}
+	}
+	items, itemsErr := h.existingLayoutItems(items)
+	if itemsErr != nil {
+		log.Error().Err(itemsErr).Msg("Error getting items from database")
+		return c.JSON(400, "Error getting items from database")
+	}
+	return h.applyLayout(c, items, layoutJournal{step: step})
+}
+
+// a function to put the canvas of a folder back the way it was at a point in
+// time, items that left the folder since come back and items that came into
+// it go back to where they were
+func (h *HandlerClient) RestoreLayout(c echo.Context) error {
+	var restoreReq models.RestoreLayoutRequest
+	bindErr := c.Bind(&restoreReq)
+	if bindErr != nil {
+		return bindErr
+	}
+	if !restoreReq.At.IsValid() {
+		return c.JSON(400, "A time to restore to is required")
+	}
+	if restoreReq.At.After(types.NowSource()) {
+		return c.JSON(400, "Cannot restore to a time in the future")
+	}
+	folder, err := h.DBClient.GetFolderByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting folder from database")
+		return c.JSON(404, "Folder not found")
+	}
+	if err := h.authorizeFolder(c, folder, models.RoleEditor); err != nil {
+		return err
+	}
+	changes, changesErr := h.DBClient.GetLayoutChangesSince(folder.ID.String(), restoreReq.At.Time)
+	if changesErr != nil {
+		log.Error().Err(changesErr).Msg("Error getting layout journal from database")
+		return c.JSON(400, "Error getting layout journal from database")
+	}
+
+	// the first change of an item after the point in time says where it was
+	seen := make(map[uuid.UUID]bool, len(changes))
+	items := make([]models.LayoutItem, 0, len(changes))
+	for _, change := range changes {
+		if seen[change.ItemID] {
+			continue
+		}
+		seen[change.ItemID] = true
+		items = append(items, layoutItem(change.ItemType, change.ItemID, change.FromFolderID, change.FromX, change.FromY))
+	}
+	items, itemsErr := h.existingLayoutItems(items)
+	if itemsErr != nil {
+		log.Error().Err(itemsErr).Msg("Error getting items from database")
+		return c.JSON(400, "Error getting items from database")
+	}
+	return h.applyLayout(c, items, layoutJournal{action: models.LayoutActionRestore, canvas: folder.ID})
+}
+
+// existingLayoutItems leaves out the items that were deleted since they were
+// recorded, an item whose folder was deleted stays in the folder it is in now
+func (h *HandlerClient) existingLayoutItems(items []models.LayoutItem) ([]models.LayoutItem, error) {
+	var fileIDs, folderIDs []string
+	for _, item := range items {
+		if item.ItemType == models.LayoutItemFile {
+			fileIDs = append(fileIDs, item.ID)
+		} else {
+			folderIDs = append(folderIDs, item.ID)
+		}
+		folderIDs = append(folderIDs, item.FolderID)
+	}
+	files, err := h.DBClient.GetFilesByIDs(fileIDs)
+	if err != nil {
+		return nil, err
+	}
+	folders, err := h.DBClient.GetFoldersByIDs(folderIDs)
+	if err != nil {
+		return nil, err
+	}
+	exists := make(map[string]bool, len(files)+len(folders))
+	for _, file := range files {
+		exists[file.ID.String()] = true
+	}
+	for _, folder := range folders {
+		exists[folder.ID.String()] = true
+	}
+
+	kept := make([]models.LayoutItem, 0, len(items))
+	for _, item := range items {
+		if !exists[item.ID] {
+			continue
+		}
+		if !exists[item.FolderID] {
+			item.FolderID = ""
+		}
+		kept = append(kept, item)
+	}
+	return kept, nil
+}
+
+func layoutItem(itemType string, id uuid.UUID, folderID uuid.UUID, x float64, y float64) models.LayoutItem {
+	return models.LayoutItem{
+		ItemType: itemType,
+		ID:       id.String(),
+		X:        &x,
+		Y:        &y,
+		FolderID: folderID.String(),
+	}
+}
+
+// layoutChange is the journal entry of an item, nothing when it did not change
+func layoutChange(itemType string, id uuid.UUID, fromFolderID uuid.UUID, toFolderID uuid.UUID, fromX float64, fromY float64, toX float64, toY float64) []models.LayoutChange {
+	if fromFolderID == toFolderID && fromX == toX && fromY == toY {
+		return nil
+	}
+	return []models.LayoutChange{{
+		ItemType:     itemType,
+		ItemID:       id,
+		FromFolderID: fromFolderID,
+		ToFolderID:   toFolderID,
+		FromX:        fromX,
+		FromY:        fromY,
+		ToX:          toX,
+		ToY:          toY,
+	}}
+}
+
+// journalSteps turns the changes of a request into the steps to record, or
+// flips the step that is undone or redone
+func journalSteps(c echo.Context, journal layoutJournal, changes []models.LayoutChange) []models.LayoutStep {
+	if journal.step != nil {
+		step := *journal.step
+		step.Undone = !step.Undone
+		step.Changes = nil
+		return []models.LayoutStep{step}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	var steps []models.LayoutStep
+	stepOf := make(map[uuid.UUID]int)
+	for _, change := range changes {
+		folderID := journal.canvas
+		if folderID == uuid.Nil {
+			folderID = change.FromFolderID
+		}
+		i, ok := stepOf[folderID]
+		if !ok {
+			i = len(steps)
+			stepOf[folderID] = i
+			steps = append(steps, models.LayoutStep{
+				FolderID: folderID,
+				ActorID:  callerID(c),
+				Action:   journal.action,
+			})
+		}
+		steps[i].Changes = append(steps[i].Changes, change)
+	}
+	return steps
+}
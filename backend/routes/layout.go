@@ -0,0 +1,461 @@
+package routes
+
+import (
+	"cascloud/db"
+	"cascloud/events"
+	"cascloud/layout"
+	"cascloud/models"
+
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// the most items one layout request may place
+const maxLayoutItems = 500
+
+// items just outside the viewport are listed too so panning does not show
+// empty edges, the margin is this many pixels on screen
+const viewportOverscan = 200
+
+// the range of zoom levels a viewport may be requested at
+const (
+	minZoom = 0.01
+	maxZoom = 100.0
+)
+
+// movedTree is a folder that changes parent in a layout request together with
+// everything inside it, the root is folders[0]
+type movedTree struct {
+	folders []models.Folder
+	files   []models.File
+	dest    *models.Folder
+}
+
+// a function to place many files and folders on the canvas at once, items can
+// also move into another folder. Every item is checked before anything changes
+// and the database is updated in a single transaction.
+func (h *HandlerClient) UpdateLayout(c echo.Context) error {
+	var layoutReq models.UpdateLayoutRequest
+	bindErr := c.Bind(&layoutReq)
+	if bindErr != nil {
+		return bindErr
+	}
+	if len(layoutReq.Items) == 0 {
+		return c.JSON(400, "No items provided")
+	}
+	if len(layoutReq.Items) > maxLayoutItems {
+		return c.JSON(400, fmt.Sprintf("At most %d items can be placed at once", maxLayoutItems))
+	}
+
+	return h.applyLayout(c, layoutReq.Items, layoutJournal{action: models.LayoutActionLayout})
+}
+
+// layoutJournal says how a layout change is recorded in the layout journal
+type layoutJournal struct {
+	action string
+	// the folder whose journal gets every change, when it is nil each change
+	// goes to the journal of the folder the item was in
+	canvas uuid.UUID
+	// an undo or redo flips this step instead of recording a new one
+	step *models.LayoutStep
+}
+
+// applyLayout places and moves the items, records the change in the layout
+// journal and answers the request
+func (h *HandlerClient) applyLayout(c echo.Context, items []models.LayoutItem, journal layoutJournal) error {
+	var fileIDs, folderIDs, knownIDs []string
+	seen := make(map[string]bool, len(items))
+	for i, item := range items {
+		id, parseErr := uuid.Parse(item.ID)
+		if parseErr != nil {
+			return c.JSON(400, "Invalid item ID")
+		}
+		// compare the parsed ids so different spellings of one id are caught
+		items[i].ID = id.String()
+		if seen[id.String()] {
+			return c.JSON(400, "An item is listed more than once")
+		}
+		seen[id.String()] = true
+		if item.X == nil || item.Y == nil {
+			return c.JSON(400, "Every item needs x and y")
+		}
+		if !validCoordinate(*item.X) || !validCoordinate(*item.Y) {
+			return c.JSON(400, "Invalid coordinates")
+		}
+		if item.FolderID != "" {
+			folderID, folderErr := uuid.Parse(item.FolderID)
+			if folderErr != nil {
+				return c.JSON(400, "Invalid folder ID")
+			}
+			items[i].FolderID = folderID.String()
+			knownIDs = append(knownIDs, folderID.String())
+		}
+		switch item.ItemType {
+		case models.LayoutItemFile:
+			fileIDs = append(fileIDs, id.String())
+		case models.LayoutItemFolder:
+			folderIDs = append(folderIDs, id.String())
+			knownIDs = append(knownIDs, id.String())
+		default:
+			return c.JSON(400, "Item type must be file or folder")
+		}
+	}
+
+	files, filesErr := h.DBClient.GetFilesByIDs(fileIDs)
+	if filesErr != nil {
+		log.Error().Err(filesErr).Msg("Error getting files from database")
+		return c.JSON(400, "Error getting files from database")
+	}
+	if len(files) != len(fileIDs) {
+		return c.JSON(404, "File not found")
+	}
+	for _, file := range files {
+		knownIDs = append(knownIDs, file.FolderID.String())
+	}
+	// the folders of the request, the folders its files are in and the destinations
+	knownFolders, foldersErr := h.DBClient.GetFoldersByIDs(knownIDs)
+	if foldersErr != nil {
+		log.Error().Err(foldersErr).Msg("Error getting folders from database")
+		return c.JSON(400, "Error getting folders from database")
+	}
+	known := make(map[uuid.UUID]*models.Folder, len(knownFolders))
+	for i := range knownFolders {
+		known[knownFolders[i].ID] = &knownFolders[i]
+	}
+	fileByID := make(map[uuid.UUID]*models.File, len(files))
+	for i := range files {
+		fileByID[files[i].ID] = &files[i]
+	}
+
+	// every workspace that is touched needs an editor
+	workspaces := make(map[uuid.UUID]bool)
+	for _, id := range knownIDs {
+		folder, ok := known[uuid.MustParse(id)]
+		if !ok {
+			return c.JSON(404, "Folder not found")
+		}
+		if workspaces[folder.WorkspaceID] {
+			continue
+		}
+		if err := h.authorizeFolder(c, folder, models.RoleEditor); err != nil {
+			return err
+		}
+		workspaces[folder.WorkspaceID] = true
+	}
+
+	// work out which items change folder
+	var trees []movedTree
+	movedFiles := make(map[uuid.UUID]*models.Folder)
+	incoming := make(map[uuid.UUID][]string)
+	var leaving []uuid.UUID
+	for _, item := range items {
+		id := uuid.MustParse(item.ID)
+		if item.FolderID == "" {
+			continue
+		}
+		dest := known[uuid.MustParse(item.FolderID)]
+		if item.ItemType == models.LayoutItemFile {
+			file := fileByID[id]
+			if file.FolderID == dest.ID {
+				continue
+			}
+			if known[file.FolderID].WorkspaceID != dest.WorkspaceID {
+				return c.JSON(400, "Items cannot be moved to another workspace, copy them instead")
+			}
+			movedFiles[id] = dest
+			incoming[dest.ID] = append(incoming[dest.ID], file.Name)
+			leaving = append(leaving, id)
+			continue
+		}
+		folder := known[id]
+		if folder.ParentID == dest.ID {
+			continue
+		}
+		if folder.ParentID == uuid.Nil {
+			return c.JSON(400, "The home folder cannot be moved")
+		}
+		if folder.WorkspaceID != dest.WorkspaceID {
+			return c.JSON(400, "Items cannot be moved to another workspace, copy them instead")
+		}
+		treeFolders, treeFiles, treeErr := h.DBClient.GetFolderTree(item.ID)
+		if treeErr != nil {
+			log.Error().Err(treeErr).Msg("Error getting folder from database")
+			return c.JSON(400, "Error getting folder from database")
+		}
+		if inTree(treeFolders, dest.ID) {
+			return c.JSON(400, "A folder cannot be moved into itself or one of its subfolders")
+		}
+		trees = append(trees, movedTree{folders: treeFolders, files: treeFiles, dest: dest})
+		incoming[dest.ID] = append(incoming[dest.ID], folder.Name)
+		leaving = append(leaving, id)
+	}
+
+	// a moved folder takes its contents along, so nothing inside it may move on
+	// its own and nothing may move into it
+	for _, tree := range trees {
+		for _, other := range trees {
+			if other.folders[0].ID != tree.folders[0].ID && inTree(tree.folders, other.folders[0].ID) {
+				return c.JSON(400, "An item cannot be moved together with the folder it is in")
+			}
+			if inTree(tree.folders, other.dest.ID) {
+				return c.JSON(400, "Items cannot be moved into a folder that is moved in the same request")
+			}
+		}
+		for id, dest := range movedFiles {
+			if inTree(tree.folders, fileByID[id].FolderID) {
+				return c.JSON(400, "An item cannot be moved together with the folder it is in")
+			}
+			if inTree(tree.folders, dest.ID) {
+				return c.JSON(400, "Items cannot be moved into a folder that is moved in the same request")
+			}
+		}
+	}
+
+	for destID, names := range incoming {
+		taken, takenErr := h.takenNames(destID.String(), leaving...)
+		if takenErr != nil {
+			log.Error().Err(takenErr).Msg("Error getting folder contents from database")
+			return c.JSON(400, "Error getting folder contents from database")
+		}
+		for _, name := range names {
+			if taken[name] {
+				return c.JSON(http.StatusConflict, fmt.Sprintf("An item named %s already exists in the folder", name))
+			}
+			taken[name] = true
+		}
+	}
+
+	// collect the rows to save, a moved tree brings all of its rows along
+	var folderRows []models.Folder
+	var fileRows []models.File
+	folderRow := make(map[uuid.UUID]int)
+	fileRow := make(map[uuid.UUID]int)
+	for _, tree := range trees {
+		relocateTree(tree.folders, tree.files, tree.dest, tree.folders[0].Name)
+		tree.folders[0].ParentID = tree.dest.ID
+		for _, folder := range tree.folders {
+			folderRow[folder.ID] = len(folderRows)
+			folderRows = append(folderRows, folder)
+		}
+		for _, file := range tree.files {
+			fileRow[file.ID] = len(fileRows)
+			fileRows = append(fileRows, file)
+		}
+	}
+	for _, item := range items {
+		id := uuid.MustParse(item.ID)
+		if item.ItemType == models.LayoutItemFile {
+			i, ok := fileRow[id]
+			if !ok {
+				i = len(fileRows)
+				fileRow[id] = i
+				fileRows = append(fileRows, *fileByID[id])
+			}
+			if dest, moved := movedFiles[id]; moved {
+				fileRows[i].Path = fmt.Sprintf("%s/%s", dest.Path, fileRows[i].Name)
+				fileRows[i].FolderID = dest.ID
+			}
+			fileRows[i].X = *item.X
+			fileRows[i].Y = *item.Y
+			continue
+		}
+		i, ok := folderRow[id]
+		if !ok {
+			i = len(folderRows)
+			folderRow[id] = i
+			folderRows = append(folderRows, *known[id])
+		}
+		folderRows[i].X = *item.X
+		folderRows[i].Y = *item.Y
+	}
+
+	var changes []models.LayoutChange
+	for _, item := range items {
+		id := uuid.MustParse(item.ID)
+		if item.ItemType == models.LayoutItemFile {
+			before, after := fileByID[id], fileRows[fileRow[id]]
+			changes = append(changes, layoutChange(item.ItemType, id, before.FolderID, after.FolderID, before.X, before.Y, after.X, after.Y)...)
+			continue
+		}
+		before, after := known[id], folderRows[folderRow[id]]
+		changes = append(changes, layoutChange(item.ItemType, id, before.ParentID, after.ParentID, before.X, before.Y, after.X, after.Y)...)
+	}
+	steps := journalSteps(c, journal, changes)
+
+	saveErr := h.DBClient.UpdateLayout(folderRows, fileRows, steps)
+	if errors.Is(saveErr, db.ErrLayoutStepChanged) {
+		return c.JSON(http.StatusConflict, "This step was undone or redone by someone else")
+	}
+	if errors.Is(saveErr, db.ErrTreeChanged) {
+		return c.JSON(http.StatusConflict, "The folders were changed by someone else, try again")
+	}
+	if errors.Is(saveErr, db.ErrNameTaken) {
+		return c.JSON(http.StatusConflict, "An item with this name already exists in the folder")
+	}
+	if saveErr != nil {
+		log.Error().Err(saveErr).Msg("Error updating layout in database")
+		return c.JSON(400, "Error updating layout in database")
+	}
+
+	placedFolders := []models.Folder{}
+	placedFiles := []models.File{}
+	for _, item := range items {
+		id := uuid.MustParse(item.ID)
+		if item.ItemType == models.LayoutItemFile {
+			file := fileRows[fileRow[id]]
+			placedFiles = append(placedFiles, file)
+			h.publish(c, events.FileMoved, known[fileByID[id].FolderID].WorkspaceID, file.FolderID, file)
+			continue
+		}
+		folder := folderRows[folderRow[id]]
+		placedFolders = append(placedFolders, folder)
+		h.publish(c, events.FolderMoved, folder.WorkspaceID, folder.ParentID, folder)
+	}
+
+	return c.JSON(200, map[string]interface{}{
+		"folders": placedFolders,
+		"files":   placedFiles,
+	})
+}
+
+// a function to re-arrange everything inside a folder with one of the layout
+// strategies, the new coordinates are stored and returned
+func (h *HandlerClient) ArrangeFolder(c echo.Context) error {
+	var arrangeReq models.ArrangeFolderRequest
+	bindErr := c.Bind(&arrangeReq)
+	if bindErr != nil {
+		return bindErr
+	}
+	if arrangeReq.Order != "" && arrangeReq.Order != "asc" && arrangeReq.Order != "desc" {
+		return c.JSON(400, "Order must be asc or desc")
+	}
+	folder, err := h.DBClient.GetFolderByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting folder from database")
+		return c.JSON(404, "Folder not found")
+	}
+	if err := h.authorizeFolder(c, folder, models.RoleEditor); err != nil {
+		return err
+	}
+	folders, files, contentErr := h.DBClient.GetFoldersAndFilesInFolder(folder.ID.String())
+	if contentErr != nil {
+		log.Error().Err(contentErr).Msg("Error getting folder contents from database")
+		return c.JSON(400, "Error getting folder contents from database")
+	}
+
+	// folders come first, the arranged items keep that order
+	items := make([]layout.Item, 0, len(folders)+len(files))
+	for _, child := range folders {
+		items = append(items, layout.Item{ID: child.ID, Folder: true, Name: child.Name, CreatedAt: child.CreatedAt.Time, X: child.X, Y: child.Y})
+	}
+	for _, file := range files {
+		items = append(items, layout.Item{ID: file.ID, Name: file.Name, Size: file.Size, CreatedAt: file.CreatedAt.Time, X: file.X, Y: file.Y})
+	}
+	arranged, arrangeErr := layout.Arrange(items, layout.Options{
+		Strategy:   arrangeReq.Strategy,
+		SortBy:     arrangeReq.SortBy,
+		Descending: arrangeReq.Order == "desc",
+		Columns:    arrangeReq.Columns,
+	})
+	switch {
+	case errors.Is(arrangeErr, layout.ErrUnknownStrategy):
+		return c.JSON(400, "Strategy must be grid, sorted, clustered or packed")
+	case errors.Is(arrangeErr, layout.ErrUnknownSort):
+		return c.JSON(400, "Sort by must be name, date, size or type")
+	case errors.Is(arrangeErr, layout.ErrInvalidColumns):
+		return c.JSON(400, fmt.Sprintf("Columns must be between 1 and %d", layout.MaxColumns))
+	case arrangeErr != nil:
+		log.Error().Err(arrangeErr).Msg("Error arranging folder")
+		return c.JSON(400, "Error arranging folder")
+	}
+	for i := range folders {
+		folders[i].X, folders[i].Y = arranged[i].X, arranged[i].Y
+	}
+	for i := range files {
+		files[i].X, files[i].Y = arranged[len(folders)+i].X, arranged[len(folders)+i].Y
+	}
+
+	var changes []models.LayoutChange
+	for i, child := range folders {
+		changes = append(changes, layoutChange(models.LayoutItemFolder, child.ID, folder.ID, folder.ID, items[i].X, items[i].Y, child.X, child.Y)...)
+	}
+	for i, file := range files {
+		before := items[len(folders)+i]
+		changes = append(changes, layoutChange(models.LayoutItemFile, file.ID, folder.ID, folder.ID, before.X, before.Y, file.X, file.Y)...)
+	}
+	steps := journalSteps(c, layoutJournal{action: models.LayoutActionArrange, canvas: folder.ID}, changes)
+
+	saveErr := h.DBClient.UpdateLayout(folders, files, steps)
+	if saveErr != nil {
+		log.Error().Err(saveErr).Msg("Error updating layout in database")
+		return c.JSON(400, "Error updating layout in database")
+	}
+
+	result := map[string]interface{}{
+		"folders": folders,
+		"files":   files,
+	}
+	h.publish(c, events.FolderArranged, folder.WorkspaceID, folder.ID, result)
+	return c.JSON(200, result)
+}
+
+// parseViewport reads a viewport like readViewport and widens it so items
+// that only poke into it are found by their top left corner, and by an
+// overscan that shrinks as the optional zoom parameter grows.
+func parseViewport(c echo.Context) (*models.Viewport, error) {
+	viewport, err := readViewport(c)
+	if err != nil || viewport == nil {
+		return viewport, err
+	}
+	zoom := 1.0
+	if raw := c.QueryParam("zoom"); raw != "" {
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil || value < minZoom || value > maxZoom {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Zoom must be between %g and %g", minZoom, maxZoom))
+		}
+		zoom = value
+	}
+	margin := viewportOverscan / zoom
+	viewport.MinX -= margin + layout.ItemWidth
+	viewport.MinY -= margin + layout.ItemHeight
+	viewport.MaxX += margin
+	viewport.MaxY += margin
+	return viewport, nil
+}
+
+// readViewport reads the min_x, min_y, max_x and max_y query parameters, it
+// returns nil when none of them are given
+func readViewport(c echo.Context) (*models.Viewport, error) {
+	names := []string{"min_x", "min_y", "max_x", "max_y"}
+	values := make([]float64, len(names))
+	given := 0
+	for i, name := range names {
+		raw := c.QueryParam(name)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil || !validCoordinate(value) {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid %s", name))
+		}
+		values[i] = value
+		given++
+	}
+	if given == 0 {
+		return nil, nil
+	}
+	if given != len(names) {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "A viewport needs min_x, min_y, max_x and max_y")
+	}
+	viewport := models.Viewport{MinX: values[0], MinY: values[1], MaxX: values[2], MaxY: values[3]}
+	if viewport.MinX > viewport.MaxX || viewport.MinY > viewport.MaxY {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "The minimum of a viewport cannot be larger than its maximum")
+	}
+	return &viewport, nil
+}
@@ -0,0 +1,292 @@
+package routes
+
+import (
+	"cascloud/db"
+	"cascloud/helpers"
+	"cascloud/models"
+
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// roles that can be handed out, ownership can not be given away this way
+func assignableRole(name string) bool {
+	return name != models.RoleOwner && roleRank(name) > 0
+}
+
+// a function to invite someone to a workspace by email
+func (h *HandlerClient) InviteMember(c echo.Context) error {
+	workspace, err := h.DBClient.GetWorkspaceByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting workspace from database")
+		return c.JSON(404, "Workspace not found")
+	}
+	if err := h.authorizeWorkspace(c, workspace.ID, models.RoleOwner); err != nil {
+		return err
+	}
+	var inviteReq models.InviteMemberRequest
+	bindErr := c.Bind(&inviteReq)
+	if bindErr != nil {
+		return bindErr
+	}
+	email := strings.TrimSpace(inviteReq.Email)
+	if !strings.Contains(email, "@") {
+		return c.JSON(400, "Invalid email")
+	}
+	if !assignableRole(inviteReq.Role) {
+		return c.JSON(400, "Invalid role")
+	}
+
+	if h.DBClient.UserExists(email) {
+		user, userErr := h.DBClient.GetUserByEmail(email)
+		if userErr != nil {
+			log.Error().Err(userErr).Msg("Error getting user from database")
+			return c.JSON(400, "Error getting user from database")
+		}
+		role, roleErr := h.DBClient.GetWorkspaceRole(user.ID.String(), workspace.ID.String())
+		if roleErr != nil {
+			log.Error().Err(roleErr).Msg("Error getting workspace role from database")
+			return c.JSON(400, "Error getting workspace role from database")
+		}
+		if role != nil || workspace.OwnerID == user.ID {
+			return c.JSON(409, "User is already a member of the workspace")
+		}
+	}
+	pending, pendingErr := h.DBClient.GetPendingInvitations(workspace.ID.String(), email)
+	if pendingErr != nil {
+		log.Error().Err(pendingErr).Msg("Error getting invitations from database")
+		return c.JSON(400, "Error getting invitations from database")
+	}
+	if len(pending) > 0 {
+		return c.JSON(409, "User already has a pending invitation")
+	}
+
+	invitation := models.Invitation{
+		WorkspaceID: workspace.ID,
+		Email:       email,
+		Role:        inviteReq.Role,
+		InvitedBy:   callerID(c),
+		Status:      models.InvitationPending,
+	}
+	createErr := h.DBClient.CreateInvitation(&invitation)
+	if createErr != nil {
+		log.Error().Err(createErr).Msg("Error creating invitation in database")
+		return c.JSON(400, "Error creating invitation in database")
+	}
+
+	return c.JSON(http.StatusCreated, invitation)
+}
+
+// a function to list the pending invitations of a workspace
+func (h *HandlerClient) GetWorkspaceInvitations(c echo.Context) error {
+	workspace, err := h.DBClient.GetWorkspaceByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting workspace from database")
+		return c.JSON(404, "Workspace not found")
+	}
+	if err := h.authorizeWorkspace(c, workspace.ID, models.RoleOwner); err != nil {
+		return err
+	}
+	invitations, invitationsErr := h.DBClient.GetPendingInvitations(workspace.ID.String(), "")
+	if invitationsErr != nil {
+		log.Error().Err(invitationsErr).Msg("Error getting invitations from database")
+		return c.JSON(400, "Error getting invitations from database")
+	}
+	return c.JSON(200, invitations)
+}
+
+// a function to list the pending invitations of the caller
+func (h *HandlerClient) GetMyInvitations(c echo.Context) error {
+	user := helpers.CurrentUser(c)
+	if user == nil {
+		return c.JSON(401, "Not authenticated")
+	}
+	invitations, err := h.DBClient.GetPendingInvitations("", user.Email)
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting invitations from database")
+		return c.JSON(400, "Error getting invitations from database")
+	}
+	return c.JSON(200, invitations)
+}
+
+// a function to accept an invitation addressed to the caller
+func (h *HandlerClient) AcceptInvitation(c echo.Context) error {
+	invitation, user, err := h.callerInvitation(c)
+	if err != nil {
+		return err
+	}
+	role, roleErr := h.DBClient.GetRoleByName(invitation.Role)
+	if roleErr != nil {
+		log.Error().Err(roleErr).Msg("Error getting role from database")
+		return c.JSON(400, "Error getting role from database")
+	}
+
+	acceptErr := h.DBClient.AcceptInvitation(invitation, user, role)
+	if errors.Is(acceptErr, db.ErrInvitationNotPending) {
+		return c.JSON(409, "Invitation is no longer pending")
+	}
+	if errors.Is(acceptErr, db.ErrAlreadyMember) {
+		return c.JSON(409, "User is already a member of the workspace")
+	}
+	if acceptErr != nil {
+		log.Error().Err(acceptErr).Msg("Error accepting invitation in database")
+		return c.JSON(400, "Error accepting invitation in database")
+	}
+
+	return c.JSON(200, invitation)
+}
+
+// a function to decline an invitation addressed to the caller
+func (h *HandlerClient) DeclineInvitation(c echo.Context) error {
+	invitation, _, err := h.callerInvitation(c)
+	if err != nil {
+		return err
+	}
+	declineErr := h.DBClient.DeclineInvitation(invitation)
+	if errors.Is(declineErr, db.ErrInvitationNotPending) {
+		return c.JSON(409, "Invitation is no longer pending")
+	}
+	if declineErr != nil {
+		log.Error().Err(declineErr).Msg("Error declining invitation in database")
+		return c.JSON(400, "Error declining invitation in database")
+	}
+
+	return c.JSON(200, invitation)
+}
+
+// callerInvitation loads the invitation of the :id parameter and makes sure it
+// is addressed to the caller
+func (h *HandlerClient) callerInvitation(c echo.Context) (*models.Invitation, *models.User, error) {
+	user := helpers.CurrentUser(c)
+	if user == nil {
+		return nil, nil, echo.ErrUnauthorized
+	}
+	invitation, err := h.DBClient.GetInvitationByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting invitation from database")
+		return nil, nil, echo.NewHTTPError(http.StatusNotFound, "Invitation not found")
+	}
+	if !strings.EqualFold(invitation.Email, user.Email) {
+		return nil, nil, echo.NewHTTPError(http.StatusForbidden, "This invitation is addressed to someone else")
+	}
+	return invitation, user, nil
+}
+
+// a function to list the members of a workspace with their roles
+func (h *HandlerClient) GetWorkspaceMembers(c echo.Context) error {
+	workspace, err := h.DBClient.GetWorkspaceByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting workspace from database")
+		return c.JSON(404, "Workspace not found")
+	}
+	if err := h.authorizeWorkspace(c, workspace.ID, models.RoleViewer); err != nil {
+		return err
+	}
+	members, membersErr := h.DBClient.GetWorkspaceMembers(workspace.ID.String())
+	if membersErr != nil {
+		log.Error().Err(membersErr).Msg("Error getting members from database")
+		return c.JSON(400, "Error getting members from database")
+	}
+	return c.JSON(200, members)
+}
+
+// a function to change the role of a member
+func (h *HandlerClient) UpdateMember(c echo.Context) error {
+	workspace, err := h.DBClient.GetWorkspaceByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting workspace from database")
+		return c.JSON(404, "Workspace not found")
+	}
+	if err := h.authorizeWorkspace(c, workspace.ID, models.RoleOwner); err != nil {
+		return err
+	}
+	var memberReq models.UpdateMemberRequest
+	bindErr := c.Bind(&memberReq)
+	if bindErr != nil {
+		return bindErr
+	}
+	if !assignableRole(memberReq.Role) {
+		return c.JSON(400, "Invalid role")
+	}
+	userID, parseErr := uuid.Parse(c.Param("user_id"))
+	if parseErr != nil {
+		return c.JSON(400, "Invalid user ID")
+	}
+	if userID == workspace.OwnerID {
+		return c.JSON(400, "The role of the workspace owner cannot be changed")
+	}
+
+	role, roleErr := h.DBClient.GetRoleByName(memberReq.Role)
+	if roleErr != nil {
+		log.Error().Err(roleErr).Msg("Error getting role from database")
+		return c.JSON(400, "Error getting role from database")
+	}
+	updateErr := h.DBClient.UpdateMemberRole(workspace.ID.String(), userID.String(), role)
+	if errors.Is(updateErr, db.ErrNotMember) {
+		return c.JSON(404, "Member not found")
+	}
+	if updateErr != nil {
+		log.Error().Err(updateErr).Msg("Error updating member in database")
+		return c.JSON(400, "Error updating member in database")
+	}
+
+	return c.JSON(200, map[string]interface{}{
+		"user_id": userID,
+		"role":    role.Name,
+	})
+}
+
+// a function to remove a member from a workspace
+func (h *HandlerClient) RemoveMember(c echo.Context) error {
+	workspace, err := h.DBClient.GetWorkspaceByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting workspace from database")
+		return c.JSON(404, "Workspace not found")
+	}
+	if err := h.authorizeWorkspace(c, workspace.ID, models.RoleOwner); err != nil {
+		return err
+	}
+	userID, parseErr := uuid.Parse(c.Param("user_id"))
+	if parseErr != nil {
+		return c.JSON(400, "Invalid user ID")
+	}
+	return h.removeMember(c, workspace, userID)
+}
+
+// a function for the caller to leave a workspace
+func (h *HandlerClient) LeaveWorkspace(c echo.Context) error {
+	workspace, err := h.DBClient.GetWorkspaceByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting workspace from database")
+		return c.JSON(404, "Workspace not found")
+	}
+	return h.removeMember(c, workspace, callerID(c))
+}
+
+func (h *HandlerClient) removeMember(c echo.Context, workspace *models.Workspace, userID uuid.UUID) error {
+	if userID == workspace.OwnerID {
+		return c.JSON(400, "The workspace owner cannot be removed")
+	}
+	removeErr := h.DBClient.RemoveMember(workspace.ID.String(), userID.String())
+	if errors.Is(removeErr, db.ErrNotMember) {
+		return c.JSON(404, "Member not found")
+	}
+	if removeErr != nil {
+		log.Error().Err(removeErr).Msg("Error removing member from database")
+		return c.JSON(400, "Error removing member from database")
+	}
+	// an open events connection would keep showing them the workspace
+	if h.Events != nil {
+		h.Events.DisconnectUser(workspace.ID, userID)
+	}
+
+	return c.JSON(200, map[string]interface{}{
+		"workspace_id": workspace.ID,
+		"user_id":      userID,
+	})
+}
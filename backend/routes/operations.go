@@ -0,0 +1,549 @@
+package routes
+
+import (
+	"cascloud/db"
+	"cascloud/events"
+	"cascloud/models"
+
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// copies of an item dropped into its own folder are offset so they do not
+// sit exactly on top of the original
+const copyOffset = 20
+
+// how far from the origin an item may be placed on the canvas
+const maxCoordinate = 1e9
+
+// objectMove is a storage object that has to be copied from one key to another
+type objectMove struct {
+	from string
+	to   string
+}
+
+// a function to move a file into another folder, optionally renaming it
+func (h *HandlerClient) MoveFile(c echo.Context) error {
+	var moveReq models.MoveFileRequest
+	bindErr := c.Bind(&moveReq)
+	if bindErr != nil {
+		return bindErr
+	}
+	return h.editFile(c, models.EditFileRequest{
+		Name:     moveReq.Name,
+		FolderID: moveReq.FolderID,
+	})
+}
+
+// editFile renames, moves and places the file of the :id parameter, the
+// fields left out of editReq are kept
+func (h *HandlerClient) editFile(c echo.Context, editReq models.EditFileRequest) error {
+	file, err := h.DBClient.GetFileByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting file from database")
+		return c.JSON(404, "File not found")
+	}
+	if err := h.authorizeFile(c, file, models.RoleEditor); err != nil {
+		return err
+	}
+	if editReq.FolderID == "" {
+		editReq.FolderID = file.FolderID.String()
+	}
+	name := file.Name
+	if editReq.Name != "" {
+		name = editReq.Name
+	}
+	if !validFileName(name) {
+		return c.JSON(400, "Invalid file name")
+	}
+	x, y := file.X, file.Y
+	if editReq.X != nil {
+		x = *editReq.X
+	}
+	if editReq.Y != nil {
+		y = *editReq.Y
+	}
+	if !validCoordinate(x) || !validCoordinate(y) {
+		return c.JSON(400, "Invalid coordinates")
+	}
+	folder, folderErr := h.DBClient.GetFolderByID(editReq.FolderID)
+	if folderErr != nil {
+		log.Error().Err(folderErr).Msg("Error getting folder from database")
+		return c.JSON(400, "Error getting folder from database")
+	}
+	if err := h.authorizeFolder(c, folder, models.RoleEditor); err != nil {
+		return err
+	}
+	// share links and trash items of a file belong to its workspace
+	if folder.ID != file.FolderID {
+		source, sourceErr := h.DBClient.GetFolderByID(file.FolderID.String())
+		if sourceErr != nil {
+			log.Error().Err(sourceErr).Msg("Error getting folder from database")
+			return c.JSON(400, "Error getting folder from database")
+		}
+		if source.WorkspaceID != folder.WorkspaceID {
+			return c.JSON(400, "Items cannot be moved to another workspace, copy them instead")
+		}
+	}
+	relocated := folder.ID != file.FolderID || name != file.Name
+	if !relocated && x == file.X && y == file.Y {
+		return c.JSON(200, file)
+	}
+
+	if relocated {
+		taken, takenErr := h.takenNames(folder.ID.String(), file.ID)
+		if takenErr != nil {
+			log.Error().Err(takenErr).Msg("Error getting folder contents from database")
+			return c.JSON(400, "Error getting folder contents from database")
+		}
+		if taken[name] {
+			return c.JSON(http.StatusConflict, "An item with this name already exists in the folder")
+		}
+	}
+
+	source := file.FolderID
+	renamed := name != file.Name
+	action := models.LayoutActionEdit
+	if source != folder.ID {
+		action = models.LayoutActionMove
+	}
+	changes := layoutChange(models.LayoutItemFile, file.ID, source, folder.ID, file.X, file.Y, x, y)
+	file.Name = name
+	file.FolderID = folder.ID
+	if relocated {
+		file.Path = fmt.Sprintf("%s/%s", folder.Path, name)
+	}
+	file.X = x
+	file.Y = y
+	steps := journalSteps(c, layoutJournal{action: action}, changes)
+	editErr := h.DBClient.UpdateLayout(nil, []models.File{*file}, steps)
+	if errors.Is(editErr, db.ErrTreeChanged) {
+		return c.JSON(http.StatusConflict, "The folder was changed by someone else, try again")
+	}
+	if errors.Is(editErr, db.ErrNameTaken) {
+		return c.JSON(http.StatusConflict, "An item with this name already exists in the folder")
+	}
+	if editErr != nil {
+		log.Error().Err(editErr).Msg("Error editing file in database")
+		return c.JSON(400, "Error editing file in database")
+	}
+
+	switch {
+	case source != folder.ID:
+		h.publish(c, events.FileMoved, folder.WorkspaceID, folder.ID, file)
+	case renamed:
+		h.publish(c, events.FileRenamed, folder.WorkspaceID, folder.ID, file)
+	default:
+		h.publish(c, events.FileMoved, folder.WorkspaceID, folder.ID, file)
+	}
+	return c.JSON(200, file)
+}
+
+// a function to copy a file into a folder, the copy gets a free name in the
+// destination folder
+func (h *HandlerClient) CopyFile(c echo.Context) error {
+	var copyReq models.MoveFileRequest
+	bindErr := c.Bind(&copyReq)
+	if bindErr != nil {
+		return bindErr
+	}
+	file, err := h.DBClient.GetFileByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting file from database")
+		return c.JSON(404, "File not found")
+	}
+	if err := h.authorizeFile(c, file, models.RoleViewer); err != nil {
+		return err
+	}
+	if copyReq.FolderID == "" {
+		copyReq.FolderID = file.FolderID.String()
+	}
+	name := file.Name
+	if copyReq.Name != "" {
+		name = copyReq.Name
+	}
+	if !validFileName(name) {
+		return c.JSON(400, "Invalid file name")
+	}
+	folder, folderErr := h.DBClient.GetFolderByID(copyReq.FolderID)
+	if folderErr != nil {
+		log.Error().Err(folderErr).Msg("Error getting folder from database")
+		return c.JSON(400, "Error getting folder from database")
+	}
+	if err := h.authorizeFolder(c, folder, models.RoleEditor); err != nil {
+		return err
+	}
+
+	taken, takenErr := h.takenNames(folder.ID.String(), uuid.Nil)
+	if takenErr != nil {
+		log.Error().Err(takenErr).Msg("Error getting folder contents from database")
+		return c.JSON(400, "Error getting folder contents from database")
+	}
+	name = uniqueName(name, taken)
+
+	fileCopy := models.File{
+		ID:       uuid.New(),
+		Name:     name,
+		FolderID: folder.ID,
+		Size:     file.Size,
+		Path:     fmt.Sprintf("%s/%s", folder.Path, name),
+		X:        file.X,
+		Y:        file.Y,
+	}
+	if folder.ID == file.FolderID {
+		fileCopy.X += copyOffset
+		fileCopy.Y += copyOffset
+	}
+	// the copy starts out with the current version only
+	fileCopy.StorageKey = versionKey(fileCopy.ID, 1)
+
+	ctx := c.Request().Context()
+	copies := []objectMove{{from: file.StorageKey, to: fileCopy.StorageKey}}
+	if copyErr := h.copyObjects(ctx, copies); copyErr != nil {
+		return c.JSON(400, "Error copying file in s3")
+	}
+	createErr := h.DBClient.CreateFolderTree(nil, []models.File{fileCopy})
+	if createErr != nil {
+		log.Error().Err(createErr).Msg("Error creating file in database")
+		h.deleteObjects(context.WithoutCancel(ctx), []string{fileCopy.StorageKey})
+		if errors.Is(createErr, db.ErrNameTaken) {
+			return c.JSON(http.StatusConflict, "The name was taken by someone else, try again")
+		}
+		return c.JSON(400, "Error creating file in database")
+	}
+
+	h.publish(c, events.FileCreated, folder.WorkspaceID, folder.ID, fileCopy)
+	return c.JSON(200, fileCopy)
+}
+
+// a function to move a folder with everything inside it under a new parent
+func (h *HandlerClient) MoveFolder(c echo.Context) error {
+	var moveReq models.MoveFolderRequest
+	bindErr := c.Bind(&moveReq)
+	if bindErr != nil {
+		return bindErr
+	}
+	if moveReq.ParentID == "" {
+		return c.JSON(400, "Parent ID not provided")
+	}
+	return h.editFolder(c, models.EditFolderRequest{ParentID: moveReq.ParentID})
+}
+
+// a function to rename, move and place a folder, everything inside it moves along
+func (h *HandlerClient) EditFolder(c echo.Context) error {
+	var folderReq models.EditFolderRequest
+	bindErr := c.Bind(&folderReq)
+	if bindErr != nil {
+		return bindErr
+	}
+	return h.editFolder(c, folderReq)
+}
+
+// editFolder renames, moves and places the folder of the :id parameter, the
+// fields left out of editReq are kept. The paths of every folder and file
+// below it are rewritten, their content stays where it is in storage.
+func (h *HandlerClient) editFolder(c echo.Context, editReq models.EditFolderRequest) error {
+	folders, files, err := h.DBClient.GetFolderTree(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting folder from database")
+		return c.JSON(404, "Folder not found")
+	}
+	root := folders[0]
+	if err := h.authorizeFolder(c, &root, models.RoleEditor); err != nil {
+		return err
+	}
+	if root.ParentID == uuid.Nil {
+		return c.JSON(400, "The home folder cannot be moved or renamed")
+	}
+	if editReq.ParentID == "" {
+		editReq.ParentID = root.ParentID.String()
+	}
+	name := root.Name
+	if editReq.Name != "" {
+		name = editReq.Name
+	}
+	if !validFileName(name) {
+		return c.JSON(400, "Invalid folder name")
+	}
+	x, y := root.X, root.Y
+	if editReq.X != nil {
+		x = *editReq.X
+	}
+	if editReq.Y != nil {
+		y = *editReq.Y
+	}
+	if !validCoordinate(x) || !validCoordinate(y) {
+		return c.JSON(400, "Invalid coordinates")
+	}
+	parent, parentErr := h.DBClient.GetFolderByID(editReq.ParentID)
+	if parentErr != nil {
+		log.Error().Err(parentErr).Msg("Error getting folder from database")
+		return c.JSON(400, "Error getting folder from database")
+	}
+	if err := h.authorizeFolder(c, parent, models.RoleEditor); err != nil {
+		return err
+	}
+	moved := parent.ID != root.ParentID
+	renamed := name != root.Name
+	if !moved && !renamed && x == root.X && y == root.Y {
+		return c.JSON(200, root)
+	}
+	if moved && inTree(folders, parent.ID) {
+		return c.JSON(400, "A folder cannot be moved into itself or one of its subfolders")
+	}
+	// share links and trash items below the folder belong to its workspace
+	if moved && parent.WorkspaceID != root.WorkspaceID {
+		return c.JSON(400, "Items cannot be moved to another workspace, copy them instead")
+	}
+
+	if moved || renamed {
+		taken, takenErr := h.takenNames(parent.ID.String(), root.ID)
+		if takenErr != nil {
+			log.Error().Err(takenErr).Msg("Error getting folder contents from database")
+			return c.JSON(400, "Error getting folder contents from database")
+		}
+		if taken[name] {
+			return c.JSON(http.StatusConflict, "An item with this name already exists in the folder")
+		}
+		relocateTree(folders, files, parent, name)
+	} else {
+		// only the position changes, nothing below the folder has to be saved
+		folders, files = folders[:1], nil
+	}
+	folders[0].ParentID = parent.ID
+	folders[0].X = x
+	folders[0].Y = y
+
+	action := models.LayoutActionEdit
+	if moved {
+		action = models.LayoutActionMove
+	}
+	changes := layoutChange(models.LayoutItemFolder, root.ID, root.ParentID, parent.ID, root.X, root.Y, x, y)
+	steps := journalSteps(c, layoutJournal{action: action}, changes)
+
+	saveErr := h.DBClient.UpdateLayout(folders, files, steps)
+	if errors.Is(saveErr, db.ErrTreeChanged) {
+		return c.JSON(http.StatusConflict, "The folder was changed by someone else, try again")
+	}
+	if errors.Is(saveErr, db.ErrNameTaken) {
+		return c.JSON(http.StatusConflict, "An item with this name already exists in the folder")
+	}
+	if saveErr != nil {
+		log.Error().Err(saveErr).Msg("Error editing folder in database")
+		return c.JSON(400, "Error editing folder in database")
+	}
+
+	switch {
+	case moved:
+		h.publish(c, events.FolderMoved, parent.WorkspaceID, parent.ID, folders[0])
+	case renamed:
+		h.publish(c, events.FolderRenamed, parent.WorkspaceID, parent.ID, folders[0])
+	default:
+		h.publish(c, events.FolderMoved, parent.WorkspaceID, parent.ID, folders[0])
+	}
+	return c.JSON(200, folders[0])
+}
+
+// a function to copy a folder with everything inside it under a parent
+func (h *HandlerClient) CopyFolder(c echo.Context) error {
+	var copyReq models.MoveFolderRequest
+	bindErr := c.Bind(&copyReq)
+	if bindErr != nil {
+		return bindErr
+	}
+	folders, files, err := h.DBClient.GetFolderTree(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting folder from database")
+		return c.JSON(404, "Folder not found")
+	}
+	root := folders[0]
+	if err := h.authorizeFolder(c, &root, models.RoleViewer); err != nil {
+		return err
+	}
+	if copyReq.ParentID == "" {
+		if root.ParentID == uuid.Nil {
+			return c.JSON(400, "Parent ID not provided")
+		}
+		copyReq.ParentID = root.ParentID.String()
+	}
+	parent, parentErr := h.DBClient.GetFolderByID(copyReq.ParentID)
+	if parentErr != nil {
+		log.Error().Err(parentErr).Msg("Error getting folder from database")
+		return c.JSON(400, "Error getting folder from database")
+	}
+	if err := h.authorizeFolder(c, parent, models.RoleEditor); err != nil {
+		return err
+	}
+	if inTree(folders, parent.ID) {
+		return c.JSON(400, "A folder cannot be copied into itself or one of its subfolders")
+	}
+
+	taken, takenErr := h.takenNames(parent.ID.String(), uuid.Nil)
+	if takenErr != nil {
+		log.Error().Err(takenErr).Msg("Error getting folder contents from database")
+		return c.JSON(400, "Error getting folder contents from database")
+	}
+
+	// every copied folder gets a new id, children are pointed at the new ids
+	newIDs := make(map[uuid.UUID]uuid.UUID, len(folders))
+	for _, folder := range folders {
+		newIDs[folder.ID] = uuid.New()
+	}
+	relocateTree(folders, files, parent, uniqueName(root.Name, taken))
+	for i := range folders {
+		folders[i].ID = newIDs[folders[i].ID]
+		if i > 0 {
+			folders[i].ParentID = newIDs[folders[i].ParentID]
+		}
+	}
+	folders[0].ParentID = parent.ID
+	if parent.ID == root.ParentID {
+		folders[0].X += copyOffset
+		folders[0].Y += copyOffset
+	}
+	// the copies start out with the current version of every file only
+	copies := make([]objectMove, 0, len(files))
+	for i := range files {
+		files[i].ID = uuid.New()
+		files[i].FolderID = newIDs[files[i].FolderID]
+		files[i].Version = 1
+		copies = append(copies, objectMove{from: files[i].StorageKey, to: versionKey(files[i].ID, 1)})
+		files[i].StorageKey = copies[i].to
+	}
+
+	ctx := c.Request().Context()
+	if copyErr := h.copyObjects(ctx, copies); copyErr != nil {
+		return c.JSON(400, "Error copying files in s3")
+	}
+	createErr := h.DBClient.CreateFolderTree(folders, files)
+	if createErr != nil {
+		log.Error().Err(createErr).Msg("Error creating folder copy in database")
+		keys := make([]string, 0, len(copies))
+		for _, cp := range copies {
+			keys = append(keys, cp.to)
+		}
+		h.deleteObjects(context.WithoutCancel(ctx), keys)
+		if errors.Is(createErr, db.ErrNameTaken) {
+			return c.JSON(http.StatusConflict, "The name was taken by someone else, try again")
+		}
+		return c.JSON(400, "Error creating folder copy in database")
+	}
+
+	h.publish(c, events.FolderCreated, parent.WorkspaceID, parent.ID, folders[0])
+	return c.JSON(200, folders[0])
+}
+
+// relocateTree rewrites the paths of a folder tree so that its root becomes
+// parent/rootName, the root is folders[0]. Files keep the last segment of their
+// path and move along with their folder, ids are left untouched.
+func relocateTree(folders []models.Folder, files []models.File, parent *models.Folder, rootName string) {
+	oldRootPath := folders[0].Path
+	newRootPath := fmt.Sprintf("%s/%s", parent.Path, rootName)
+	folders[0].Name = rootName
+
+	folderPaths := make(map[uuid.UUID]string, len(folders))
+	for i := range folders {
+		folders[i].Path = newRootPath + strings.TrimPrefix(folders[i].Path, oldRootPath)
+		folders[i].WorkspaceID = parent.WorkspaceID
+		folderPaths[folders[i].ID] = folders[i].Path
+	}
+
+	for i := range files {
+		files[i].Path = fmt.Sprintf("%s/%s", folderPaths[files[i].FolderID], path.Base(files[i].Path))
+	}
+}
+
+// copyObjects copies storage objects, if one of them fails the copies already
+// made are deleted again
+func (h *HandlerClient) copyObjects(ctx context.Context, copies []objectMove) error {
+	for i, cp := range copies {
+		if err := h.S3Client.CopyFile(ctx, cp.from, cp.to); err != nil {
+			log.Error().Err(err).Str("from", cp.from).Str("to", cp.to).Msg("Error copying object")
+			keys := make([]string, 0, i)
+			for _, done := range copies[:i] {
+				keys = append(keys, done.to)
+			}
+			h.deleteObjects(context.WithoutCancel(ctx), keys)
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteObjects deletes storage objects and returns the keys that could not be deleted
+func (h *HandlerClient) deleteObjects(ctx context.Context, keys []string) []string {
+	failed := []string{}
+	for _, key := range keys {
+		if err := h.S3Client.DeleteFile(ctx, key); err != nil {
+			log.Error().Err(err).Str("key", key).Msg("Error deleting object, it is left orphaned")
+			failed = append(failed, key)
+		}
+	}
+	return failed
+}
+
+// takenNames returns the names of the files and folders directly inside a folder,
+// except for the items being moved. Files and folders share one namespace since
+// their paths do. The names are read before saving, the database checks them
+// again when the change is saved and fails with db.ErrNameTaken.
+func (h *HandlerClient) takenNames(folderID string, except ...uuid.UUID) (map[string]bool, error) {
+	folders, files, err := h.DBClient.GetFoldersAndFilesInFolder(folderID)
+	if err != nil {
+		return nil, err
+	}
+	skip := make(map[uuid.UUID]bool, len(except))
+	for _, id := range except {
+		skip[id] = true
+	}
+	taken := make(map[string]bool, len(folders)+len(files))
+	for _, folder := range folders {
+		if !skip[folder.ID] {
+			taken[folder.Name] = true
+		}
+	}
+	for _, file := range files {
+		if !skip[file.ID] {
+			taken[file.Name] = true
+			taken[path.Base(file.Path)] = true
+		}
+	}
+	return taken, nil
+}
+
+// uniqueName returns name, or "name (n).ext" with the first free n
+func uniqueName(name string, taken map[string]bool) string {
+	if !taken[name] {
+		return name
+	}
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if !taken[candidate] {
+			return candidate
+		}
+	}
+}
+
+func inTree(folders []models.Folder, id uuid.UUID) bool {
+	for _, folder := range folders {
+		if folder.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// validCoordinate keeps positions on the canvas finite and within reach
+func validCoordinate(v float64) bool {
+	return !math.IsNaN(v) && math.Abs(v) <= maxCoordinate
+}
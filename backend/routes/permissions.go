@@ -0,0 +1,92 @@
+package routes
+
+import (
+	"cascloud/models"
+
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// Every handler that touches a workspace checks the role of the caller first:
+//   - viewers and commenters can list and download
+//   - commenters can also put notes on a canvas and change their own notes
+//   - editors can also upload, create, move, copy, delete and restore
+//   - owners can also purge the trash for good
+//
+// The checks return an *echo.HTTPError that handlers return as is.
+
+// roleRank orders roles by privilege, unknown roles rank below every real one
+func roleRank(name string) int {
+	for i, role := range models.Roles {
+		if role == name {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// a function to check that the caller has at least minRole in a workspace
+func (h *HandlerClient) authorizeWorkspace(c echo.Context, workspaceID uuid.UUID, minRole string) error {
+	userID := callerID(c)
+	if userID == uuid.Nil {
+		return echo.ErrUnauthorized
+	}
+	role, err := h.DBClient.GetWorkspaceRole(userID.String(), workspaceID.String())
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting workspace role from database")
+		return echo.NewHTTPError(http.StatusInternalServerError, "Error checking permissions")
+	}
+	if role == nil {
+		return echo.NewHTTPError(http.StatusForbidden, "You do not have access to this workspace")
+	}
+	if roleRank(role.Name) < roleRank(minRole) {
+		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("This requires the %s role, your role in this workspace is %s", minRole, role.Name))
+	}
+	return nil
+}
+
+func (h *HandlerClient) authorizeFolder(c echo.Context, folder *models.Folder, minRole string) error {
+	return h.authorizeWorkspace(c, folder.WorkspaceID, minRole)
+}
+
+// a function to check the role of the caller in the workspace of a file
+func (h *HandlerClient) authorizeFile(c echo.Context, file *models.File, minRole string) error {
+	folder, err := h.DBClient.GetFolderByID(file.FolderID.String())
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting folder from database")
+		return echo.NewHTTPError(http.StatusInternalServerError, "Error checking permissions")
+	}
+	return h.authorizeFolder(c, folder, minRole)
+}
+
+// a function to check the role of the caller in the workspace of a folder id
+func (h *HandlerClient) authorizeFolderID(c echo.Context, folderID string, minRole string) error {
+	folder, err := h.DBClient.GetFolderByID(folderID)
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting folder from database")
+		return echo.NewHTTPError(http.StatusNotFound, "Folder not found")
+	}
+	return h.authorizeFolder(c, folder, minRole)
+}
+
+// a function to check that the caller may create or change a canvas item in a
+// folder, a note of the caller only needs a commenter
+func (h *HandlerClient) authorizeCanvasItem(c echo.Context, folder *models.Folder, item *models.CanvasItem) error {
+	if item.Kind == models.CanvasItemNote && (item.CreatedBy == uuid.Nil || item.CreatedBy == callerID(c)) {
+		return h.authorizeFolder(c, folder, models.RoleCommenter)
+	}
+	return h.authorizeFolder(c, folder, models.RoleEditor)
+}
+
+// a function to check the role of the caller in a workspace given as a string
+func (h *HandlerClient) authorizeWorkspaceID(c echo.Context, workspaceID string, minRole string) error {
+	id, err := uuid.Parse(workspaceID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid workspace ID")
+	}
+	return h.authorizeWorkspace(c, id, minRole)
+}
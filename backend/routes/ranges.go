@@ -0,0 +1,265 @@
+package routes
+
+import (
+	"cascloud/storage"
+
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// downloadGate is asked before length bytes of an object of size bytes are
+// sent, an error stops the download. done is called with the bytes that were
+// actually sent.
+type downloadGate func(size int64, length int64) (done func(sent int64), err error)
+
+// a function to stream a stored object to the client as a file called name, it
+// answers conditional requests with 304 and serves a single byte range when one
+// is requested
+func (h *HandlerClient) streamObject(c echo.Context, key string, name string) error {
+	return h.streamGatedObject(c, key, name, nil)
+}
+
+// streamGatedObject is streamObject that lets gate refuse or count a download
+// once it is known which bytes would be sent, HEAD requests never reach it
+func (h *HandlerClient) streamGatedObject(c echo.Context, key string, name string, gate downloadGate) error {
+	ctx := c.Request().Context()
+	info, statErr := h.S3Client.StatFile(ctx, key)
+	if statErr != nil {
+		log.Error().Err(statErr).Msg("Error getting file metadata from s3")
+		return c.JSON(400, "Error downloading file from s3")
+	}
+
+	header := c.Response().Header()
+	header.Set("Accept-Ranges", "bytes")
+	if info.ETag != "" {
+		header.Set("ETag", info.ETag)
+	}
+	if !info.LastModified.IsZero() {
+		header.Set("Last-Modified", info.LastModified.UTC().Format(http.TimeFormat))
+	}
+	contentType := contentTypeFor(name, info)
+	header.Set(echo.HeaderContentDisposition, contentDisposition(c, name, contentType))
+	header.Set(echo.HeaderXContentTypeOptions, "nosniff")
+
+	if notModified(c.Request(), info) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	var byteRange *storage.ByteRange
+	if ifRangeMatches(c.Request(), info) {
+		var rangeErr error
+		byteRange, rangeErr = parseRange(c.Request().Header.Get("Range"), info.Size)
+		if rangeErr != nil {
+			header.Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+			return c.JSON(http.StatusRequestedRangeNotSatisfiable, "Requested range not satisfiable")
+		}
+	}
+
+	status := http.StatusOK
+	length := info.Size
+	if byteRange != nil {
+		status = http.StatusPartialContent
+		length = byteRange.End - byteRange.Start + 1
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", byteRange.Start, byteRange.End, info.Size))
+	}
+	header.Set(echo.HeaderContentLength, strconv.FormatInt(length, 10))
+
+	if c.Request().Method == http.MethodHead {
+		header.Set(echo.HeaderContentType, contentType)
+		return c.NoContent(status)
+	}
+
+	done := func(sent int64) {}
+	if gate != nil {
+		var gateErr error
+		done, gateErr = gate(info.Size, length)
+		if gateErr != nil {
+			header.Del(echo.HeaderContentLength)
+			header.Del("Content-Range")
+			return gateErr
+		}
+	}
+
+	// Download the file from s3
+	fileData, fileInfo, fileErr := h.S3Client.DownloadFile(ctx, key, byteRange)
+	if fileErr != nil {
+		log.Error().Err(fileErr).Msg("Error downloading file from s3")
+		done(0)
+		header.Del(echo.HeaderContentLength)
+		header.Del("Content-Range")
+		return c.JSON(400, "Error downloading file from s3")
+	}
+	defer fileData.Close()
+	// the headers and the range were worked out for the object that was
+	// stat'ed, they are wrong for anything written to the key since
+	if !sameObject(info, fileInfo) {
+		log.Error().Str("key", key).Msg("File changed between getting its metadata and downloading it")
+		done(0)
+		for _, name := range []string{echo.HeaderContentLength, "Content-Range", "ETag", "Last-Modified"} {
+			header.Del(name)
+		}
+		return c.JSON(http.StatusConflict, "The file changed while it was downloaded, try again")
+	}
+
+	// the size of the response is what the client was actually sent
+	defer func() { done(c.Response().Size) }()
+	return c.Stream(status, contentType, fileData)
+}
+
+// sameObject reports whether two reads of a key saw the same content
+func sameObject(stat *storage.ObjectInfo, download *storage.ObjectInfo) bool {
+	return download != nil && stat.Size == download.Size && stat.ETag == download.ETag
+}
+
+// parseRange reads a "bytes=" Range header. Only single ranges are served, a
+// missing, malformed or multi range header returns a nil range so the whole
+// file is sent, as RFC 9110 allows.
+func parseRange(rangeHeader string, size int64) (*storage.ByteRange, error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(rangeHeader), "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return nil, nil
+	}
+	startStr, endStr, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return nil, nil
+	}
+
+	// a suffix range, "bytes=-500" is the last 500 bytes
+	if startStr == "" {
+		suffix, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || suffix < 0 {
+			return nil, nil
+		}
+		if suffix == 0 || size == 0 {
+			return nil, errRangeNotSatisfiable
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return &storage.ByteRange{Start: size - suffix, End: size - 1}, nil
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 {
+		return nil, nil
+	}
+	end := size - 1
+	if endStr != "" {
+		end, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end < start {
+			return nil, nil
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return nil, errRangeNotSatisfiable
+	}
+	return &storage.ByteRange{Start: start, End: end}, nil
+}
+
+// notModified checks If-None-Match and, when that is absent, If-Modified-Since
+func notModified(r *http.Request, info *storage.ObjectInfo) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagListMatches(inm, info.ETag)
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !info.LastModified.IsZero() {
+		since, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		return !info.LastModified.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+// ifRangeMatches reports whether a Range header may be honoured, If-Range
+// holds either a strong ETag or a date the file must not have changed since
+func ifRangeMatches(r *http.Request, info *storage.ObjectInfo) bool {
+	ifRange := r.Header.Get("If-Range")
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) {
+		return info.ETag != "" && ifRange == info.ETag
+	}
+	since, err := http.ParseTime(ifRange)
+	if err != nil || info.LastModified.IsZero() {
+		return false
+	}
+	return !info.LastModified.Truncate(time.Second).After(since)
+}
+
+// etagListMatches does the weak comparison If-None-Match asks for
+func etagListMatches(list string, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// the content type is guessed from the file name first since objects are
+// uploaded without one
+func contentTypeFor(name string, info *storage.ObjectInfo) string {
+	if byExt := mime.TypeByExtension(filepath.Ext(name)); byExt != "" {
+		return byExt
+	}
+	if info.ContentType != "" && info.ContentType != "binary/octet-stream" {
+		return info.ContentType
+	}
+	return echo.MIMEOctetStream
+}
+
+// the types a browser shows without running anything, only these are served
+// inline. HTML, SVG and the like could run script on the API origin.
+var inlineTypes = map[string]bool{
+	"application/pdf": true,
+	"image/avif":      true,
+	"image/bmp":       true,
+	"image/gif":       true,
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/webp":      true,
+	"text/plain":      true,
+}
+
+// inlineSafe reports whether a file of contentType may be shown inline
+func inlineSafe(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "audio/") || strings.HasPrefix(mediaType, "video/") {
+		return true
+	}
+	return inlineTypes[mediaType]
+}
+
+// files are downloaded as attachments unless ?inline=true is passed, which is
+// what the video player uses, and the content type is safe to show inline
+func contentDisposition(c echo.Context, name string, contentType string) string {
+	disposition := "attachment"
+	if inline, _ := strconv.ParseBool(c.QueryParam("inline")); inline && inlineSafe(contentType) {
+		disposition = "inline"
+	}
+	return mime.FormatMediaType(disposition, map[string]string{"filename": name})
+}
@@ -0,0 +1,47 @@
+package routes
+
+import (
+	"cascloud/storage"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	cases := []struct {
+		header string
+		size   int64
+		want   *storage.ByteRange
+		err    error
+	}{
+		{"", 100, nil, nil},
+		{"bytes=0-9", 100, &storage.ByteRange{Start: 0, End: 9}, nil},
+		{"bytes=10-", 100, &storage.ByteRange{Start: 10, End: 99}, nil},
+		{"bytes=90-200", 100, &storage.ByteRange{Start: 90, End: 99}, nil},
+		{" bytes= 5-5 ", 100, &storage.ByteRange{Start: 5, End: 5}, nil},
+		// the last bytes of the file
+		{"bytes=-10", 100, &storage.ByteRange{Start: 90, End: 99}, nil},
+		{"bytes=-500", 100, &storage.ByteRange{Start: 0, End: 99}, nil},
+		{"bytes=-0", 100, nil, errRangeNotSatisfiable},
+		{"bytes=-5", 0, nil, errRangeNotSatisfiable},
+		{"bytes=100-", 100, nil, errRangeNotSatisfiable},
+		{"bytes=0-", 0, nil, errRangeNotSatisfiable},
+		// anything else is ignored and the whole file is sent
+		{"bytes=0-1,5-6", 100, nil, nil},
+		{"bytes=9-3", 100, nil, nil},
+		{"bytes=a-3", 100, nil, nil},
+		{"bytes=3-b", 100, nil, nil},
+		{"bytes=--5", 100, nil, nil},
+		{"bytes=-1-5", 100, nil, nil},
+		{"bytes=5", 100, nil, nil},
+		{"items=0-9", 100, nil, nil},
+	}
+	for _, tc := range cases {
+		got, err := parseRange(tc.header, tc.size)
+		if err != tc.err {
+			t.Errorf("parseRange(%q, %d) gave error %v, want %v", tc.header, tc.size, err, tc.err)
+			continue
+		}
+		if (got == nil) != (tc.want == nil) || (got != nil && *got != *tc.want) {
+			t.Errorf("parseRange(%q, %d) = %v, want %v", tc.header, tc.size, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package routes
+
+import (
+	"cascloud/helpers"
+
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// a function to add every route of the API to an echo instance
+func (h *HandlerClient) RegisterRoutes(e *echo.Echo) {
+	e.GET("/", func(c echo.Context) error {
+		return c.String(http.StatusOK, "Hello, World!")
+	})
+	e.POST("/users", h.RegisterUser)
+	e.POST("/login", h.LoginUser)
+	// share links are opened by people without an account
+	e.GET("/s/:token", h.GetShare)
+	e.POST("/s/:token/unlock", h.UnlockShare)
+	e.GET("/s/:token/folders/:id", h.GetSharedFolder)
+	e.GET("/s/:token/download", h.DownloadShare)
+	e.HEAD("/s/:token/download", h.DownloadShare)
+
+	// everything else needs a valid token
+	api := e.Group("", helpers.ValidateJWT(h.DBClient.GetUserByID))
+	api.POST("/upload", h.UploadFile)
+	api.POST("/create-folder", h.CreateFolder)
+	api.GET("/get-directory", h.GetDirectory)
+	api.GET("/get-workspaces", h.GetUsersWorkspaces)
+	api.GET("/get-files", h.GetFilesByFolderID)
+	api.GET("/download", h.DownloadFile)
+	api.HEAD("/download", h.DownloadFile)
+	api.GET("/get-user", h.GetUser)
+	api.POST("/uploads", h.CreateUpload)
+	api.GET("/uploads/:id", h.GetUpload)
+	api.PATCH("/uploads/:id", h.AppendUpload)
+	api.POST("/uploads/:id/complete", h.CompleteUpload)
+	api.DELETE("/uploads/:id", h.AbortUpload)
+	api.PATCH("/files/:id", h.EditFile)
+	api.DELETE("/files/:id", h.DeleteFile)
+	api.POST("/files/:id/move", h.MoveFile)
+	api.POST("/files/:id/copy", h.CopyFile)
+	api.GET("/files/:id/versions", h.GetFileVersions)
+	api.GET("/files/:id/versions/:version/download", h.DownloadFileVersion)
+	api.POST("/files/:id/versions/:version/promote", h.PromoteFileVersion)
+	api.PATCH("/folders/:id", h.EditFolder)
+	api.DELETE("/folders/:id", h.DeleteFolder)
+	api.GET("/folders/:id/download", h.DownloadFolder)
+	api.POST("/folders/:id/move", h.MoveFolder)
+	api.POST("/folders/:id/copy", h.CopyFolder)
+	api.POST("/folders/:id/arrange", h.ArrangeFolder)
+	api.GET("/folders/:id/export", h.ExportCanvas)
+	api.GET("/folders/:id/layout/history", h.GetLayoutHistory)
+	api.POST("/folders/:id/layout/undo", h.UndoLayout)
+	api.POST("/folders/:id/layout/redo", h.RedoLayout)
+	api.POST("/folders/:id/layout/restore", h.RestoreLayout)
+	api.PATCH("/layout", h.UpdateLayout)
+	api.POST("/canvas-items", h.CreateCanvasItem)
+	api.GET("/canvas-items/:id", h.GetCanvasItem)
+	api.PATCH("/canvas-items/:id", h.UpdateCanvasItem)
+	api.DELETE("/canvas-items/:id", h.DeleteCanvasItem)
+	api.POST("/connectors", h.CreateConnector)
+	api.GET("/connectors/:id", h.GetConnector)
+	api.PATCH("/connectors/:id", h.UpdateConnector)
+	api.DELETE("/connectors/:id", h.DeleteConnector)
+	api.GET("/trash", h.GetTrash)
+	api.DELETE("/trash", h.EmptyTrash)
+	api.POST("/trash/:id/restore", h.RestoreTrashItem)
+	api.DELETE("/trash/:id", h.PurgeTrashItem)
+	api.GET("/workspaces/:id/members", h.GetWorkspaceMembers)
+	api.PATCH("/workspaces/:id/members/:user_id", h.UpdateMember)
+	api.DELETE("/workspaces/:id/members/:user_id", h.RemoveMember)
+	api.POST("/workspaces/:id/leave", h.LeaveWorkspace)
+	api.GET("/workspaces/:id/invitations", h.GetWorkspaceInvitations)
+	api.POST("/workspaces/:id/invitations", h.InviteMember)
+	api.GET("/invitations", h.GetMyInvitations)
+	api.POST("/invitations/:id/accept", h.AcceptInvitation)
+	api.POST("/invitations/:id/decline", h.DeclineInvitation)
+	api.GET("/shares", h.GetShareLinks)
+	api.POST("/shares", h.CreateShareLink)
+	api.DELETE("/shares/:id", h.DeleteShareLink)
+	api.GET("/workspaces/:id/events", h.WorkspaceEvents)
+}
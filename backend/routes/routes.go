@@ -2,30 +2,38 @@ package routes
 
 import (
 	"cascloud/db"
+	"cascloud/events"
 	"cascloud/helpers"
 	"cascloud/models"
 	"cascloud/storage"
 
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"strconv"
 	"net/http"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
-	"github.com/lib/pq"
 	"github.com/rs/zerolog/log"
 )
 
 type HandlerClient struct {
 	DBClient db.DBInterface
 	S3Client storage.S3Interface
+	// pushes workspace changes to connected clients, nil disables events
+	Events *events.Hub
 }
 
 var upgrader = websocket.Upgrader{
-    ReadBufferSize:  1024,
-    WriteBufferSize: 1024,
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// like CORS any origin may connect, every connection still needs a token
+	CheckOrigin: func(r *http.Request) bool { return true },
 }
 
 type Handler interface {
@@ -50,24 +58,27 @@ func (h *HandlerClient) RegisterUser(c echo.Context) error {
 		return c.JSON(400, "User already exists")
 	}
 
-	createErr := h.DBClient.CreateUser(&user)
-	if createErr != nil {
-		return createErr
-	}
+	// the user and their workspace are created together or not at all
+	registerErr := h.DBClient.Transaction(func(tx db.DBInterface) error {
+		createErr := tx.CreateUser(&user)
+		if createErr != nil {
+			return createErr
+		}
 
-	workspaceID := uuid.New()
+		workspaceID := uuid.New()
 
-	workspace := models.Workspace{
-		ID:      workspaceID,
-		Name:    fmt.Sprintf("%s's Workspace", user.FirstName),
-		OwnerID: user.ID,
-		Users:   pq.StringArray{user.ID.String()},
-	}
+		workspace := models.Workspace{
+			ID:      workspaceID,
+			Name:    fmt.Sprintf("%s's Workspace", user.FirstName),
+			OwnerID: user.ID,
+		}
 
-	// create a workspace for the user
-	workspaceErr := h.DBClient.CreateWorkspace(&workspace, &user)
-	if workspaceErr != nil {
-		return workspaceErr
+		// create a workspace for the user
+		return tx.CreateWorkspace(&workspace, &user)
+	})
+	if registerErr != nil {
+		log.Error().Err(registerErr).Msg("Error registering user in database")
+		return c.JSON(400, "Error registering user in database")
 	}
 
 	return c.JSON(200, user)
@@ -107,17 +118,11 @@ func (h *HandlerClient) LoginUser(c echo.Context) error {
 
 }
 
+// a function to get the authenticated user
 func (h *HandlerClient) GetUser(c echo.Context) error {
-	userID := c.QueryParam("user_id")
-	if userID == "" {
-		log.Error().Msg("User ID not provided")
-		return c.JSON(400, "User ID not provided")
-	}
-	// Get the user from the database
-	user, userErr := h.DBClient.GetUserByID(userID)
-	if userErr != nil {
-		log.Error().Err(userErr).Msg("Error getting user from database")
-		return c.JSON(400, "Error getting user from database")
+	user := helpers.CurrentUser(c)
+	if user == nil {
+		return c.JSON(401, "Not authenticated")
 	}
 
 	return c.JSON(200, user)
@@ -135,18 +140,39 @@ func (h *HandlerClient) CreateFolder(c echo.Context) error {
 		return bindErr
 	}
 
+	parent, parentErr := h.DBClient.GetFolderByID(folderReq.ParentID)
+	if parentErr != nil {
+		log.Error().Err(parentErr).Msg("Error getting parent folder from database")
+		return c.JSON(404, "Parent folder not found")
+	}
+	if err := h.authorizeFolder(c, parent, models.RoleEditor); err != nil {
+		return err
+	}
+	if !validFileName(folderReq.Name) {
+		return c.JSON(400, "Invalid folder name")
+	}
+
+	// the folder always lives in the workspace of its parent
 	folder := models.Folder{
 		Name:        folderReq.Name,
-		WorkspaceID: uuid.MustParse(folderReq.WorkspaceID),
-		ParentID:    uuid.MustParse(folderReq.ParentID),
+		WorkspaceID: parent.WorkspaceID,
+		ParentID:    parent.ID,
 	}
 
 	// create the folder in the database
 	folderErr := h.DBClient.CreateFolder(&folder)
+	if errors.Is(folderErr, db.ErrNameTaken) {
+		return c.JSON(http.StatusConflict, "An item with this name already exists in the folder")
+	}
+	if errors.Is(folderErr, db.ErrTreeChanged) {
+		return c.JSON(http.StatusConflict, "The parent folder was changed by someone else, try again")
+	}
 	if folderErr != nil {
-		return folderErr
+		log.Error().Err(folderErr).Msg("Error creating folder in database")
+		return c.JSON(400, "Error creating folder in database")
 	}
 
+	h.publish(c, events.FolderCreated, folder.WorkspaceID, folder.ParentID, folder)
 	return c.JSON(200, folder)
 }
 
@@ -199,31 +225,41 @@ func (h *HandlerClient) UploadFile(c echo.Context) error {
 		log.Error().Err(folderErr).Msg("Error getting folder from database")
 		return c.JSON(400, "Error getting folder from database")
 	}
+	if err := h.authorizeFolder(c, folder, models.RoleEditor); err != nil {
+		return err
+	}
 
-	path := fmt.Sprintf("%s/%s", folder.Path, file.Filename)
-	fmt.Println(path)
-	// Upload the file to s3
-	uploadErr := h.S3Client.UploadFile(context.Background(), path, fileData)
-	if uploadErr != nil {
-		log.Error().Err(uploadErr).Msg("Error uploading file to s3")
-		return c.JSON(400, "Error uploading file to s3")
+	if !validFileName(file.Filename) {
+		return c.JSON(400, "Invalid file name")
 	}
 
-	// Create the file in the database
-	fileModel := models.File{
-		Name:     file.Filename,
-		FolderID: folder.ID,
-		Size:     fileSize,
-		Path:     path,
-		X:        fileX,
-		Y:        fileY,
+	// hash the upload before sending it, the form file can be read twice
+	hash := sha256.New()
+	if _, hashErr := io.Copy(hash, fileData); hashErr != nil {
+		log.Error().Err(hashErr).Msg("Error reading file")
+		return c.JSON(400, "Error reading file")
 	}
-	fileErr := h.DBClient.CreateFile(&fileModel)
-	if fileErr != nil {
-		log.Error().Err(fileErr).Msg("Error creating file in database")
-		return c.JSON(400, "Error creating file in database")
+	if _, seekErr := fileData.Seek(0, io.SeekStart); seekErr != nil {
+		log.Error().Err(seekErr).Msg("Error reading file")
+		return c.JSON(400, "Error reading file")
 	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
 
+	// Upload the file to s3, a file with the same name gets a new version
+	fileModel, commitErr := h.commitFile(c.Request().Context(), folder, file.Filename, fileX, fileY, callerID(c), func(ctx context.Context, key string) (int64, string, error) {
+		return fileSize, checksum, h.S3Client.UploadFile(ctx, key, fileData)
+	})
+	if errors.Is(commitErr, db.ErrFileChanged) {
+		return c.JSON(http.StatusConflict, "The file was changed by someone else, try again")
+	}
+	if errors.Is(commitErr, db.ErrNameTaken) {
+		return c.JSON(http.StatusConflict, "A folder with this name already exists in the folder")
+	}
+	if commitErr != nil {
+		return c.JSON(400, "Error uploading file")
+	}
+
+	h.publish(c, commitEvent(fileModel), folder.WorkspaceID, folder.ID, fileModel)
 	return c.JSON(200, fileModel)
 }
 
@@ -239,15 +275,8 @@ func (h *HandlerClient) EditFile(c echo.Context) error {
 		return bindErr
 	}
 
-	file := models.File{
-		ID:       uuid.MustParse(fileReq.ID),
-		Name:     fileReq.Name,
-		FolderID: uuid.MustParse(fileReq.FolderID),
-		X:        fileReq.X,
-		Y:        fileReq.Y,
-	}
-
-
+	return h.editFile(c, fileReq)
+}
 
 // a function to get all files from a folder
 func (h *HandlerClient) GetFilesByFolderID(c echo.Context) error {
@@ -256,6 +285,9 @@ func (h *HandlerClient) GetFilesByFolderID(c echo.Context) error {
 		log.Error().Msg("Folder ID not provided")
 		return c.JSON(400, "Folder ID not provided")
 	}
+	if err := h.authorizeFolderID(c, folderID, models.RoleViewer); err != nil {
+		return err
+	}
 	// Get the files from the database
 	files, filesErr := h.DBClient.GetFilesByFolderID(folderID)
 	if filesErr != nil {
@@ -266,33 +298,89 @@ func (h *HandlerClient) GetFilesByFolderID(c echo.Context) error {
 	return c.JSON(200, files)
 }
 
+// a function to list a folder, with min_x, min_y, max_x and max_y only the
+// items on that part of the canvas are listed, see parseViewport
 func (h *HandlerClient) GetDirectory(c echo.Context) error {
 	folderID := c.QueryParam("folder_id")
 	if folderID == "" {
 		log.Error().Msg("Folder ID not provided")
 		return c.JSON(400, "Folder ID not provided")
 	}
+	viewport, viewportErr := parseViewport(c)
+	if viewportErr != nil {
+		return viewportErr
+	}
+	if err := h.authorizeFolderID(c, folderID, models.RoleViewer); err != nil {
+		return err
+	}
+	if viewport != nil {
+		folders, files, folderErr := h.DBClient.GetFoldersAndFilesInViewport(folderID, *viewport)
+		if folderErr != nil {
+			log.Error().Err(folderErr).Msg("Error getting folder from database")
+			return c.JSON(400, "Error getting folder from database")
+		}
+		items, itemsErr := h.DBClient.GetCanvasItemsInViewport(folderID, *viewport)
+		if itemsErr != nil {
+			log.Error().Err(itemsErr).Msg("Error getting canvas items from database")
+			return c.JSON(400, "Error getting canvas items from database")
+		}
+		// a connector is sent when at least one of its ends is in view
+		ids := make([]string, 0, len(folders)+len(files)+len(items))
+		for _, folder := range folders {
+			ids = append(ids, folder.ID.String())
+		}
+		for _, file := range files {
+			ids = append(ids, file.ID.String())
+		}
+		for _, item := range items {
+			ids = append(ids, item.ID.String())
+		}
+		connectors, connectorsErr := h.DBClient.GetConnectorsOfItems(folderID, ids)
+		if connectorsErr != nil {
+			log.Error().Err(connectorsErr).Msg("Error getting connectors from database")
+			return c.JSON(400, "Error getting connectors from database")
+		}
+		return c.JSON(200, map[string]interface{}{
+			"folders":    folders,
+			"files":      files,
+			"items":      items,
+			"connectors": connectors,
+			"viewport":   viewport,
+		})
+	}
 	// Get the folder from the database
 	folders, files, folderErr := h.DBClient.GetFoldersAndFilesInFolder(folderID)
 	if folderErr != nil {
 		log.Error().Err(folderErr).Msg("Error getting folder from database")
 		return c.JSON(400, "Error getting folder from database")
 	}
+	// notes, links and frames live on the canvas next to files and folders
+	items, itemsErr := h.DBClient.GetCanvasItemsInFolder(folderID)
+	if itemsErr != nil {
+		log.Error().Err(itemsErr).Msg("Error getting canvas items from database")
+		return c.JSON(400, "Error getting canvas items from database")
+	}
+	connectors, connectorsErr := h.DBClient.GetConnectorsInFolder(folderID)
+	if connectorsErr != nil {
+		log.Error().Err(connectorsErr).Msg("Error getting connectors from database")
+		return c.JSON(400, "Error getting connectors from database")
+	}
 
 	return c.JSON(200, map[string]interface{}{
-		"folders": folders,
-		"files":   files,
+		"folders":    folders,
+		"files":      files,
+		"items":      items,
+		"connectors": connectors,
 	})
 }
 
 func (h *HandlerClient) GetUsersWorkspaces(c echo.Context) error {
-	userID := c.QueryParam("user_id")
-	if userID == "" {
-		log.Error().Msg("User ID not provided")
-		return c.JSON(400, "User ID not provided")
+	userID := callerID(c)
+	if userID == uuid.Nil {
+		return c.JSON(401, "Not authenticated")
 	}
 	// Get the workspaces from the database
-	workspaces, workspacesErr := h.DBClient.GetWorkspacesAvailableWorkspaces(userID)
+	workspaces, workspacesErr := h.DBClient.GetWorkspacesAvailableWorkspaces(userID.String())
 	if workspacesErr != nil {
 		log.Error().Err(workspacesErr).Msg("Error getting workspaces from database")
 		return c.JSON(400, "Error getting workspaces from database")
@@ -313,12 +401,16 @@ func (h *HandlerClient) DownloadFile(c echo.Context) error {
 		log.Error().Err(err).Msg("Error getting file from database")
 		return c.JSON(400, "Error getting file from database")
 	}
-	// Download the file from s3
-	fileData, fileErr := h.S3Client.DownloadFile(context.Background(), file.Path)
-	if fileErr != nil {
-		log.Error().Err(fileErr).Msg("Error downloading file from s3")
-		return c.JSON(400, "Error downloading file from s3")
+	if err := h.authorizeFile(c, file, models.RoleViewer); err != nil {
+		return err
 	}
+	return h.streamObject(c, file.StorageKey, file.Name)
+}
 
-	return c.Stream(200, "application/octet-stream", fileData)
+// callerID is the id of the authenticated user making the request
+func callerID(c echo.Context) uuid.UUID {
+	if user := helpers.CurrentUser(c); user != nil {
+		return user.ID
+	}
+	return uuid.Nil
 }
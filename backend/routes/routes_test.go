@@ -0,0 +1,1036 @@
+package routes_test
+
+import (
+	"cascloud/db"
+	"cascloud/events"
+	"cascloud/models"
+	"cascloud/testsupport"
+	"cascloud/types"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRegisterUser(t *testing.T) {
+	s := testsupport.NewServer(t)
+	user, _ := s.Register("alice")
+
+	if user.ID == uuid.Nil {
+		t.Fatal("the user has no id")
+	}
+	if user.PasswordHash == "password-alice" {
+		t.Error("the password is stored in plain text")
+	}
+	if len(user.Workspaces) != 1 {
+		t.Fatalf("the user has %d workspaces, want 1", len(user.Workspaces))
+	}
+	home := s.HomeFolder(user)
+	if home.Path != "home@alice" || home.ParentID != uuid.Nil {
+		t.Errorf("home folder is %q with parent %s", home.Path, home.ParentID)
+	}
+
+	rec := s.Do(http.MethodPost, "/users", "", map[string]string{
+		"first_name": "alice",
+		"last_name":  "again",
+		"email":      "alice@example.com",
+		"username":   "alice2",
+		"password":   "password",
+	})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("registering an email twice gave %d, want 400", rec.Code)
+	}
+}
+
+func TestLoginUser(t *testing.T) {
+	s := testsupport.NewServer(t)
+	user, _ := s.Register("alice")
+
+	rec := s.Do(http.MethodPost, "/login", "", models.UserLogin{Email: "alice@example.com", Password: "password-alice"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login gave %d: %s", rec.Code, rec.Body.String())
+	}
+	var login struct {
+		Token string      `json:"token"`
+		User  models.User `json:"user"`
+	}
+	testsupport.Decode(t, rec, &login)
+	if login.Token == "" || login.User.ID != user.ID {
+		t.Fatalf("login returned token %q for user %s", login.Token, login.User.ID)
+	}
+
+	rec = s.Do(http.MethodGet, "/get-user", login.Token, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("the login token was refused with %d", rec.Code)
+	}
+	var current models.User
+	testsupport.Decode(t, rec, &current)
+	if current.ID != user.ID || len(current.Workspaces) != 1 || current.Workspaces[0] != user.Workspaces[0] {
+		t.Errorf("get-user returned %+v", current)
+	}
+
+	cases := []struct {
+		name  string
+		login models.UserLogin
+		want  int
+	}{
+		{"wrong password", models.UserLogin{Email: "alice@example.com", Password: "nope"}, http.StatusUnauthorized},
+		{"unknown email", models.UserLogin{Email: "bob@example.com", Password: "password-alice"}, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if rec := s.Do(http.MethodPost, "/login", "", tc.login); rec.Code != tc.want {
+				t.Errorf("got %d, want %d", rec.Code, tc.want)
+			}
+		})
+	}
+}
+
+func TestRoutesNeedToken(t *testing.T) {
+	s := testsupport.NewServer(t)
+	for _, token := range []string{"", "not-a-token"} {
+		if rec := s.Do(http.MethodGet, "/get-user", token, nil); rec.Code != http.StatusUnauthorized {
+			t.Errorf("token %q gave %d, want 401", token, rec.Code)
+		}
+	}
+}
+
+func TestCreateFolder(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	_, bobToken := s.Register("bob")
+	home := s.HomeFolder(alice)
+
+	rec := s.Do(http.MethodPost, "/create-folder", aliceToken, models.CreateFolderRequest{Name: "docs", ParentID: home.ID.String()})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create folder gave %d: %s", rec.Code, rec.Body.String())
+	}
+	var folder models.Folder
+	testsupport.Decode(t, rec, &folder)
+	if folder.Path != "home@alice/docs" || folder.ParentID != home.ID || folder.WorkspaceID != home.WorkspaceID {
+		t.Errorf("created %+v", folder)
+	}
+
+	cases := []struct {
+		name   string
+		token  string
+		parent string
+		folder string
+		want   int
+	}{
+		{"someone else's folder", bobToken, home.ID.String(), "x", http.StatusForbidden},
+		{"missing parent", aliceToken, uuid.NewString(), "x", http.StatusNotFound},
+		{"bad name", aliceToken, home.ID.String(), "..", http.StatusBadRequest},
+		{"name with a slash", aliceToken, home.ID.String(), "a/b", http.StatusBadRequest},
+		{"folder name taken", aliceToken, home.ID.String(), "docs", http.StatusConflict},
+		{"file name taken", aliceToken, home.ID.String(), "notes.txt", http.StatusConflict},
+	}
+	upload(t, s, aliceToken, home.ID, "notes.txt", "hello")
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := s.Do(http.MethodPost, "/create-folder", tc.token, models.CreateFolderRequest{Name: tc.folder, ParentID: tc.parent})
+			if rec.Code != tc.want {
+				t.Errorf("got %d, want %d", rec.Code, tc.want)
+			}
+		})
+	}
+}
+
+func upload(t *testing.T, s *testsupport.Server, token string, folderID uuid.UUID, name string, content string) models.File {
+	t.Helper()
+	rec := s.Do(http.MethodPost, "/upload", token, uploadForm(folderID.String(), name, content, "10", "20"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("uploading %s gave %d: %s", name, rec.Code, rec.Body.String())
+	}
+	var file models.File
+	testsupport.Decode(t, rec, &file)
+	return file
+}
+
+func uploadForm(folderID string, name string, content string, x string, y string) testsupport.Form {
+	return testsupport.Form{
+		Fields: map[string]string{"folder_id": folderID, "x_coordinate": x, "y_coordinate": y},
+		Files:  map[string]testsupport.FormFile{"file": {Name: name, Content: []byte(content)}},
+	}
+}
+
+func TestUploadFile(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	_, bobToken := s.Register("bob")
+	home := s.HomeFolder(alice)
+
+	file := upload(t, s, aliceToken, home.ID, "notes.txt", "hello")
+	if file.Path != "home@alice/notes.txt" || file.Size != 5 || file.X != 10 || file.Y != 20 || file.FolderID != home.ID {
+		t.Errorf("uploaded %+v", file)
+	}
+	stored, err := s.DB.GetFileByID(file.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, ok := s.Storage.Object(stored.StorageKey); !ok || string(content) != "hello" {
+		t.Errorf("storage holds %q at %s", content, stored.StorageKey)
+	}
+
+	cases := []struct {
+		name  string
+		token string
+		form  testsupport.Form
+		want  int
+	}{
+		{"someone else's folder", bobToken, uploadForm(home.ID.String(), "b.txt", "b", "0", "0"), http.StatusForbidden},
+		{"no folder", aliceToken, uploadForm("", "b.txt", "b", "0", "0"), http.StatusBadRequest},
+		{"bad coordinates", aliceToken, uploadForm(home.ID.String(), "b.txt", "b", "left", "0"), http.StatusBadRequest},
+		{"bad name", aliceToken, uploadForm(home.ID.String(), "..", "b", "0", "0"), http.StatusBadRequest},
+		{"no file", aliceToken, testsupport.Form{Fields: map[string]string{"folder_id": home.ID.String()}}, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if rec := s.Do(http.MethodPost, "/upload", tc.token, tc.form); rec.Code != tc.want {
+				t.Errorf("got %d, want %d: %s", rec.Code, tc.want, rec.Body.String())
+			}
+		})
+	}
+	if keys := s.Storage.Keys(); len(keys) != 1 {
+		t.Errorf("storage holds %v after the failed uploads", keys)
+	}
+}
+
+func TestUploadFileOverFolder(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	home := s.HomeFolder(alice)
+	createFolder(t, s, aliceToken, home.ID, "docs")
+
+	rec := s.Do(http.MethodPost, "/upload", aliceToken, uploadForm(home.ID.String(), "docs", "hello", "0", "0"))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("uploading over a folder gave %d, want 409: %s", rec.Code, rec.Body.String())
+	}
+	rec = s.Do(http.MethodPost, "/uploads", aliceToken, models.CreateUploadRequest{FolderID: home.ID.String(), Name: "docs", Size: 5})
+	if rec.Code != http.StatusConflict {
+		t.Errorf("starting an upload over a folder gave %d, want 409: %s", rec.Code, rec.Body.String())
+	}
+	if keys := s.Storage.Keys(); len(keys) != 0 {
+		t.Errorf("storage holds %v", keys)
+	}
+}
+
+func TestMoveToOtherWorkspace(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	bob, _ := s.Register("bob")
+	editor, err := s.DB.GetRoleByName(models.RoleEditor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DB.CreateCollaboration(&models.Collaborations{UserID: alice.ID, RoleID: editor.ID, WorkspaceID: bob.Workspaces[0]}); err != nil {
+		t.Fatal(err)
+	}
+	home, bobHome := s.HomeFolder(alice), s.HomeFolder(bob)
+	docs := createFolder(t, s, aliceToken, home.ID, "docs")
+	file := upload(t, s, aliceToken, docs.ID, "notes.txt", "hello")
+	x, y := 1.0, 2.0
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+	}{
+		{"move file", http.MethodPost, "/files/" + file.ID.String() + "/move", models.MoveFileRequest{FolderID: bobHome.ID.String()}},
+		{"move folder", http.MethodPost, "/folders/" + docs.ID.String() + "/move", models.MoveFolderRequest{ParentID: bobHome.ID.String()}},
+		{"layout file", http.MethodPatch, "/layout", models.UpdateLayoutRequest{Items: []models.LayoutItem{
+			{ItemType: models.LayoutItemFile, ID: file.ID.String(), X: &x, Y: &y, FolderID: bobHome.ID.String()},
+		}}},
+		{"layout folder", http.MethodPatch, "/layout", models.UpdateLayoutRequest{Items: []models.LayoutItem{
+			{ItemType: models.LayoutItemFolder, ID: docs.ID.String(), X: &x, Y: &y, FolderID: bobHome.ID.String()},
+		}}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := s.Do(tc.method, tc.path, aliceToken, tc.body)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("got %d, want 400: %s", rec.Code, rec.Body.String())
+			}
+		})
+	}
+	checkPaths(t, s, docs, "home@alice/docs", file, "home@alice/docs/notes.txt")
+
+	// copies get new rows in the other workspace
+	rec := s.Do(http.MethodPost, "/folders/"+docs.ID.String()+"/copy", aliceToken, models.MoveFolderRequest{ParentID: bobHome.ID.String()})
+	if rec.Code != http.StatusOK {
+		t.Errorf("copying gave %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestGetDirectory(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	_, bobToken := s.Register("bob")
+	home := s.HomeFolder(alice)
+
+	rec := s.Do(http.MethodPost, "/create-folder", aliceToken, models.CreateFolderRequest{Name: "docs", ParentID: home.ID.String()})
+	var docs models.Folder
+	testsupport.Decode(t, rec, &docs)
+	upload(t, s, aliceToken, home.ID, "a.txt", "a")
+	upload(t, s, aliceToken, docs.ID, "b.txt", "b")
+
+	rec = s.Do(http.MethodGet, "/get-directory?folder_id="+home.ID.String(), aliceToken, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get-directory gave %d: %s", rec.Code, rec.Body.String())
+	}
+	var directory struct {
+		Folders []models.Folder `json:"folders"`
+		Files   []models.File   `json:"files"`
+	}
+	testsupport.Decode(t, rec, &directory)
+	if len(directory.Folders) != 1 || directory.Folders[0].ID != docs.ID {
+		t.Errorf("folders %+v, want only docs", directory.Folders)
+	}
+	if len(directory.Files) != 1 || directory.Files[0].Name != "a.txt" {
+		t.Errorf("files %+v, want only a.txt", directory.Files)
+	}
+
+	if rec := s.Do(http.MethodGet, "/get-directory?folder_id="+home.ID.String(), bobToken, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("someone else's folder gave %d, want 403", rec.Code)
+	}
+	if rec := s.Do(http.MethodGet, "/get-directory", aliceToken, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("no folder gave %d, want 400", rec.Code)
+	}
+}
+
+func TestGetDirectoryViewport(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	home := s.HomeFolder(alice)
+	for name, position := range map[string]string{"near.txt": "50", "edge.txt": "300", "far.txt": "5000"} {
+		rec := s.Do(http.MethodPost, "/upload", aliceToken, uploadForm(home.ID.String(), name, "x", position, position))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("uploading %s gave %d: %s", name, rec.Code, rec.Body.String())
+		}
+	}
+	// a frame reaches into the viewport from far outside it, another one sits far away
+	title := "frame"
+	for _, box := range [][4]float64{{-1000, -1000, 2000, 2000}, {9000, 9000, 10, 10}} {
+		rec := s.Do(http.MethodPost, "/canvas-items", aliceToken, models.CanvasItemRequest{
+			FolderID: home.ID.String(),
+			Kind:     models.CanvasItemFrame,
+			X:        &box[0],
+			Y:        &box[1],
+			Width:    &box[2],
+			Height:   &box[3],
+			Title:    &title,
+		})
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("creating a canvas item gave %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	// the viewport grows by the overscan and the size of an item
+	rec := s.Do(http.MethodGet, "/get-directory?folder_id="+home.ID.String()+"&min_x=0&min_y=0&max_x=100&max_y=100", aliceToken, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get-directory gave %d: %s", rec.Code, rec.Body.String())
+	}
+	var directory struct {
+		Files []models.File       `json:"files"`
+		Items []models.CanvasItem `json:"items"`
+	}
+	testsupport.Decode(t, rec, &directory)
+	names := map[string]bool{}
+	for _, file := range directory.Files {
+		names[file.Name] = true
+	}
+	if len(names) != 2 || !names["near.txt"] || !names["edge.txt"] {
+		t.Errorf("files %v are in view, want near.txt and edge.txt", names)
+	}
+	if len(directory.Items) != 1 || directory.Items[0].X != -1000 {
+		t.Errorf("items %+v are in view, want the frame", directory.Items)
+	}
+}
+
+func TestDownloadFile(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	_, bobToken := s.Register("bob")
+	file := upload(t, s, aliceToken, s.HomeFolder(alice).ID, "notes.txt", "hello world")
+	path := "/download?file_id=" + file.ID.String()
+
+	rec := s.Do(http.MethodGet, path, aliceToken, nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello world" {
+		t.Fatalf("download gave %d %q", rec.Code, rec.Body.String())
+	}
+	if disposition := rec.Header().Get("Content-Disposition"); disposition == "" {
+		t.Error("the download has no Content-Disposition")
+	}
+
+	req := s.Request(http.MethodGet, path, aliceToken, nil)
+	req.Header.Set("Range", "bytes=6-")
+	rec = s.Serve(req)
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "world" {
+		t.Errorf("a range gave %d %q", rec.Code, rec.Body.String())
+	}
+
+	if rec := s.Do(http.MethodGet, path, bobToken, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("someone else's file gave %d, want 403", rec.Code)
+	}
+	if rec := s.Do(http.MethodGet, "/download?file_id="+uuid.NewString(), aliceToken, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("a missing file gave %d, want 400", rec.Code)
+	}
+}
+
+func TestDownloadChangedFile(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	file := upload(t, s, aliceToken, s.HomeFolder(alice).ID, "notes.txt", "hello world")
+	stored, err := s.DB.GetFileByID(file.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the object is replaced by one of the same size after its metadata was read
+	s.Storage.BeforeDownload = func(key string) {
+		s.Storage.BeforeDownload = nil
+		s.Storage.UploadFile(context.Background(), key, strings.NewReader("HELLO WORLD"))
+	}
+
+	req := s.Request(http.MethodGet, "/download?file_id="+file.ID.String(), aliceToken, nil)
+	req.Header.Set("Range", "bytes=6-")
+	rec := s.Serve(req)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("a changed file gave %d %q, want 409", rec.Code, rec.Body.String())
+	}
+	for _, header := range []string{"Content-Range", "ETag"} {
+		if value := rec.Header().Get(header); value != "" {
+			t.Errorf("the conflict has %s %q", header, value)
+		}
+	}
+
+	rec = s.Do(http.MethodGet, "/download?file_id="+file.ID.String(), aliceToken, nil)
+	if content, _ := s.Storage.Object(stored.StorageKey); rec.Code != http.StatusOK || rec.Body.String() != string(content) {
+		t.Errorf("downloading again gave %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func createFolder(t *testing.T, s *testsupport.Server, token string, parentID uuid.UUID, name string) models.Folder {
+	t.Helper()
+	rec := s.Do(http.MethodPost, "/create-folder", token, models.CreateFolderRequest{Name: name, ParentID: parentID.String()})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("creating folder %s gave %d: %s", name, rec.Code, rec.Body.String())
+	}
+	var folder models.Folder
+	testsupport.Decode(t, rec, &folder)
+	return folder
+}
+
+// checkPaths fails the test unless the folder and file have the given paths
+// in the database and the file's content is still in storage
+func checkPaths(t *testing.T, s *testsupport.Server, folder models.Folder, folderPath string, file models.File, filePath string) {
+	t.Helper()
+	stored, err := s.DB.GetFolderByID(folder.ID.String())
+	if err != nil || stored.Path != folderPath {
+		t.Errorf("folder %s has path %q, want %q (%v)", folder.Name, stored.Path, folderPath, err)
+	}
+	storedFile, err := s.DB.GetFileByID(file.ID.String())
+	if err != nil || storedFile.Path != filePath {
+		t.Errorf("file %s has path %q, want %q (%v)", file.Name, storedFile.Path, filePath, err)
+	}
+	if _, ok := s.Storage.Object(storedFile.StorageKey); !ok {
+		t.Errorf("storage has no object at %s: %v", storedFile.StorageKey, s.Storage.Keys())
+	}
+}
+
+func TestEditFolder(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	_, bobToken := s.Register("bob")
+	home := s.HomeFolder(alice)
+	docs := createFolder(t, s, aliceToken, home.ID, "docs")
+	sub := createFolder(t, s, aliceToken, docs.ID, "sub")
+	createFolder(t, s, aliceToken, home.ID, "other")
+	file := upload(t, s, aliceToken, sub.ID, "notes.txt", "hello")
+	keys := s.Storage.Keys()
+
+	rec := s.Do(http.MethodPatch, "/folders/"+docs.ID.String(), aliceToken, models.EditFolderRequest{Name: "papers"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("renaming gave %d: %s", rec.Code, rec.Body.String())
+	}
+	var renamed models.Folder
+	testsupport.Decode(t, rec, &renamed)
+	if renamed.Name != "papers" || renamed.Path != "home@alice/papers" || renamed.ParentID != home.ID {
+		t.Errorf("renamed to %+v", renamed)
+	}
+	checkPaths(t, s, sub, "home@alice/papers/sub", file, "home@alice/papers/sub/notes.txt")
+	if after := s.Storage.Keys(); len(after) != 1 || after[0] != keys[0] {
+		t.Errorf("renaming changed storage from %v to %v", keys, after)
+	}
+
+	cases := []struct {
+		name   string
+		token  string
+		folder uuid.UUID
+		edit   models.EditFolderRequest
+		want   int
+	}{
+		{"home folder", aliceToken, home.ID, models.EditFolderRequest{Name: "house"}, http.StatusBadRequest},
+		{"bad name", aliceToken, docs.ID, models.EditFolderRequest{Name: ".."}, http.StatusBadRequest},
+		{"name taken", aliceToken, docs.ID, models.EditFolderRequest{Name: "other"}, http.StatusConflict},
+		{"someone else's folder", bobToken, docs.ID, models.EditFolderRequest{Name: "mine"}, http.StatusForbidden},
+		{"missing folder", aliceToken, uuid.New(), models.EditFolderRequest{Name: "x"}, http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := s.Do(http.MethodPatch, "/folders/"+tc.folder.String(), tc.token, tc.edit)
+			if rec.Code != tc.want {
+				t.Errorf("got %d, want %d: %s", rec.Code, tc.want, rec.Body.String())
+			}
+		})
+	}
+	checkPaths(t, s, sub, "home@alice/papers/sub", file, "home@alice/papers/sub/notes.txt")
+}
+
+func TestMoveFolder(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	home := s.HomeFolder(alice)
+	docs := createFolder(t, s, aliceToken, home.ID, "docs")
+	sub := createFolder(t, s, aliceToken, docs.ID, "sub")
+	other := createFolder(t, s, aliceToken, home.ID, "other")
+	file := upload(t, s, aliceToken, sub.ID, "notes.txt", "hello")
+	keys := s.Storage.Keys()
+
+	rec := s.Do(http.MethodPost, "/folders/"+docs.ID.String()+"/move", aliceToken, models.MoveFolderRequest{ParentID: other.ID.String()})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("moving gave %d: %s", rec.Code, rec.Body.String())
+	}
+	checkPaths(t, s, docs, "home@alice/other/docs", file, "home@alice/other/docs/sub/notes.txt")
+	checkPaths(t, s, sub, "home@alice/other/docs/sub", file, "home@alice/other/docs/sub/notes.txt")
+
+	for _, dest := range []uuid.UUID{docs.ID, sub.ID} {
+		rec := s.Do(http.MethodPost, "/folders/"+other.ID.String()+"/move", aliceToken, models.MoveFolderRequest{ParentID: dest.String()})
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("moving a folder below itself gave %d, want 400", rec.Code)
+		}
+	}
+	if after := s.Storage.Keys(); len(after) != 1 || after[0] != keys[0] {
+		t.Errorf("storage holds %v after the moves, want %v", after, keys)
+	}
+}
+
+// the database keeps names unique even when the checks of the handlers were
+// passed with names that are taken by the time the change is saved
+func TestNamesAreUnique(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	home := s.HomeFolder(alice)
+	docs := createFolder(t, s, aliceToken, home.ID, "docs")
+	other := createFolder(t, s, aliceToken, home.ID, "other")
+	upload(t, s, aliceToken, home.ID, "notes.txt", "hello")
+	moved := upload(t, s, aliceToken, other.ID, "notes.txt", "hello")
+
+	err := s.DB.CreateFolder(&models.Folder{Name: "docs", ParentID: home.ID, WorkspaceID: home.WorkspaceID})
+	if !errors.Is(err, db.ErrNameTaken) {
+		t.Errorf("creating a second docs folder gave %v, want ErrNameTaken", err)
+	}
+
+	// a file moved next to a file with its name
+	moved.FolderID = home.ID
+	moved.Path = "home@alice/notes.txt"
+	if err := s.DB.UpdateLayout(nil, []models.File{moved}, nil); !errors.Is(err, db.ErrNameTaken) {
+		t.Errorf("moving a file next to one with its name gave %v, want ErrNameTaken", err)
+	}
+	// a folder renamed to the name of a file
+	docs.Name = "notes.txt"
+	docs.Path = "home@alice/notes.txt"
+	if err := s.DB.UpdateLayout([]models.Folder{docs}, nil, nil); !errors.Is(err, db.ErrNameTaken) {
+		t.Errorf("renaming a folder to the name of a file gave %v, want ErrNameTaken", err)
+	}
+
+	rec := s.Do(http.MethodGet, "/get-directory?folder_id="+home.ID.String(), aliceToken, nil)
+	if rec.Code != http.StatusOK || strings.Count(rec.Body.String(), `"name":"notes.txt"`) != 1 || !strings.Contains(rec.Body.String(), `"name":"docs"`) {
+		t.Errorf("home holds %s", rec.Body.String())
+	}
+}
+
+func TestMoveFolderConcurrently(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	home := s.HomeFolder(alice)
+	a := createFolder(t, s, aliceToken, home.ID, "a")
+	b := createFolder(t, s, aliceToken, home.ID, "b")
+
+	// both trees are read before either move is saved, like two requests at once
+	aFolders, aFiles, err := s.DB.GetFolderTree(a.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bFolders, bFiles, err := s.DB.GetFolderTree(b.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	moveUnder := func(folders []models.Folder, files []models.File, parent models.Folder) error {
+		folders[0].ParentID = parent.ID
+		folders[0].Path = parent.Path + "/" + folders[0].Name
+		return s.DB.UpdateLayout(folders, files, nil)
+	}
+	if err := moveUnder(aFolders, aFiles, b); err != nil {
+		t.Fatalf("moving a into b: %v", err)
+	}
+	if err := moveUnder(bFolders, bFiles, a); !errors.Is(err, db.ErrTreeChanged) {
+		t.Errorf("moving b into a afterwards gave %v, want ErrTreeChanged", err)
+	}
+
+	// a file uploaded while its folder is renamed keeps the folder from being saved
+	cFolders, cFiles, err := s.DB.GetFolderTree(b.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload(t, s, aliceToken, b.ID, "late.txt", "late")
+	cFolders[0].Name = "c"
+	if err := moveUnder(cFolders, cFiles, home); !errors.Is(err, db.ErrTreeChanged) {
+		t.Errorf("renaming with a stale tree gave %v, want ErrTreeChanged", err)
+	}
+}
+
+func TestUploadFileVersion(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	home := s.HomeFolder(alice)
+	first := upload(t, s, aliceToken, home.ID, "notes.txt", "one")
+	second := upload(t, s, aliceToken, home.ID, "notes.txt", "two")
+	if second.ID != first.ID || second.Version != 2 {
+		t.Fatalf("the second upload gave %+v", second)
+	}
+
+	rec := s.Do(http.MethodGet, "/files/"+first.ID.String()+"/versions/1/download", aliceToken, nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "one" {
+		t.Fatalf("version 1 gave %d %q", rec.Code, rec.Body.String())
+	}
+
+	// an upload that started from version 1 lost the race, the file keeps
+	// pointing at the content of the upload that won
+	stale := first
+	err := s.DB.AddFileVersion(&stale, &models.FileVersion{Size: 5, StorageKey: ".versions/stale"})
+	if !errors.Is(err, db.ErrFileChanged) {
+		t.Errorf("adding a version to a stale file gave %v, want ErrFileChanged", err)
+	}
+	for number, want := range map[string]string{"1": "one", "2": "two"} {
+		rec = s.Do(http.MethodGet, "/files/"+first.ID.String()+"/versions/"+number+"/download", aliceToken, nil)
+		if rec.Code != http.StatusOK || rec.Body.String() != want {
+			t.Errorf("version %s gave %d %q after the lost race", number, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestUploadFileVersionConcurrently(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	home := s.HomeFolder(alice)
+	file := upload(t, s, aliceToken, home.ID, "notes.txt", "base")
+
+	// every upload writes its own object, the ones that lose the race to save
+	// their version delete that object and nothing else
+	const uploads = 8
+	var wg sync.WaitGroup
+	codes := make([]int, uploads)
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			form := uploadForm(home.ID.String(), "notes.txt", fmt.Sprintf("upload %d", i), "0", "0")
+			codes[i] = s.Do(http.MethodPost, "/upload", aliceToken, form).Code
+		}(i)
+	}
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusOK && code != http.StatusConflict {
+			t.Errorf("upload %d gave %d", i, code)
+		}
+	}
+
+	stored, err := s.DB.GetFileByID(file.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions, err := s.DB.GetFileVersions(file.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != stored.Version {
+		t.Errorf("the file is at version %d with %d versions saved", stored.Version, len(versions))
+	}
+	if keys := s.Storage.Keys(); len(keys) != len(versions) {
+		t.Errorf("storage holds %d objects for %d versions: %v", len(keys), len(versions), keys)
+	}
+	for _, version := range versions {
+		if _, ok := s.Storage.Object(version.StorageKey); !ok {
+			t.Errorf("version %d has no content at %s", version.Version, version.StorageKey)
+		}
+	}
+	rec := s.Do(http.MethodGet, "/download?file_id="+file.ID.String(), aliceToken, nil)
+	current, _ := s.Storage.Object(stored.StorageKey)
+	if rec.Code != http.StatusOK || rec.Body.String() != string(current) {
+		t.Errorf("downloading gave %d %q, want %q", rec.Code, rec.Body.String(), current)
+	}
+}
+
+func TestPurgeNestedTrash(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	home := s.HomeFolder(alice)
+	docs := createFolder(t, s, aliceToken, home.ID, "docs")
+	sub := createFolder(t, s, aliceToken, docs.ID, "sub")
+	file := upload(t, s, aliceToken, docs.ID, "notes.txt", "hello")
+	upload(t, s, aliceToken, docs.ID, "notes.txt", "hello again")
+
+	// the file and the subfolder are trashed on their own before their folder
+	for _, path := range []string{"/files/" + file.ID.String(), "/folders/" + sub.ID.String(), "/folders/" + docs.ID.String()} {
+		if rec := s.Do(http.MethodDelete, path, aliceToken, nil); rec.Code != http.StatusOK {
+			t.Fatalf("deleting %s gave %d: %s", path, rec.Code, rec.Body.String())
+		}
+	}
+	trash := "/trash?workspace_id=" + home.WorkspaceID.String()
+	var items []models.TrashItem
+	testsupport.Decode(t, s.Do(http.MethodGet, trash, aliceToken, nil), &items)
+	if len(items) != 3 {
+		t.Fatalf("the trash holds %d items, want 3", len(items))
+	}
+
+	var docsItem models.TrashItem
+	for _, item := range items {
+		if item.ItemID == docs.ID {
+			docsItem = item
+		}
+	}
+	if rec := s.Do(http.MethodDelete, "/trash/"+docsItem.ID.String(), aliceToken, nil); rec.Code != http.StatusOK {
+		t.Fatalf("purging the folder gave %d: %s", rec.Code, rec.Body.String())
+	}
+	testsupport.Decode(t, s.Do(http.MethodGet, trash, aliceToken, nil), &items)
+	if len(items) != 0 {
+		t.Errorf("the trash still holds %+v", items)
+	}
+	if keys := s.Storage.Keys(); len(keys) != 0 {
+		t.Errorf("storage still holds %v", keys)
+	}
+}
+
+func TestShareDownloadLimit(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	file := upload(t, s, aliceToken, s.HomeFolder(alice).ID, "notes.txt", "hello world")
+	one := 1
+	rec := s.Do(http.MethodPost, "/shares", aliceToken, models.CreateShareLinkRequest{
+		ItemType:     models.ShareItemFile,
+		ItemID:       file.ID.String(),
+		MaxDownloads: &one,
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("creating the share gave %d: %s", rec.Code, rec.Body.String())
+	}
+	var share struct {
+		URL string `json:"url"`
+	}
+	testsupport.Decode(t, rec, &share)
+	download := share.URL + "/download"
+
+	// a download that is cut off after the first bytes
+	req := s.Request(http.MethodGet, download, "", nil)
+	req.Header.Set("Range", "bytes=0-5")
+	rec = s.Serve(req)
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "hello " {
+		t.Fatalf("the download gave %d %q", rec.Code, rec.Body.String())
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("the download set %d cookies, want 1", len(cookies))
+	}
+
+	// the client that started the download may resume it, once
+	ranged := func(rangeHeader string, withCookie bool) *httptest.ResponseRecorder {
+		req := s.Request(http.MethodGet, download, "", nil)
+		req.Header.Set("Range", rangeHeader)
+		if withCookie {
+			req.AddCookie(cookies[0])
+		}
+		return s.Serve(req)
+	}
+	if rec := ranged("bytes=6-", true); rec.Code != http.StatusPartialContent || rec.Body.String() != "world" {
+		t.Errorf("resuming gave %d %q", rec.Code, rec.Body.String())
+	}
+	for _, rangeHeader := range []string{"bytes=6-", "bytes=-999999999", "bytes=10-"} {
+		if rec := ranged(rangeHeader, true); rec.Code != http.StatusGone {
+			t.Errorf("%s after the whole file was sent gave %d, want 410", rangeHeader, rec.Code)
+		}
+	}
+
+	// anyone else gets nothing more, ranged or not
+	for _, rangeHeader := range []string{"bytes=1-", "bytes=-5"} {
+		if rec := ranged(rangeHeader, false); rec.Code != http.StatusGone {
+			t.Errorf("%s without the cookie gave %d, want 410", rangeHeader, rec.Code)
+		}
+	}
+	for _, path := range []string{download, share.URL} {
+		if rec := s.Do(http.MethodGet, path, "", nil); rec.Code != http.StatusGone {
+			t.Errorf("%s gave %d after the last download, want 410", path, rec.Code)
+		}
+	}
+	if rec := s.Do(http.MethodHead, download, "", nil); rec.Code != http.StatusOK {
+		t.Errorf("HEAD gave %d after the last download, want 200", rec.Code)
+	}
+}
+
+func TestShareDownloadBytes(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	home := s.HomeFolder(alice)
+	file := upload(t, s, aliceToken, home.ID, "notes.txt", "hello")
+	rec := s.Do(http.MethodPost, "/shares", aliceToken, models.CreateShareLinkRequest{ItemType: models.ShareItemFile, ItemID: file.ID.String()})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("creating the share gave %d: %s", rec.Code, rec.Body.String())
+	}
+	links, err := s.DB.GetShareLinks(home.WorkspaceID.String(), file.ID.String())
+	if err != nil || len(links) != 1 {
+		t.Fatalf("got share links %v (%v)", links, err)
+	}
+	link := links[0]
+
+	now := time.Now()
+	download := models.ShareDownload{ID: uuid.New(), ShareLinkID: link.ID, FileID: file.ID, Size: 5, Served: 3, ExpiresAt: *types.NewTimestamp(now.Add(time.Hour))}
+	if err := s.DB.StartShareDownload(&link, &download); err != nil || link.DownloadCount != 1 {
+		t.Fatalf("starting the download gave %v with %d downloads", err, link.DownloadCount)
+	}
+	reserve := func(length int64, at time.Time) error {
+		return s.DB.ReserveShareDownload(download.ID.String(), link.ID.String(), file.ID.String(), length, at)
+	}
+	if err := reserve(2, now); err != nil {
+		t.Errorf("taking the last 2 bytes gave %v", err)
+	}
+	if err := reserve(1, now); !errors.Is(err, db.ErrShareDownloadUsed) {
+		t.Errorf("taking a byte too many gave %v, want ErrShareDownloadUsed", err)
+	}
+	// bytes that were never sent can be asked for again
+	if err := s.DB.ReleaseShareDownload(download.ID.String(), 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := reserve(2, now); err != nil {
+		t.Errorf("taking released bytes gave %v", err)
+	}
+	if err := s.DB.ReleaseShareDownload(download.ID.String(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := reserve(1, now.Add(2*time.Hour)); !errors.Is(err, db.ErrShareDownloadUsed) {
+		t.Errorf("using an expired download gave %v, want ErrShareDownloadUsed", err)
+	}
+	if deleted, err := s.DB.DeleteExpiredShareDownloads(now.Add(2 * time.Hour)); err != nil || deleted != 1 {
+		t.Errorf("deleted %d expired downloads (%v), want 1", deleted, err)
+	}
+}
+
+func TestShareHidesPaths(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	home := s.HomeFolder(alice)
+	docs := createFolder(t, s, aliceToken, home.ID, "docs")
+	sub := createFolder(t, s, aliceToken, docs.ID, "sub")
+	file := upload(t, s, aliceToken, docs.ID, "notes.txt", "hello")
+
+	var urls []string
+	for _, item := range []struct {
+		itemType string
+		id       uuid.UUID
+	}{{models.ShareItemFile, file.ID}, {models.ShareItemFolder, docs.ID}} {
+		rec := s.Do(http.MethodPost, "/shares", aliceToken, models.CreateShareLinkRequest{ItemType: item.itemType, ItemID: item.id.String()})
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("creating the share gave %d: %s", rec.Code, rec.Body.String())
+		}
+		var share struct {
+			URL string `json:"url"`
+		}
+		testsupport.Decode(t, rec, &share)
+		urls = append(urls, share.URL)
+	}
+	urls = append(urls, urls[1]+"/folders/"+sub.ID.String())
+
+	for _, url := range urls {
+		rec := s.Do(http.MethodGet, url, "", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s gave %d: %s", url, rec.Code, rec.Body.String())
+		}
+		if body := rec.Body.String(); strings.Contains(body, "home@alice") || strings.Contains(body, `"path"`) {
+			t.Errorf("%s shows paths: %s", url, body)
+		}
+	}
+}
+
+func TestSharePassword(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	file := upload(t, s, aliceToken, s.HomeFolder(alice).ID, "notes.txt", "hello")
+	rec := s.Do(http.MethodPost, "/shares", aliceToken, models.CreateShareLinkRequest{
+		ItemType: models.ShareItemFile,
+		ItemID:   file.ID.String(),
+		Password: "secret",
+	})
+	var share struct {
+		URL string `json:"url"`
+	}
+	testsupport.Decode(t, rec, &share)
+
+	withPassword := func(method string, path string, password string) *http.Request {
+		req := s.Request(method, path, "", nil)
+		req.Header.Set("X-Share-Password", password)
+		return req
+	}
+	if rec := s.Do(http.MethodGet, share.URL+"?password=secret", "", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("the password in the URL gave %d, want 401", rec.Code)
+	}
+	if rec := s.Serve(withPassword(http.MethodGet, share.URL, "wrong")); rec.Code != http.StatusUnauthorized {
+		t.Errorf("a wrong password gave %d, want 401", rec.Code)
+	}
+	if rec := s.Serve(withPassword(http.MethodGet, share.URL, "secret")); rec.Code != http.StatusOK {
+		t.Errorf("the password header gave %d, want 200", rec.Code)
+	}
+
+	rec = s.Serve(withPassword(http.MethodPost, share.URL+"/unlock", "secret"))
+	if rec.Code != http.StatusNoContent || len(rec.Result().Cookies()) != 1 {
+		t.Fatalf("unlocking gave %d with cookies %v", rec.Code, rec.Result().Cookies())
+	}
+	req := s.Request(http.MethodGet, share.URL+"/download", "", nil)
+	req.AddCookie(rec.Result().Cookies()[0])
+	if rec := s.Serve(req); rec.Code != http.StatusOK || rec.Body.String() != "hello" {
+		t.Errorf("downloading with the unlock cookie gave %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestDownloadInline(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	home := s.HomeFolder(alice)
+
+	cases := []struct {
+		name        string
+		disposition string
+	}{
+		{"photo.png", "inline"},
+		{"clip.mp4", "inline"},
+		{"page.html", "attachment"},
+		{"drawing.svg", "attachment"},
+		{"data.bin", "attachment"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			file := upload(t, s, aliceToken, home.ID, tc.name, "<script>alert(1)</script>")
+			rec := s.Do(http.MethodGet, "/download?inline=true&file_id="+file.ID.String(), aliceToken, nil)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("download gave %d", rec.Code)
+			}
+			if disposition := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(disposition, tc.disposition+";") {
+				t.Errorf("served with %q, want %s", disposition, tc.disposition)
+			}
+			if nosniff := rec.Header().Get("X-Content-Type-Options"); nosniff != "nosniff" {
+				t.Errorf("X-Content-Type-Options is %q", nosniff)
+			}
+		})
+	}
+}
+
+func TestTrashFolderConcurrently(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	home := s.HomeFolder(alice)
+	docs := createFolder(t, s, aliceToken, home.ID, "docs")
+
+	// the tree is read, then a file arrives before the folder is trashed
+	folders, files, err := s.DB.GetFolderTree(docs.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload(t, s, aliceToken, docs.ID, "late.txt", "late")
+	item := models.TrashItem{ID: uuid.New(), WorkspaceID: docs.WorkspaceID, ItemType: models.TrashItemFolder, ItemID: docs.ID, OriginalParentID: home.ID}
+	if err := s.DB.TrashFolderTree(folders, files, &item); !errors.Is(err, db.ErrTreeChanged) {
+		t.Fatalf("trashing a stale tree gave %v, want ErrTreeChanged", err)
+	}
+
+	if rec := s.Do(http.MethodDelete, "/folders/"+docs.ID.String(), aliceToken, nil); rec.Code != http.StatusOK {
+		t.Fatalf("deleting gave %d: %s", rec.Code, rec.Body.String())
+	}
+	// nothing can be added to a folder once it is in the trash
+	late := models.Folder{Name: "later", ParentID: docs.ID, WorkspaceID: docs.WorkspaceID}
+	if err := s.DB.CreateFolder(&late); !errors.Is(err, db.ErrTreeChanged) {
+		t.Errorf("creating a folder in the trash gave %v, want ErrTreeChanged", err)
+	}
+}
+
+func TestTrashFileConcurrently(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	home := s.HomeFolder(alice)
+	docs := createFolder(t, s, aliceToken, home.ID, "docs")
+	file := upload(t, s, aliceToken, home.ID, "notes.txt", "hello")
+
+	// the file is read, then moved before it is trashed
+	stale, err := s.DB.GetFileByID(file.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := s.Do(http.MethodPost, "/files/"+file.ID.String()+"/move", aliceToken, models.MoveFileRequest{FolderID: docs.ID.String()}); rec.Code != http.StatusOK {
+		t.Fatalf("moving gave %d: %s", rec.Code, rec.Body.String())
+	}
+	item := models.TrashItem{ID: uuid.New(), WorkspaceID: home.WorkspaceID, ItemType: models.TrashItemFile, ItemID: file.ID, OriginalParentID: home.ID}
+	if err := s.DB.TrashFile(stale, &item); !errors.Is(err, db.ErrTreeChanged) {
+		t.Fatalf("trashing a moved file gave %v, want ErrTreeChanged", err)
+	}
+
+	if rec := s.Do(http.MethodDelete, "/files/"+file.ID.String(), aliceToken, nil); rec.Code != http.StatusOK {
+		t.Fatalf("deleting gave %d: %s", rec.Code, rec.Body.String())
+	}
+	// a file that is already in the trash cannot be trashed again
+	if trashed, err := s.DB.GetFileByID(file.ID.String()); err == nil {
+		t.Fatalf("the trashed file is still found: %+v", trashed)
+	}
+	stale.FolderID = docs.ID
+	again := models.TrashItem{ID: uuid.New(), WorkspaceID: home.WorkspaceID, ItemType: models.TrashItemFile, ItemID: file.ID, OriginalParentID: docs.ID}
+	if err := s.DB.TrashFile(stale, &again); !errors.Is(err, db.ErrTreeChanged) {
+		t.Errorf("trashing a trashed file gave %v, want ErrTreeChanged", err)
+	}
+}
+
+func TestRemoveMemberDisconnectsEvents(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	bob, bobToken := s.Register("bob")
+	carol, _ := s.Register("carol")
+	workspaceID := alice.Workspaces[0]
+	viewer, err := s.DB.GetRoleByName(models.RoleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []models.User{bob, carol} {
+		if err := s.DB.CreateCollaboration(&models.Collaborations{UserID: user.ID, RoleID: viewer.ID, WorkspaceID: workspaceID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bobEvents := s.Handler.Events.Subscribe(workspaceID, bob.ID)
+	carolEvents := s.Handler.Events.Subscribe(workspaceID, carol.ID)
+
+	workspace := "/workspaces/" + workspaceID.String()
+	if rec := s.Do(http.MethodPost, workspace+"/leave", bobToken, nil); rec.Code != http.StatusOK {
+		t.Fatalf("leaving gave %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := s.Do(http.MethodDelete, workspace+"/members/"+carol.ID.String(), aliceToken, nil); rec.Code != http.StatusOK {
+		t.Fatalf("removing gave %d: %s", rec.Code, rec.Body.String())
+	}
+	for name, sub := range map[string]*events.Subscriber{"bob": bobEvents, "carol": carolEvents} {
+		if _, open := <-sub.Messages(); open {
+			t.Errorf("%s still gets the events of the workspace", name)
+		}
+	}
+	if n := s.Handler.Events.Subscribers(workspaceID); n != 0 {
+		t.Errorf("the workspace has %d subscribers left", n)
+	}
+}
@@ -0,0 +1,460 @@
+package routes
+
+import (
+	"cascloud/db"
+	"cascloud/helpers"
+	"cascloud/models"
+	"cascloud/types"
+
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// Share links give people without an account access to one file or folder:
+//   - GET /s/:token describes the shared item, for a folder with its contents
+//   - GET /s/:token/folders/:id lists a folder inside a shared folder
+//   - GET /s/:token/download streams the shared file, or with ?file_id= a file
+//     inside a shared folder
+//
+// A password protected link needs the password in the X-Share-Password header
+// on every request. POST /s/:token/unlock with the header once instead sets a
+// cookie that stands in for the password for a while, for plain browser links.
+// The password is never taken from the URL where it would end up in logs.
+//
+// Every download of a file that starts is counted. Starting one sets a cookie
+// for the file so the ranged requests that resume or split it are not counted
+// again, until together they have been sent as many bytes as the file has. A
+// request without the cookie, or one that asks for more, is a new download.
+const sharePasswordHeader = "X-Share-Password"
+
+// how long a started download can be resumed without counting again
+const shareDownloadTTL = 24 * time.Hour
+
+// how long an unlocked link can be used without giving the password again
+const shareUnlockTTL = time.Hour
+
+const shareUnlockCookie = "share_unlock"
+const shareUnlockScope = "unlock"
+
+// a function to create a share link for a file or folder
+func (h *HandlerClient) CreateShareLink(c echo.Context) error {
+	var shareReq models.CreateShareLinkRequest
+	bindErr := c.Bind(&shareReq)
+	if bindErr != nil {
+		return bindErr
+	}
+	itemID, parseErr := uuid.Parse(shareReq.ItemID)
+	if parseErr != nil {
+		return c.JSON(400, "Invalid item ID")
+	}
+	if shareReq.ExpiresAt != nil && !shareReq.ExpiresAt.After(types.NowSource()) {
+		return c.JSON(400, "Expiry must be in the future")
+	}
+	if shareReq.MaxDownloads != nil && *shareReq.MaxDownloads <= 0 {
+		return c.JSON(400, "Max downloads must be greater than zero")
+	}
+
+	var workspaceID uuid.UUID
+	switch shareReq.ItemType {
+	case models.ShareItemFile:
+		file, err := h.DBClient.GetFileByID(itemID.String())
+		if err != nil {
+			log.Error().Err(err).Msg("Error getting file from database")
+			return c.JSON(404, "File not found")
+		}
+		folder, folderErr := h.DBClient.GetFolderByID(file.FolderID.String())
+		if folderErr != nil {
+			log.Error().Err(folderErr).Msg("Error getting folder from database")
+			return c.JSON(400, "Error getting folder from database")
+		}
+		workspaceID = folder.WorkspaceID
+	case models.ShareItemFolder:
+		folder, err := h.DBClient.GetFolderByID(itemID.String())
+		if err != nil {
+			log.Error().Err(err).Msg("Error getting folder from database")
+			return c.JSON(404, "Folder not found")
+		}
+		workspaceID = folder.WorkspaceID
+	default:
+		return c.JSON(400, "Item type must be file or folder")
+	}
+	if err := h.authorizeWorkspace(c, workspaceID, models.RoleEditor); err != nil {
+		return err
+	}
+
+	token, tokenHash, tokenErr := helpers.GenerateShareToken()
+	if tokenErr != nil {
+		log.Error().Err(tokenErr).Msg("Error generating share token")
+		return c.JSON(400, "Error generating share token")
+	}
+	link := models.ShareLink{
+		TokenHash:    tokenHash,
+		WorkspaceID:  workspaceID,
+		ItemType:     shareReq.ItemType,
+		ItemID:       itemID,
+		CreatedBy:    callerID(c),
+		ExpiresAt:    shareReq.ExpiresAt,
+		PasswordHash: shareReq.Password,
+		MaxDownloads: shareReq.MaxDownloads,
+	}
+	createErr := h.DBClient.CreateShareLink(&link)
+	if createErr != nil {
+		log.Error().Err(createErr).Msg("Error creating share link in database")
+		return c.JSON(400, "Error creating share link in database")
+	}
+
+	// the token is not stored, this is the only time it can be shown
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"share": link,
+		"token": token,
+		"url":   "/s/" + token,
+	})
+}
+
+// a function to list the share links of a workspace, or of one item in it
+func (h *HandlerClient) GetShareLinks(c echo.Context) error {
+	workspaceID := c.QueryParam("workspace_id")
+	if workspaceID == "" {
+		log.Error().Msg("Workspace ID not provided")
+		return c.JSON(400, "Workspace ID not provided")
+	}
+	if err := h.authorizeWorkspaceID(c, workspaceID, models.RoleEditor); err != nil {
+		return err
+	}
+	links, err := h.DBClient.GetShareLinks(workspaceID, c.QueryParam("item_id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting share links from database")
+		return c.JSON(400, "Error getting share links from database")
+	}
+	return c.JSON(200, links)
+}
+
+// a function to revoke a share link
+func (h *HandlerClient) DeleteShareLink(c echo.Context) error {
+	link, err := h.DBClient.GetShareLinkByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting share link from database")
+		return c.JSON(404, "Share link not found")
+	}
+	if err := h.authorizeWorkspace(c, link.WorkspaceID, models.RoleEditor); err != nil {
+		return err
+	}
+	deleteErr := h.DBClient.DeleteShareLink(link)
+	if deleteErr != nil {
+		log.Error().Err(deleteErr).Msg("Error deleting share link from database")
+		return c.JSON(400, "Error deleting share link from database")
+	}
+	return c.JSON(200, link)
+}
+
+// a function to describe a shared item, a shared folder comes with its contents
+func (h *HandlerClient) GetShare(c echo.Context) error {
+	link, err := h.openShare(c)
+	if err != nil {
+		return err
+	}
+	if err := downloadsLeft(link); err != nil {
+		return err
+	}
+	if link.ItemType == models.ShareItemFile {
+		file, fileErr := h.DBClient.GetFileByID(link.ItemID.String())
+		if fileErr != nil {
+			log.Error().Err(fileErr).Msg("Error getting file from database")
+			return c.JSON(404, "Shared file not found")
+		}
+		return c.JSON(200, map[string]interface{}{
+			"share": link,
+			"file":  newSharedFile(file),
+		})
+	}
+
+	folder, folderErr := h.DBClient.GetFolderByID(link.ItemID.String())
+	if folderErr != nil {
+		log.Error().Err(folderErr).Msg("Error getting folder from database")
+		return c.JSON(404, "Shared folder not found")
+	}
+	return h.sharedListing(c, link, folder)
+}
+
+// a function to list a folder inside a shared folder
+func (h *HandlerClient) GetSharedFolder(c echo.Context) error {
+	link, err := h.openShare(c)
+	if err != nil {
+		return err
+	}
+	if err := downloadsLeft(link); err != nil {
+		return err
+	}
+	if link.ItemType != models.ShareItemFolder {
+		return c.JSON(404, "Folder not found")
+	}
+	folders, _, treeErr := h.DBClient.GetFolderTree(link.ItemID.String())
+	if treeErr != nil {
+		log.Error().Err(treeErr).Msg("Error getting folder from database")
+		return c.JSON(404, "Shared folder not found")
+	}
+	for i := range folders {
+		if folders[i].ID.String() == c.Param("id") {
+			return h.sharedListing(c, link, &folders[i])
+		}
+	}
+	return c.JSON(404, "Folder not found")
+}
+
+// a function to download a shared file, or a file inside a shared folder
+func (h *HandlerClient) DownloadShare(c echo.Context) error {
+	link, err := h.openShare(c)
+	if err != nil {
+		return err
+	}
+
+	var file *models.File
+	if link.ItemType == models.ShareItemFile {
+		sharedFile, fileErr := h.DBClient.GetFileByID(link.ItemID.String())
+		if fileErr != nil {
+			log.Error().Err(fileErr).Msg("Error getting file from database")
+			return c.JSON(404, "Shared file not found")
+		}
+		file = sharedFile
+	} else {
+		_, files, treeErr := h.DBClient.GetFolderTree(link.ItemID.String())
+		if treeErr != nil {
+			log.Error().Err(treeErr).Msg("Error getting folder from database")
+			return c.JSON(404, "Shared folder not found")
+		}
+		for i := range files {
+			if files[i].ID.String() == c.QueryParam("file_id") {
+				file = &files[i]
+				break
+			}
+		}
+		if file == nil {
+			return c.JSON(404, "File not found")
+		}
+	}
+
+	return h.streamGatedObject(c, file.StorageKey, file.Name, h.shareDownloadGate(c, link, file))
+}
+
+func (h *HandlerClient) sharedListing(c echo.Context, link *models.ShareLink, folder *models.Folder) error {
+	folders, files, err := h.DBClient.GetFoldersAndFilesInFolder(folder.ID.String())
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting folder from database")
+		return c.JSON(400, "Error getting folder from database")
+	}
+	sharedFolders := make([]sharedFolder, 0, len(folders))
+	for i := range folders {
+		sharedFolders = append(sharedFolders, newSharedFolder(&folders[i]))
+	}
+	sharedFiles := make([]sharedFile, 0, len(files))
+	for i := range files {
+		sharedFiles = append(sharedFiles, newSharedFile(&files[i]))
+	}
+	return c.JSON(200, map[string]interface{}{
+		"share":   link,
+		"folder":  newSharedFolder(folder),
+		"folders": sharedFolders,
+		"files":   sharedFiles,
+	})
+}
+
+// sharedFolder and sharedFile are what people with a share link see of the
+// shared items. They leave out the paths, which start with the home folder of
+// the owner and name every folder above the shared one.
+type sharedFolder struct {
+	ID        uuid.UUID       `json:"id"`
+	Name      string          `json:"name"`
+	ParentID  uuid.UUID       `json:"parent_id"`
+	X         float64         `json:"x"`
+	Y         float64         `json:"y"`
+	CreatedAt types.Timestamp `json:"created_at"`
+}
+
+type sharedFile struct {
+	ID        uuid.UUID       `json:"id"`
+	Name      string          `json:"name"`
+	Size      int64           `json:"size"`
+	Version   int             `json:"version"`
+	FolderID  uuid.UUID       `json:"folder_id"`
+	X         float64         `json:"x"`
+	Y         float64         `json:"y"`
+	CreatedAt types.Timestamp `json:"created_at"`
+}
+
+func newSharedFolder(folder *models.Folder) sharedFolder {
+	return sharedFolder{
+		ID:        folder.ID,
+		Name:      folder.Name,
+		ParentID:  folder.ParentID,
+		X:         folder.X,
+		Y:         folder.Y,
+		CreatedAt: folder.CreatedAt,
+	}
+}
+
+func newSharedFile(file *models.File) sharedFile {
+	return sharedFile{
+		ID:        file.ID,
+		Name:      file.Name,
+		Size:      file.Size,
+		Version:   file.Version,
+		FolderID:  file.FolderID,
+		X:         file.X,
+		Y:         file.Y,
+		CreatedAt: file.CreatedAt,
+	}
+}
+
+// openShare loads the link of the :token parameter and checks that it is still
+// usable and that the right password was given
+func (h *HandlerClient) openShare(c echo.Context) (*models.ShareLink, error) {
+	link, err := h.DBClient.GetShareLinkByTokenHash(helpers.HashShareToken(c.Param("token")))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Share link not found")
+	}
+	if link.ExpiresAt.IsValid() && !link.ExpiresAt.After(types.NowSource()) {
+		return nil, echo.NewHTTPError(http.StatusGone, "This link has expired")
+	}
+	if link.PasswordProtected {
+		password := c.Request().Header.Get(sharePasswordHeader)
+		if password == "" {
+			if cookie, cookieErr := c.Cookie(shareUnlockCookie); cookieErr == nil && helpers.VerifyShareClaim(cookie.Value, link.ID, shareUnlockScope) {
+				return link, nil
+			}
+			return nil, echo.NewHTTPError(http.StatusUnauthorized, "This link needs a password")
+		}
+		if !helpers.ComparePasswords(link.PasswordHash, password) {
+			return nil, echo.NewHTTPError(http.StatusUnauthorized, "Incorrect password")
+		}
+	}
+	return link, nil
+}
+
+// a function to exchange the password of a share link for a cookie, so links
+// opened in a browser do not need the password header
+func (h *HandlerClient) UnlockShare(c echo.Context) error {
+	if c.Request().Header.Get(sharePasswordHeader) == "" {
+		return c.JSON(http.StatusUnauthorized, "This link needs a password")
+	}
+	link, err := h.openShare(c)
+	if err != nil {
+		return err
+	}
+	if link.PasswordProtected {
+		token, tokenErr := helpers.GenerateShareClaim(link.ID, shareUnlockScope, shareUnlockTTL)
+		if tokenErr != nil {
+			log.Error().Err(tokenErr).Msg("Error signing share unlock")
+			return c.JSON(400, "Error unlocking share link")
+		}
+		c.SetCookie(&http.Cookie{
+			Name:     shareUnlockCookie,
+			Value:    token,
+			Path:     "/s/" + c.Param("token"),
+			MaxAge:   int(shareUnlockTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   c.IsTLS(),
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// downloadsLeft refuses to describe a link that reached its download limit,
+// downloads check the limit themselves when they start
+func downloadsLeft(link *models.ShareLink) error {
+	if link.MaxDownloads != nil && link.DownloadCount >= *link.MaxDownloads {
+		return echo.NewHTTPError(http.StatusGone, "This link has reached its download limit")
+	}
+	return nil
+}
+
+// shareDownloadGate counts a download of file unless the request fits in a
+// download the client already started, then it only takes the bytes it sends
+// from that download. Bytes that could not be sent are given back so the
+// download can be resumed.
+func (h *HandlerClient) shareDownloadGate(c echo.Context, link *models.ShareLink, file *models.File) downloadGate {
+	return func(size int64, length int64) (func(sent int64), error) {
+		if cookie, err := c.Cookie(shareDownloadCookie(file.ID)); err == nil {
+			if _, parseErr := uuid.Parse(cookie.Value); parseErr == nil {
+				reserveErr := h.DBClient.ReserveShareDownload(cookie.Value, link.ID.String(), file.ID.String(), length, types.NowSource())
+				if reserveErr == nil {
+					return h.releaseShareDownload(cookie.Value, length), nil
+				}
+				if !errors.Is(reserveErr, db.ErrShareDownloadUsed) {
+					log.Error().Err(reserveErr).Msg("Error resuming share download in database")
+					return nil, echo.NewHTTPError(400, "Error counting share download in database")
+				}
+			}
+		}
+
+		download := models.ShareDownload{
+			ID:          uuid.New(),
+			ShareLinkID: link.ID,
+			FileID:      file.ID,
+			Size:        size,
+			Served:      length,
+			ExpiresAt:   *types.NewTimestamp(types.NowSource().Add(shareDownloadTTL)),
+		}
+		startErr := h.DBClient.StartShareDownload(link, &download)
+		if errors.Is(startErr, db.ErrShareLimitReached) {
+			return nil, echo.NewHTTPError(http.StatusGone, "This link has reached its download limit")
+		}
+		if startErr != nil {
+			log.Error().Err(startErr).Msg("Error counting share download in database")
+			return nil, echo.NewHTTPError(400, "Error counting share download in database")
+		}
+		c.SetCookie(&http.Cookie{
+			Name:     shareDownloadCookie(file.ID),
+			Value:    download.ID.String(),
+			Path:     "/s/" + c.Param("token"),
+			MaxAge:   int(shareDownloadTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   c.IsTLS(),
+			SameSite: http.SameSiteLaxMode,
+		})
+		return h.releaseShareDownload(download.ID.String(), length), nil
+	}
+}
+
+// releaseShareDownload gives back the reserved bytes of a download that were
+// not sent
+func (h *HandlerClient) releaseShareDownload(id string, length int64) func(sent int64) {
+	return func(sent int64) {
+		if sent >= length {
+			return
+		}
+		if err := h.DBClient.ReleaseShareDownload(id, length-sent); err != nil {
+			log.Error().Err(err).Msg("Error releasing share download in database")
+		}
+	}
+}
+
+func shareDownloadCookie(fileID uuid.UUID) string {
+	return "share_download_" + fileID.String()
+}
+
+// a function that deletes expired share downloads every interval until the
+// context is cancelled
+func (h *HandlerClient) StartShareDownloadPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		deleted, err := h.DBClient.DeleteExpiredShareDownloads(types.NowSource())
+		if err != nil {
+			log.Error().Err(err).Msg("Error deleting expired share downloads from database")
+		} else if deleted > 0 {
+			log.Info().Int64("downloads", deleted).Msg("Deleted expired share downloads")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
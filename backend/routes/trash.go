@@ -0,0 +1,330 @@
+package routes
+
+import (
+	"cascloud/db"
+	"cascloud/events"
+	"cascloud/models"
+	"cascloud/types"
+
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// a function to move a file to the trash
+func (h *HandlerClient) DeleteFile(c echo.Context) error {
+	file, err := h.DBClient.GetFileByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting file from database")
+		return c.JSON(404, "File not found")
+	}
+	folder, folderErr := h.DBClient.GetFolderByID(file.FolderID.String())
+	if folderErr != nil {
+		log.Error().Err(folderErr).Msg("Error getting folder from database")
+		return c.JSON(400, "Error getting folder from database")
+	}
+	if err := h.authorizeFolder(c, folder, models.RoleEditor); err != nil {
+		return err
+	}
+
+	// the content stays where it is until the trash item is purged
+	item := newTrashItem(c, folder.WorkspaceID, models.TrashItemFile, file.ID, file.Name, file.Path, file.FolderID)
+	trashErr := h.DBClient.TrashFile(file, &item)
+	if errors.Is(trashErr, db.ErrTreeChanged) {
+		return c.JSON(http.StatusConflict, "The file was changed by someone else, try again")
+	}
+	if trashErr != nil {
+		log.Error().Err(trashErr).Msg("Error moving file to the trash in database")
+		return c.JSON(400, "Error moving file to the trash in database")
+	}
+
+	h.publish(c, events.FileDeleted, folder.WorkspaceID, folder.ID, file)
+	return c.JSON(200, item)
+}
+
+// a function to move a folder with everything inside it to the trash
+func (h *HandlerClient) DeleteFolder(c echo.Context) error {
+	folders, files, err := h.DBClient.GetFolderTree(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting folder from database")
+		return c.JSON(404, "Folder not found")
+	}
+	root := folders[0]
+	if err := h.authorizeFolder(c, &root, models.RoleEditor); err != nil {
+		return err
+	}
+	if root.ParentID == uuid.Nil {
+		return c.JSON(400, "The home folder cannot be deleted")
+	}
+
+	item := newTrashItem(c, root.WorkspaceID, models.TrashItemFolder, root.ID, root.Name, root.Path, root.ParentID)
+	trashErr := h.DBClient.TrashFolderTree(folders, files, &item)
+	if errors.Is(trashErr, db.ErrTreeChanged) {
+		return c.JSON(http.StatusConflict, "The folder was changed by someone else, try again")
+	}
+	if trashErr != nil {
+		log.Error().Err(trashErr).Msg("Error moving folder to the trash in database")
+		return c.JSON(400, "Error moving folder to the trash in database")
+	}
+
+	h.publish(c, events.FolderDeleted, root.WorkspaceID, root.ParentID, root)
+	return c.JSON(200, item)
+}
+
+// a function to list the trash of a workspace
+func (h *HandlerClient) GetTrash(c echo.Context) error {
+	workspaceID := c.QueryParam("workspace_id")
+	if workspaceID == "" {
+		log.Error().Msg("Workspace ID not provided")
+		return c.JSON(400, "Workspace ID not provided")
+	}
+	if err := h.authorizeWorkspaceID(c, workspaceID, models.RoleViewer); err != nil {
+		return err
+	}
+	items, err := h.DBClient.GetTrashItems(workspaceID)
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting trash from database")
+		return c.JSON(400, "Error getting trash from database")
+	}
+	return c.JSON(200, items)
+}
+
+// a function to restore a trash item into its original folder, or into the
+// home folder of the workspace when the original folder is gone
+func (h *HandlerClient) RestoreTrashItem(c echo.Context) error {
+	item, err := h.DBClient.GetTrashItemByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting trash item from database")
+		return c.JSON(404, "Trash item not found")
+	}
+	if err := h.authorizeWorkspace(c, item.WorkspaceID, models.RoleEditor); err != nil {
+		return err
+	}
+	folders, files, treeErr := h.DBClient.GetTrashedTree(item)
+	if treeErr != nil {
+		log.Error().Err(treeErr).Msg("Error getting trash item from database")
+		return c.JSON(400, "Error getting trash item from database")
+	}
+
+	parent, parentErr := h.restoreDestination(item)
+	if parentErr != nil {
+		log.Error().Err(parentErr).Msg("Error getting restore folder from database")
+		return c.JSON(400, "Error getting restore folder from database")
+	}
+	if err := h.authorizeFolder(c, parent, models.RoleEditor); err != nil {
+		return err
+	}
+	taken, takenErr := h.takenNames(parent.ID.String(), uuid.Nil)
+	if takenErr != nil {
+		log.Error().Err(takenErr).Msg("Error getting folder contents from database")
+		return c.JSON(400, "Error getting folder contents from database")
+	}
+	name := uniqueName(item.Name, taken)
+
+	switch item.ItemType {
+	case models.TrashItemFolder:
+		if len(folders) == 0 {
+			return c.JSON(400, "Trash item is empty")
+		}
+		relocateTree(folders, files, parent, name)
+		folders[0].ParentID = parent.ID
+	case models.TrashItemFile:
+		if len(files) != 1 {
+			return c.JSON(400, "Trash item is empty")
+		}
+		base := path.Base(files[0].Path)
+		if name != files[0].Name {
+			base = name
+		}
+		files[0].Name = name
+		files[0].FolderID = parent.ID
+		files[0].Path = fmt.Sprintf("%s/%s", parent.Path, base)
+	}
+	restoreErr := h.DBClient.RestoreTrashItem(item, folders, files)
+	if errors.Is(restoreErr, db.ErrNameTaken) {
+		return c.JSON(http.StatusConflict, "The name was taken by someone else, try again")
+	}
+	if restoreErr != nil {
+		log.Error().Err(restoreErr).Msg("Error restoring trash item in database")
+		return c.JSON(400, "Error restoring trash item in database")
+	}
+
+	if item.ItemType == models.TrashItemFolder {
+		h.publish(c, events.FolderRestored, parent.WorkspaceID, parent.ID, folders[0])
+	} else {
+		h.publish(c, events.FileRestored, parent.WorkspaceID, parent.ID, files[0])
+	}
+
+	return c.JSON(200, map[string]interface{}{
+		"folders": folders,
+		"files":   files,
+	})
+}
+
+// a function to delete a single trash item for good
+func (h *HandlerClient) PurgeTrashItem(c echo.Context) error {
+	item, err := h.DBClient.GetTrashItemByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting trash item from database")
+		return c.JSON(404, "Trash item not found")
+	}
+	if err := h.authorizeWorkspace(c, item.WorkspaceID, models.RoleOwner); err != nil {
+		return err
+	}
+	if purgeErr := h.purgeTrashItem(c.Request().Context(), item); purgeErr != nil {
+		return c.JSON(400, "Error purging trash item")
+	}
+	return c.JSON(200, item)
+}
+
+// a function to empty the trash of a workspace
+func (h *HandlerClient) EmptyTrash(c echo.Context) error {
+	workspaceID := c.QueryParam("workspace_id")
+	if workspaceID == "" {
+		log.Error().Msg("Workspace ID not provided")
+		return c.JSON(400, "Workspace ID not provided")
+	}
+	if err := h.authorizeWorkspaceID(c, workspaceID, models.RoleOwner); err != nil {
+		return err
+	}
+	items, err := h.DBClient.GetTrashItems(workspaceID)
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting trash from database")
+		return c.JSON(400, "Error getting trash from database")
+	}
+
+	purged := 0
+	failed := []models.TrashItem{}
+	for i := range items {
+		if purgeErr := h.purgeTrashItem(c.Request().Context(), &items[i]); purgeErr != nil {
+			failed = append(failed, items[i])
+			continue
+		}
+		purged++
+	}
+
+	return c.JSON(200, map[string]interface{}{
+		"purged": purged,
+		"failed": failed,
+	})
+}
+
+// a function that purges trash items older than the retention period every
+// interval until the context is cancelled
+func (h *HandlerClient) StartTrashPurger(ctx context.Context, retention time.Duration, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		h.PurgeExpiredTrash(ctx, retention)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// a function to purge every trash item older than the retention period
+func (h *HandlerClient) PurgeExpiredTrash(ctx context.Context, retention time.Duration) {
+	items, err := h.DBClient.GetExpiredTrashItems(types.NowSource().Add(-retention))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting expired trash items from database")
+		return
+	}
+	for i := range items {
+		if ctx.Err() != nil {
+			return
+		}
+		// failures are logged and retried on the next run
+		h.purgeTrashItem(ctx, &items[i])
+	}
+	if len(items) > 0 {
+		log.Info().Int("items", len(items)).Msg("Purged expired trash")
+	}
+}
+
+// purgeTrashItem deletes the objects of a trash item, every version of its
+// files included, first and only removes the rows once every object is gone,
+// so a failed purge can simply be retried
+func (h *HandlerClient) purgeTrashItem(ctx context.Context, item *models.TrashItem) error {
+	folders, files, err := h.DBClient.GetTrashedTree(item)
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting trash item from database")
+		return err
+	}
+	// items trashed from inside these folders before them still point at them,
+	// they go first so no row is left behind without its folder
+	nested, nestedErr := h.DBClient.GetNestedTrashItems(item, folders)
+	if nestedErr != nil {
+		log.Error().Err(nestedErr).Msg("Error getting trash items from database")
+		return nestedErr
+	}
+	for i := range nested {
+		if purgeErr := h.purgeTrashItem(ctx, &nested[i]); purgeErr != nil {
+			return purgeErr
+		}
+	}
+	// files uploaded before versioning only have their current content
+	seen := make(map[string]bool, len(files))
+	keys := make([]string, 0, len(files))
+	fileIDs := make([]string, 0, len(files))
+	for _, file := range files {
+		seen[file.StorageKey] = true
+		keys = append(keys, file.StorageKey)
+		fileIDs = append(fileIDs, file.ID.String())
+	}
+	versionKeys, versionsErr := h.DBClient.GetVersionKeys(fileIDs)
+	if versionsErr != nil {
+		log.Error().Err(versionsErr).Msg("Error getting file versions from database")
+		return versionsErr
+	}
+	for _, key := range versionKeys {
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	if failed := h.deleteObjects(ctx, keys); len(failed) > 0 {
+		return fmt.Errorf("could not delete %d objects of trash item %s", len(failed), item.ID)
+	}
+	if purgeErr := h.DBClient.PurgeTrashItem(item, folders, files); purgeErr != nil {
+		log.Error().Err(purgeErr).Msg("Error purging trash item from database")
+		return purgeErr
+	}
+	return nil
+}
+
+// restoreDestination is the original parent of a trash item if it still
+// exists, otherwise the home folder of its workspace
+func (h *HandlerClient) restoreDestination(item *models.TrashItem) (*models.Folder, error) {
+	parent, err := h.DBClient.GetFolderByID(item.OriginalParentID.String())
+	if err == nil {
+		return parent, nil
+	}
+	workspace, workspaceErr := h.DBClient.GetWorkspaceByID(item.WorkspaceID.String())
+	if workspaceErr != nil {
+		return nil, workspaceErr
+	}
+	return h.DBClient.GetFolderByID(workspace.HomeFolderID.String())
+}
+
+func newTrashItem(c echo.Context, workspaceID uuid.UUID, itemType string, itemID uuid.UUID, name string, itemPath string, parentID uuid.UUID) models.TrashItem {
+	return models.TrashItem{
+		ID:               uuid.New(),
+		WorkspaceID:      workspaceID,
+		ItemType:         itemType,
+		ItemID:           itemID,
+		Name:             name,
+		Path:             itemPath,
+		OriginalParentID: parentID,
+		DeletedBy:        callerID(c),
+		DeletedAt:        *types.NowTimestamp(),
+	}
+}
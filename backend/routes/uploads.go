@@ -0,0 +1,453 @@
+package routes
+
+import (
+	"cascloud/db"
+	"cascloud/models"
+	"cascloud/storage"
+	"cascloud/types"
+
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// Resumable uploads work in three steps:
+//   - POST /uploads creates a session for a file of a known size
+//   - PATCH /uploads/:id appends the next chunk, the Upload-Offset header must
+//     match the confirmed offset of the session
+//   - POST /uploads/:id/complete turns the session into a file
+//
+// GET /uploads/:id returns the confirmed offset so a client can resume after
+// a dropped connection, DELETE /uploads/:id abandons the upload. A completion
+// that fails can be retried, uploads nobody touches for a while are expired.
+const uploadOffsetHeader = "Upload-Offset"
+
+// the parts of every upload are assembled under this prefix
+const uploadsPrefix = ".uploads"
+
+// how long a request may take to send its chunk to storage before another
+// request may send one at the same offset
+const uploadAppendLease = time.Hour
+
+// how long a completion may run before a retry can take it over
+const uploadCompleteTimeout = 15 * time.Minute
+
+// a function to start a resumable upload
+func (h *HandlerClient) CreateUpload(c echo.Context) error {
+	var uploadReq models.CreateUploadRequest
+	bindErr := c.Bind(&uploadReq)
+	if bindErr != nil {
+		return bindErr
+	}
+	if uploadReq.Size <= 0 {
+		return c.JSON(400, "Upload size must be greater than zero")
+	}
+	if uploadReq.Size > storage.MaxParts*storage.MaxPartSize {
+		return c.JSON(400, fmt.Sprintf("Upload size can be at most %d bytes", int64(storage.MaxParts*storage.MaxPartSize)))
+	}
+	if !validFileName(uploadReq.Name) {
+		return c.JSON(400, "Invalid file name")
+	}
+	if uploadReq.FolderID == "" {
+		log.Error().Msg("Folder ID not provided")
+		return c.JSON(400, "Folder ID not provided")
+	}
+
+	folder, folderErr := h.DBClient.GetFolderByID(uploadReq.FolderID)
+	if folderErr != nil {
+		log.Error().Err(folderErr).Msg("Error getting folder from database")
+		return c.JSON(400, "Error getting folder from database")
+	}
+	if err := h.authorizeFolder(c, folder, models.RoleEditor); err != nil {
+		return err
+	}
+	// a file with the name gets a new version, a folder with it can not
+	taken, takenErr := h.folderTakesName(folder, uploadReq.Name)
+	if takenErr != nil {
+		log.Error().Err(takenErr).Msg("Error getting folder contents from database")
+		return c.JSON(400, "Error getting folder contents from database")
+	}
+	if taken {
+		return c.JSON(http.StatusConflict, "A folder with this name already exists in the folder")
+	}
+
+	// the parts are assembled under a key of the session, the folder may be
+	// renamed or get another file with the name before the upload completes
+	sessionID := uuid.New()
+	key := fmt.Sprintf("%s/%s", uploadsPrefix, sessionID)
+	storageUploadID, createErr := h.S3Client.CreateMultipartUpload(c.Request().Context(), key)
+	if createErr != nil {
+		log.Error().Err(createErr).Msg("Error creating multipart upload")
+		return c.JSON(400, "Error creating multipart upload")
+	}
+
+	session := models.UploadSession{
+		ID:              sessionID,
+		FolderID:        folder.ID,
+		Name:            uploadReq.Name,
+		Path:            fmt.Sprintf("%s/%s", folder.Path, uploadReq.Name),
+		Size:            uploadReq.Size,
+		X:               uploadReq.X,
+		Y:               uploadReq.Y,
+		StorageUploadID: storageUploadID,
+		StorageKey:      key,
+		NextPart:        1,
+		Status:          models.UploadStatusPending,
+	}
+	sessionErr := h.DBClient.CreateUploadSession(&session)
+	if sessionErr != nil {
+		log.Error().Err(sessionErr).Msg("Error creating upload session in database")
+		h.S3Client.AbortMultipartUpload(c.Request().Context(), key, storageUploadID)
+		return c.JSON(400, "Error creating upload session in database")
+	}
+
+	c.Response().Header().Set(uploadOffsetHeader, "0")
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"upload":         session,
+		"min_chunk_size": storage.MinPartSize,
+		"max_chunk_size": storage.MaxPartSize,
+	})
+}
+
+// a function to get the state of a resumable upload
+func (h *HandlerClient) GetUpload(c echo.Context) error {
+	session, err := h.DBClient.GetUploadSessionByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting upload session from database")
+		return c.JSON(404, "Upload not found")
+	}
+	if err := h.authorizeFolderID(c, session.FolderID.String(), models.RoleEditor); err != nil {
+		return err
+	}
+	c.Response().Header().Set(uploadOffsetHeader, strconv.FormatInt(session.Offset, 10))
+	return c.JSON(200, session)
+}
+
+// a function to append a chunk to a resumable upload, every chunk becomes
+// one part of the multipart upload in storage. The offset and the part number
+// are reserved before anything is sent to storage, so of two requests for the
+// same offset one gets a 409 and the other's part is never overwritten.
+func (h *HandlerClient) AppendUpload(c echo.Context) error {
+	session, err := h.DBClient.GetUploadSessionByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting upload session from database")
+		return c.JSON(404, "Upload not found")
+	}
+	if err := h.authorizeFolderID(c, session.FolderID.String(), models.RoleEditor); err != nil {
+		return err
+	}
+	if session.Status != models.UploadStatusPending {
+		return c.JSON(409, fmt.Sprintf("Upload is %s", session.Status))
+	}
+
+	offset, offsetErr := strconv.ParseInt(c.Request().Header.Get(uploadOffsetHeader), 10, 64)
+	if offsetErr != nil {
+		return c.JSON(400, "Upload-Offset header not provided")
+	}
+	c.Response().Header().Set(uploadOffsetHeader, strconv.FormatInt(session.Offset, 10))
+	if offset != session.Offset {
+		return c.JSON(409, "Upload-Offset does not match the confirmed offset")
+	}
+
+	chunkSize := c.Request().ContentLength
+	if chunkSize <= 0 {
+		return c.JSON(400, "Content-Length must be provided")
+	}
+	if session.Offset+chunkSize > session.Size {
+		return c.JSON(400, "Chunk exceeds the upload size")
+	}
+	if session.Offset+chunkSize < session.Size && chunkSize < storage.MinPartSize {
+		return c.JSON(400, fmt.Sprintf("Only the last chunk may be smaller than %d bytes", storage.MinPartSize))
+	}
+	if chunkSize > storage.MaxPartSize {
+		return c.JSON(400, fmt.Sprintf("A chunk can be at most %d bytes", int64(storage.MaxPartSize)))
+	}
+	if session.NextPart > storage.MaxParts || (session.NextPart == storage.MaxParts && session.Offset+chunkSize < session.Size) {
+		return c.JSON(400, fmt.Sprintf("An upload can have at most %d chunks", storage.MaxParts))
+	}
+
+	// the checksum is built chunk by chunk, its state is saved with the offset
+	hash, hashErr := restoreHash(session.HashState)
+	if hashErr != nil {
+		log.Error().Err(hashErr).Msg("Error restoring upload checksum")
+		return c.JSON(400, "Error restoring upload checksum")
+	}
+
+	lease := uuid.NewString()
+	now := types.NowSource()
+	partNumber, reserveErr := h.DBClient.ReserveUploadPart(session, offset, lease, now.Add(uploadAppendLease), now)
+	if errors.Is(reserveErr, db.ErrUploadOffsetConflict) {
+		return c.JSON(409, "Another chunk is being written at this offset")
+	}
+	if reserveErr != nil {
+		log.Error().Err(reserveErr).Msg("Error reserving upload part in database")
+		return c.JSON(400, "Error reserving upload part in database")
+	}
+	release := func() {
+		if err := h.DBClient.ReleaseUploadPart(session, lease); err != nil {
+			log.Error().Err(err).Msg("Error releasing upload part in database")
+		}
+	}
+
+	body := io.TeeReader(c.Request().Body, hash)
+	etag, uploadErr := h.S3Client.UploadPart(c.Request().Context(), session.StorageKey, session.StorageUploadID, partNumber, body, chunkSize)
+	if uploadErr != nil {
+		log.Error().Err(uploadErr).Msg("Error uploading part to s3")
+		release()
+		return c.JSON(400, "Error uploading part to s3")
+	}
+	hashState, stateErr := hash.(encoding.BinaryMarshaler).MarshalBinary()
+	if stateErr != nil {
+		log.Error().Err(stateErr).Msg("Error saving upload checksum")
+		release()
+		return c.JSON(400, "Error saving upload checksum")
+	}
+	session.HashState = hashState
+
+	part := models.UploadPart{
+		PartNumber: partNumber,
+		ETag:       etag,
+		Size:       chunkSize,
+	}
+	addErr := h.DBClient.AddUploadPart(session, &part)
+	if errors.Is(addErr, db.ErrUploadOffsetConflict) {
+		return c.JSON(409, "Upload-Offset does not match the confirmed offset")
+	}
+	if addErr != nil {
+		log.Error().Err(addErr).Msg("Error saving upload part in database")
+		release()
+		return c.JSON(400, "Error saving upload part in database")
+	}
+
+	c.Response().Header().Set(uploadOffsetHeader, strconv.FormatInt(session.Offset, 10))
+	return c.JSON(200, session)
+}
+
+// a function to finish a resumable upload once every byte was received. Only
+// one request completes an upload at a time, when it fails the upload stays
+// pending and the request can be retried.
+func (h *HandlerClient) CompleteUpload(c echo.Context) error {
+	session, err := h.DBClient.GetUploadSessionByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting upload session from database")
+		return c.JSON(404, "Upload not found")
+	}
+	if err := h.authorizeFolderID(c, session.FolderID.String(), models.RoleEditor); err != nil {
+		return err
+	}
+	if session.Offset != session.Size {
+		c.Response().Header().Set(uploadOffsetHeader, strconv.FormatInt(session.Offset, 10))
+		return c.JSON(409, "Upload is not finished yet")
+	}
+	claimErr := h.DBClient.ClaimUploadCompletion(session, types.NowSource().Add(-uploadCompleteTimeout))
+	if errors.Is(claimErr, db.ErrUploadStatusChanged) {
+		if current, currentErr := h.DBClient.GetUploadSessionByID(session.ID.String()); currentErr == nil && current.Status != models.UploadStatusPending {
+			return c.JSON(409, fmt.Sprintf("Upload is %s", current.Status))
+		}
+		return c.JSON(409, "A chunk of the upload is still being written")
+	}
+	if claimErr != nil {
+		log.Error().Err(claimErr).Msg("Error updating upload session in database")
+		return c.JSON(400, "Error updating upload session in database")
+	}
+
+	fileModel, folder, completeErr := h.completeUpload(c, session)
+	if completeErr != nil {
+		// the parts or the assembled object are still there for a retry
+		if statusErr := h.DBClient.UpdateUploadSessionStatus(session, models.UploadStatusCompleting, models.UploadStatusPending); statusErr != nil {
+			log.Error().Err(statusErr).Msg("Error updating upload session in database")
+		}
+		if errors.Is(completeErr, db.ErrFileChanged) {
+			return c.JSON(http.StatusConflict, "The file was changed by someone else, try again")
+		}
+		if errors.Is(completeErr, db.ErrNameTaken) {
+			return c.JSON(http.StatusConflict, "A folder with this name already exists in the folder")
+		}
+		return c.JSON(400, "Error completing upload")
+	}
+
+	statusErr := h.DBClient.UpdateUploadSessionStatus(session, models.UploadStatusCompleting, models.UploadStatusCompleted)
+	if statusErr != nil {
+		log.Error().Err(statusErr).Msg("Error updating upload session in database")
+	}
+	// the file has a copy of its own, the expiry job deletes what is left
+	// when this fails
+	h.deleteObjects(context.WithoutCancel(c.Request().Context()), []string{session.StorageKey})
+
+	h.publish(c, commitEvent(fileModel), folder.WorkspaceID, folder.ID, fileModel)
+	return c.JSON(200, fileModel)
+}
+
+// completeUpload assembles the parts of an upload and commits the result to
+// its folder. The assembled object is copied to the file and kept, so a retry
+// after a failed commit does not need the parts any more.
+func (h *HandlerClient) completeUpload(c echo.Context, session *models.UploadSession) (*models.File, *models.Folder, error) {
+	parts, partsErr := h.DBClient.GetUploadParts(session.ID.String())
+	if partsErr != nil {
+		log.Error().Err(partsErr).Msg("Error getting upload parts from database")
+		return nil, nil, partsErr
+	}
+	completed := make([]storage.CompletedPart, 0, len(parts))
+	for _, part := range parts {
+		completed = append(completed, storage.CompletedPart{PartNumber: part.PartNumber, ETag: part.ETag})
+	}
+
+	hash, hashErr := restoreHash(session.HashState)
+	if hashErr != nil {
+		log.Error().Err(hashErr).Msg("Error restoring upload checksum")
+		return nil, nil, hashErr
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+
+	folder, folderErr := h.DBClient.GetFolderByID(session.FolderID.String())
+	if folderErr != nil {
+		log.Error().Err(folderErr).Msg("Error getting folder from database")
+		return nil, nil, folderErr
+	}
+
+	// a file with the same name gets a new version
+	fileModel, commitErr := h.commitFile(c.Request().Context(), folder, session.Name, session.X, session.Y, callerID(c), func(ctx context.Context, key string) (int64, string, error) {
+		// an earlier attempt may have assembled the parts already
+		if _, statErr := h.S3Client.StatFile(ctx, session.StorageKey); statErr != nil {
+			completeErr := h.S3Client.CompleteMultipartUpload(ctx, session.StorageKey, session.StorageUploadID, completed)
+			if completeErr != nil {
+				return 0, "", completeErr
+			}
+		}
+		if copyErr := h.S3Client.CopyFile(ctx, session.StorageKey, key); copyErr != nil {
+			return 0, "", copyErr
+		}
+		return session.Size, checksum, nil
+	})
+	if commitErr != nil {
+		return nil, nil, commitErr
+	}
+	return fileModel, folder, nil
+}
+
+// a function to abandon a resumable upload and free the uploaded parts
+func (h *HandlerClient) AbortUpload(c echo.Context) error {
+	session, err := h.DBClient.GetUploadSessionByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting upload session from database")
+		return c.JSON(404, "Upload not found")
+	}
+	if err := h.authorizeFolderID(c, session.FolderID.String(), models.RoleEditor); err != nil {
+		return err
+	}
+	statusErr := h.DBClient.UpdateUploadSessionStatus(session, models.UploadStatusPending, models.UploadStatusAborted)
+	if errors.Is(statusErr, db.ErrUploadStatusChanged) {
+		return c.JSON(409, fmt.Sprintf("Upload is %s", session.Status))
+	}
+	if statusErr != nil {
+		log.Error().Err(statusErr).Msg("Error updating upload session in database")
+		return c.JSON(400, "Error updating upload session in database")
+	}
+
+	// parts left behind when this fails are freed when the upload expires
+	h.freeUpload(c.Request().Context(), session)
+	return c.JSON(200, session)
+}
+
+// a function that expires uploads nobody touched for maxAge every interval
+// until the context is cancelled
+func (h *HandlerClient) StartUploadPurger(ctx context.Context, maxAge time.Duration, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		h.PurgeExpiredUploads(ctx, maxAge)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// a function to expire every upload nobody touched for maxAge, whatever its
+// status. Its parts and assembled object are freed before the session is
+// deleted, so an expiry that fails is retried on the next run.
+func (h *HandlerClient) PurgeExpiredUploads(ctx context.Context, maxAge time.Duration) {
+	before := types.NowSource().Add(-maxAge)
+	sessions, err := h.DBClient.GetExpiredUploadSessions(before)
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting expired uploads from database")
+		return
+	}
+	purged := 0
+	for i := range sessions {
+		if ctx.Err() != nil {
+			return
+		}
+		session := &sessions[i]
+		if expireErr := h.DBClient.ExpireUploadSession(session, before); expireErr != nil {
+			if !errors.Is(expireErr, db.ErrUploadStatusChanged) {
+				log.Error().Err(expireErr).Msg("Error expiring upload in database")
+			}
+			continue
+		}
+		if !h.freeUpload(ctx, session) {
+			continue
+		}
+		if deleteErr := h.DBClient.DeleteUploadSession(session); deleteErr != nil {
+			log.Error().Err(deleteErr).Msg("Error deleting upload from database")
+			continue
+		}
+		purged++
+	}
+	if purged > 0 {
+		log.Info().Int("uploads", purged).Msg("Purged expired uploads")
+	}
+}
+
+// freeUpload aborts the multipart upload of a session and deletes its
+// assembled object, an upload that is already gone is fine. It reports
+// whether storage holds nothing of the session any more.
+func (h *HandlerClient) freeUpload(ctx context.Context, session *models.UploadSession) bool {
+	// sessions from before uploads had a key of their own were assembled at
+	// the path of the file, the object there may belong to a file now
+	key := session.StorageKey
+	if key == "" {
+		key = session.Path
+	}
+	abortErr := h.S3Client.AbortMultipartUpload(ctx, key, session.StorageUploadID)
+	if abortErr != nil && !errors.Is(abortErr, storage.ErrInvalidUploadID) {
+		log.Error().Err(abortErr).Msg("Error aborting multipart upload")
+		return false
+	}
+	if session.StorageKey == "" {
+		return true
+	}
+	return len(h.deleteObjects(ctx, []string{session.StorageKey})) == 0
+}
+
+// file names become the last segment of a storage key
+func validFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\\x00")
+}
+
+// restoreHash continues a sha256 from a saved state, an empty state starts a
+// new one
+func restoreHash(state []byte) (hash.Hash, error) {
+	h := sha256.New()
+	if len(state) == 0 {
+		return h, nil
+	}
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
@@ -0,0 +1,239 @@
+package routes_test
+
+import (
+	"bytes"
+	"cascloud/models"
+	"cascloud/storage"
+	"cascloud/testsupport"
+	"cascloud/types"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// createUpload starts a resumable upload of size bytes called name in a folder
+func createUpload(t *testing.T, s *testsupport.Server, token string, folderID uuid.UUID, name string, size int64) models.UploadSession {
+	t.Helper()
+	rec := s.Do(http.MethodPost, "/uploads", token, models.CreateUploadRequest{FolderID: folderID.String(), Name: name, Size: size})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("creating the upload gave %d: %s", rec.Code, rec.Body.String())
+	}
+	var created struct {
+		Upload models.UploadSession `json:"upload"`
+	}
+	testsupport.Decode(t, rec, &created)
+	return created.Upload
+}
+
+// appendChunk sends chunk at offset to an upload
+func appendChunk(s *testsupport.Server, token string, id uuid.UUID, offset int64, chunk []byte) *httptest.ResponseRecorder {
+	req := s.Request(http.MethodPatch, "/uploads/"+id.String(), token, bytes.NewReader(chunk))
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	return s.Serve(req)
+}
+
+func TestResumableUpload(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	home := s.HomeFolder(alice)
+	docs := createFolder(t, s, aliceToken, home.ID, "docs")
+
+	first := bytes.Repeat([]byte("a"), storage.MinPartSize)
+	last := []byte("tail")
+	upload := createUpload(t, s, aliceToken, docs.ID, "big.bin", int64(len(first)+len(last)))
+
+	if rec := appendChunk(s, aliceToken, upload.ID, 0, last); rec.Code != http.StatusBadRequest {
+		t.Errorf("a small chunk that is not the last gave %d, want 400", rec.Code)
+	}
+	if rec := appendChunk(s, aliceToken, upload.ID, 0, first); rec.Code != http.StatusOK {
+		t.Fatalf("the first chunk gave %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := appendChunk(s, aliceToken, upload.ID, 0, first); rec.Code != http.StatusConflict {
+		t.Errorf("a chunk at an old offset gave %d, want 409", rec.Code)
+	}
+	if rec := s.Do(http.MethodPost, "/uploads/"+upload.ID.String()+"/complete", aliceToken, nil); rec.Code != http.StatusConflict {
+		t.Errorf("completing too early gave %d, want 409", rec.Code)
+	}
+	if rec := appendChunk(s, aliceToken, upload.ID, int64(len(first)), last); rec.Code != http.StatusOK {
+		t.Fatalf("the last chunk gave %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// the folder is renamed and gets a subfolder with the name of the upload,
+	// the upload still lands in it without touching the subfolder
+	rec := s.Do(http.MethodPatch, "/folders/"+docs.ID.String(), aliceToken, models.EditFolderRequest{Name: "papers"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("renaming gave %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = s.Do(http.MethodPost, "/uploads/"+upload.ID.String()+"/complete", aliceToken, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("completing gave %d: %s", rec.Code, rec.Body.String())
+	}
+	var file models.File
+	testsupport.Decode(t, rec, &file)
+	stored, err := s.DB.GetFileByID(file.ID.String())
+	if err != nil || stored.Path != "home@alice/papers/big.bin" {
+		t.Fatalf("the upload became %+v (%v)", stored, err)
+	}
+	content, ok := s.Storage.Object(stored.StorageKey)
+	if !ok || !bytes.Equal(content, append(first, last...)) {
+		t.Errorf("storage holds %d bytes for the file", len(content))
+	}
+	if keys := s.Storage.Keys(); len(keys) != 1 {
+		t.Errorf("storage holds %v after completing", keys)
+	}
+	if rec := s.Do(http.MethodPost, "/uploads/"+upload.ID.String()+"/complete", aliceToken, nil); rec.Code != http.StatusConflict {
+		t.Errorf("completing twice gave %d, want 409", rec.Code)
+	}
+}
+
+func TestUploadChunkReservation(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	home := s.HomeFolder(alice)
+	upload := createUpload(t, s, aliceToken, home.ID, "notes.txt", 5)
+
+	// another request is sending the chunk at offset 0
+	session, err := s.DB.GetUploadSessionByID(upload.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	partNumber, err := s.DB.ReserveUploadPart(session, 0, "other", now.Add(time.Hour), now)
+	if err != nil || partNumber != 1 {
+		t.Fatalf("reserving gave part %d (%v)", partNumber, err)
+	}
+	if rec := appendChunk(s, aliceToken, upload.ID, 0, []byte("hello")); rec.Code != http.StatusConflict {
+		t.Errorf("a chunk at a reserved offset gave %d, want 409", rec.Code)
+	}
+	if rec := s.Do(http.MethodPost, "/uploads/"+upload.ID.String()+"/complete", aliceToken, nil); rec.Code != http.StatusConflict {
+		t.Errorf("completing while a chunk is sent gave %d, want 409", rec.Code)
+	}
+
+	// once the other request gives up the chunk gets a part number of its own
+	if err := s.DB.ReleaseUploadPart(session, "other"); err != nil {
+		t.Fatal(err)
+	}
+	if rec := appendChunk(s, aliceToken, upload.ID, 0, []byte("hello")); rec.Code != http.StatusOK {
+		t.Fatalf("the chunk gave %d: %s", rec.Code, rec.Body.String())
+	}
+	parts, err := s.DB.GetUploadParts(upload.ID.String())
+	if err != nil || len(parts) != 1 || parts[0].PartNumber != 2 {
+		t.Errorf("the upload has parts %+v (%v), want part 2 only", parts, err)
+	}
+	if rec := s.Do(http.MethodPost, "/uploads/"+upload.ID.String()+"/complete", aliceToken, nil); rec.Code != http.StatusOK {
+		t.Errorf("completing gave %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestUploadPartLimits(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	home := s.HomeFolder(alice)
+
+	rec := s.Do(http.MethodPost, "/uploads", aliceToken, models.CreateUploadRequest{FolderID: home.ID.String(), Name: "huge.bin", Size: storage.MaxParts*storage.MaxPartSize + 1})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("an upload larger than S3 allows gave %d, want 400", rec.Code)
+	}
+
+	upload := createUpload(t, s, aliceToken, home.ID, "huge.bin", storage.MaxParts*storage.MaxPartSize)
+	req := s.Request(http.MethodPatch, "/uploads/"+upload.ID.String(), aliceToken, bytes.NewReader([]byte("x")))
+	req.Header.Set("Upload-Offset", "0")
+	req.ContentLength = storage.MaxPartSize + 1
+	if rec := s.Serve(req); rec.Code != http.StatusBadRequest {
+		t.Errorf("a chunk larger than a part may be gave %d, want 400", rec.Code)
+	}
+	if parts, _ := s.DB.GetUploadParts(upload.ID.String()); len(parts) != 0 {
+		t.Errorf("the refused chunk left parts %+v", parts)
+	}
+}
+
+func TestRetryUploadCompletion(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	home := s.HomeFolder(alice)
+	upload := createUpload(t, s, aliceToken, home.ID, "notes.txt", 5)
+	if rec := appendChunk(s, aliceToken, upload.ID, 0, []byte("hello")); rec.Code != http.StatusOK {
+		t.Fatalf("the chunk gave %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// a completion died after the parts were assembled
+	session, err := s.DB.GetUploadSessionByID(upload.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DB.ClaimUploadCompletion(session, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	parts, err := s.DB.GetUploadParts(upload.ID.String())
+	if err != nil || len(parts) != 1 {
+		t.Fatalf("the upload has parts %+v (%v)", parts, err)
+	}
+	completed := []storage.CompletedPart{{PartNumber: parts[0].PartNumber, ETag: parts[0].ETag}}
+	if err := s.Storage.CompleteMultipartUpload(context.Background(), session.StorageKey, session.StorageUploadID, completed); err != nil {
+		t.Fatal(err)
+	}
+
+	complete := func() *httptest.ResponseRecorder {
+		return s.Do(http.MethodPost, "/uploads/"+upload.ID.String()+"/complete", aliceToken, nil)
+	}
+	if rec := complete(); rec.Code != http.StatusConflict {
+		t.Fatalf("completing while another completion runs gave %d, want 409", rec.Code)
+	}
+
+	// once that completion has run for too long a retry takes it over
+	now := types.NowSource
+	t.Cleanup(func() { types.NowSource = now })
+	types.NowSource = func() time.Time { return now().Add(time.Hour) }
+	rec := complete()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("retrying gave %d: %s", rec.Code, rec.Body.String())
+	}
+	var file models.File
+	testsupport.Decode(t, rec, &file)
+	stored, err := s.DB.GetFileByID(file.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := s.Storage.Object(stored.StorageKey); string(content) != "hello" {
+		t.Errorf("the retried upload holds %q", content)
+	}
+	if keys := s.Storage.Keys(); len(keys) != 1 {
+		t.Errorf("storage holds %v after the retry", keys)
+	}
+}
+
+func TestPurgeExpiredUploads(t *testing.T) {
+	s := testsupport.NewServer(t)
+	alice, aliceToken := s.Register("alice")
+	home := s.HomeFolder(alice)
+	abandoned := createUpload(t, s, aliceToken, home.ID, "a.txt", 5)
+	finished := createUpload(t, s, aliceToken, home.ID, "b.txt", 5)
+	appendChunk(s, aliceToken, finished.ID, 0, []byte("hello"))
+	if rec := s.Do(http.MethodPost, "/uploads/"+finished.ID.String()+"/complete", aliceToken, nil); rec.Code != http.StatusOK {
+		t.Fatalf("completing gave %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// nothing is old enough yet
+	s.Handler.PurgeExpiredUploads(context.Background(), time.Hour)
+	if rec := s.Do(http.MethodGet, "/uploads/"+abandoned.ID.String(), aliceToken, nil); rec.Code != http.StatusOK {
+		t.Errorf("a fresh upload gave %d after the purge, want 200", rec.Code)
+	}
+
+	s.Handler.PurgeExpiredUploads(context.Background(), -time.Hour)
+	for _, id := range []uuid.UUID{abandoned.ID, finished.ID} {
+		if rec := s.Do(http.MethodGet, "/uploads/"+id.String(), aliceToken, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("upload %s gave %d after the purge, want 404", id, rec.Code)
+		}
+	}
+	if open := s.Storage.OpenUploads(); open != 0 {
+		t.Errorf("%d multipart uploads are left open", open)
+	}
+	if keys := s.Storage.Keys(); len(keys) != 1 {
+		t.Errorf("storage holds %v, want only the completed file", keys)
+	}
+}
@@ -0,0 +1,216 @@
+package routes
+
+import (
+	"cascloud/db"
+	"cascloud/events"
+	"cascloud/models"
+
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// every version of a file is stored under this prefix, the keys do not depend
+// on the folder so renaming or moving a file never has to touch them
+const versionsPrefix = ".versions"
+
+// versionKey is where a version is stored, the random part keeps two uploads
+// that start from the same version from writing to or deleting the same key
+func versionKey(fileID uuid.UUID, version int) string {
+	return fmt.Sprintf("%s/%s/%d-%s", versionsPrefix, fileID, version, uuid.NewString())
+}
+
+// contentWriter stores new content at key and returns its size and sha256
+type contentWriter func(ctx context.Context, key string) (int64, string, error)
+
+// commitFile stores content under a name in a folder. When the folder already
+// has a file with that name the content becomes its next version, otherwise a
+// new file is created at x, y. When a subfolder has the name nothing is
+// stored and db.ErrNameTaken is returned.
+func (h *HandlerClient) commitFile(ctx context.Context, folder *models.Folder, name string, x float64, y float64, author uuid.UUID, write contentWriter) (*models.File, error) {
+	existing, err := h.DBClient.GetFileByName(folder.ID.String(), name)
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting file from database")
+		return nil, err
+	}
+	if existing != nil {
+		version := models.FileVersion{AuthorID: author}
+		if err := h.addVersion(ctx, existing, &version, write); err != nil {
+			return nil, err
+		}
+		return existing, nil
+	}
+	taken, takenErr := h.folderTakesName(folder, name)
+	if takenErr != nil {
+		log.Error().Err(takenErr).Msg("Error getting folder contents from database")
+		return nil, takenErr
+	}
+	if taken {
+		return nil, db.ErrNameTaken
+	}
+
+	file := models.File{
+		ID:       uuid.New(),
+		Name:     name,
+		FolderID: folder.ID,
+		Path:     fmt.Sprintf("%s/%s", folder.Path, name),
+		X:        x,
+		Y:        y,
+	}
+	file.StorageKey = versionKey(file.ID, 1)
+	size, checksum, writeErr := write(ctx, file.StorageKey)
+	if writeErr != nil {
+		log.Error().Err(writeErr).Msg("Error uploading file to s3")
+		return nil, writeErr
+	}
+	file.Size = size
+	version := models.FileVersion{Size: size, Checksum: checksum, AuthorID: author}
+	createErr := h.DBClient.CreateFileWithVersion(&file, &version)
+	if createErr != nil {
+		log.Error().Err(createErr).Msg("Error creating file in database")
+		h.deleteObjects(context.WithoutCancel(ctx), []string{file.StorageKey})
+		return nil, createErr
+	}
+	return &file, nil
+}
+
+// folderTakesName reports whether a folder directly inside folder has the name,
+// files and folders share one namespace so no file can be stored under it
+func (h *HandlerClient) folderTakesName(folder *models.Folder, name string) (bool, error) {
+	subfolders, _, err := h.DBClient.GetFoldersAndFilesInFolder(folder.ID.String())
+	if err != nil {
+		return false, err
+	}
+	for _, subfolder := range subfolders {
+		if subfolder.Name == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// addVersion writes new content under a key of its own and makes it the
+// current version of a file. The content of the other versions is never
+// touched, when saving fails only the new content is deleted again.
+func (h *HandlerClient) addVersion(ctx context.Context, file *models.File, version *models.FileVersion, write contentWriter) error {
+	key := versionKey(file.ID, file.Version+1)
+	size, checksum, writeErr := write(ctx, key)
+	if writeErr != nil {
+		log.Error().Err(writeErr).Msg("Error uploading file to s3")
+		return writeErr
+	}
+	version.StorageKey = key
+	version.Size = size
+	version.Checksum = checksum
+
+	addErr := h.DBClient.AddFileVersion(file, version)
+	if addErr != nil {
+		log.Error().Err(addErr).Msg("Error adding file version in database")
+		h.deleteObjects(context.WithoutCancel(ctx), []string{key})
+		return addErr
+	}
+	return nil
+}
+
+// a function to list the versions of a file, newest first
+func (h *HandlerClient) GetFileVersions(c echo.Context) error {
+	file, err := h.DBClient.GetFileByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting file from database")
+		return c.JSON(404, "File not found")
+	}
+	if err := h.authorizeFile(c, file, models.RoleViewer); err != nil {
+		return err
+	}
+	versions, versionsErr := h.DBClient.GetFileVersions(file.ID.String())
+	if versionsErr != nil {
+		log.Error().Err(versionsErr).Msg("Error getting file versions from database")
+		return c.JSON(400, "Error getting file versions from database")
+	}
+	// files uploaded before versioning only have their current content
+	if len(versions) == 0 {
+		versions = []models.FileVersion{{
+			FileID:    file.ID,
+			Version:   file.Version,
+			Size:      file.Size,
+			CreatedAt: file.CreatedAt,
+		}}
+	}
+
+	return c.JSON(200, map[string]interface{}{
+		"current":  file.Version,
+		"versions": versions,
+	})
+}
+
+// a function to download a version of a file
+func (h *HandlerClient) DownloadFileVersion(c echo.Context) error {
+	file, err := h.DBClient.GetFileByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting file from database")
+		return c.JSON(404, "File not found")
+	}
+	if err := h.authorizeFile(c, file, models.RoleViewer); err != nil {
+		return err
+	}
+	number, numberErr := strconv.Atoi(c.Param("version"))
+	if numberErr != nil {
+		return c.JSON(400, "Invalid version")
+	}
+	if number == file.Version {
+		return h.streamObject(c, file.StorageKey, file.Name)
+	}
+	version, versionErr := h.DBClient.GetFileVersion(file.ID.String(), number)
+	if versionErr != nil || version.StorageKey == "" {
+		log.Error().Err(versionErr).Msg("Error getting file version from database")
+		return c.JSON(404, "Version not found")
+	}
+	return h.streamObject(c, version.StorageKey, file.Name)
+}
+
+// a function to make an older version the current one, the promoted content is
+// added as a new version so the history is never rewritten
+func (h *HandlerClient) PromoteFileVersion(c echo.Context) error {
+	file, err := h.DBClient.GetFileByID(c.Param("id"))
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting file from database")
+		return c.JSON(404, "File not found")
+	}
+	if err := h.authorizeFile(c, file, models.RoleEditor); err != nil {
+		return err
+	}
+	number, numberErr := strconv.Atoi(c.Param("version"))
+	if numberErr != nil {
+		return c.JSON(400, "Invalid version")
+	}
+	if number == file.Version {
+		return c.JSON(200, file)
+	}
+	old, versionErr := h.DBClient.GetFileVersion(file.ID.String(), number)
+	if versionErr != nil || old.StorageKey == "" {
+		log.Error().Err(versionErr).Msg("Error getting file version from database")
+		return c.JSON(404, "Version not found")
+	}
+
+	version := models.FileVersion{AuthorID: callerID(c)}
+	promoteErr := h.addVersion(c.Request().Context(), file, &version, func(ctx context.Context, key string) (int64, string, error) {
+		return old.Size, old.Checksum, h.S3Client.CopyFile(ctx, old.StorageKey, key)
+	})
+	if errors.Is(promoteErr, db.ErrFileChanged) {
+		return c.JSON(http.StatusConflict, "The file was changed by someone else, try again")
+	}
+	if promoteErr != nil {
+		return c.JSON(400, "Error promoting file version")
+	}
+	if folder, folderErr := h.DBClient.GetFolderByID(file.FolderID.String()); folderErr == nil {
+		h.publish(c, events.FileUpdated, folder.WorkspaceID, folder.ID, file)
+	}
+
+	return c.JSON(200, file)
+}
@@ -2,10 +2,16 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/rs/zerolog/log"
 )
 
@@ -46,15 +52,233 @@ func (s *S3Client) GetFiles(ctx context.Context, folderName string) ([]string, e
 	return files, nil
 }
 
-// a function to Download a file from s3
-func (s *S3Client) DownloadFile(ctx context.Context, filePath string) (io.ReadCloser, error) {
-	resp, getErr := s.Client.GetObject(ctx, &s3.GetObjectInput{
+// a function to get the metadata of a file in s3 without downloading it
+func (s *S3Client) StatFile(ctx context.Context, filePath string) (*ObjectInfo, error) {
+	resp, headErr := s.Client.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: &s.BucketName,
 		Key:    aws.String(filePath),
 	})
+	if headErr != nil {
+		log.Error().Err(headErr).Msg("Error getting file metadata")
+		return nil, headErr
+	}
+	return &ObjectInfo{
+		Size:         resp.ContentLength,
+		ContentType:  aws.ToString(resp.ContentType),
+		ETag:         aws.ToString(resp.ETag),
+		LastModified: aws.ToTime(resp.LastModified),
+	}, nil
+}
+
+// a function to Download a file from s3, byteRange is optional
+func (s *S3Client) DownloadFile(ctx context.Context, filePath string, byteRange *ByteRange) (io.ReadCloser, *ObjectInfo, error) {
+	input := &s3.GetObjectInput{
+		Bucket: &s.BucketName,
+		Key:    aws.String(filePath),
+	}
+	if byteRange != nil {
+		input.Range = aws.String(byteRange.String())
+	}
+	resp, getErr := s.Client.GetObject(ctx, input)
 	if getErr != nil {
 		log.Error().Err(getErr).Msg("Error getting file")
-		return nil, getErr
+		return nil, nil, getErr
+	}
+
+	info := &ObjectInfo{
+		Size:         resp.ContentLength,
+		ContentType:  aws.ToString(resp.ContentType),
+		ETag:         aws.ToString(resp.ETag),
+		LastModified: aws.ToTime(resp.LastModified),
+	}
+	// for ranged requests the total size is only in the Content-Range header, "bytes 0-99/1234"
+	if contentRange := aws.ToString(resp.ContentRange); contentRange != "" {
+		if slash := strings.LastIndex(contentRange, "/"); slash >= 0 {
+			if total, err := strconv.ParseInt(contentRange[slash+1:], 10, 64); err == nil {
+				info.Size = total
+			}
+		}
+	}
+	return resp.Body, info, nil
+}
+
+// a function to start a multipart upload, it returns the upload id
+func (s *S3Client) CreateMultipartUpload(ctx context.Context, fileName string) (string, error) {
+	resp, createErr := s.Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: &s.BucketName,
+		Key:    aws.String(fileName),
+	})
+	if createErr != nil {
+		log.Error().Err(createErr).Msg("Error creating multipart upload")
+		return "", createErr
+	}
+	return aws.ToString(resp.UploadId), nil
+}
+
+// a function to upload one part of a multipart upload, it returns the part etag
+func (s *S3Client) UploadPart(ctx context.Context, fileName string, uploadID string, partNumber int32, data io.Reader, size int64) (string, error) {
+	resp, partErr := s.Client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        &s.BucketName,
+		Key:           aws.String(fileName),
+		UploadId:      aws.String(uploadID),
+		PartNumber:    partNumber,
+		Body:          data,
+		ContentLength: size,
+	})
+	if partErr != nil {
+		log.Error().Err(partErr).Msg("Error uploading part")
+		return "", partErr
+	}
+	return aws.ToString(resp.ETag), nil
+}
+
+// a function to assemble the uploaded parts into the final object
+func (s *S3Client) CompleteMultipartUpload(ctx context.Context, fileName string, uploadID string, parts []CompletedPart) error {
+	completed := make([]types.CompletedPart, 0, len(parts))
+	for _, part := range parts {
+		completed = append(completed, types.CompletedPart{
+			PartNumber: part.PartNumber,
+			ETag:       aws.String(part.ETag),
+		})
+	}
+	_, completeErr := s.Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          &s.BucketName,
+		Key:             aws.String(fileName),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if completeErr != nil {
+		log.Error().Err(completeErr).Msg("Error completing multipart upload")
+		return completeErr
+	}
+	return nil
+}
+
+// a function to abort a multipart upload and free its parts
+func (s *S3Client) AbortMultipartUpload(ctx context.Context, fileName string, uploadID string) error {
+	_, abortErr := s.Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   &s.BucketName,
+		Key:      aws.String(fileName),
+		UploadId: aws.String(uploadID),
+	})
+	var noSuchUpload *types.NoSuchUpload
+	if errors.As(abortErr, &noSuchUpload) {
+		return fmt.Errorf("%w: %s", ErrInvalidUploadID, abortErr)
+	}
+	if abortErr != nil {
+		log.Error().Err(abortErr).Msg("Error aborting multipart upload")
+		return abortErr
+	}
+	return nil
+}
+
+// a function to delete a file from s3, deleting a missing file is not an error
+func (s *S3Client) DeleteFile(ctx context.Context, filePath string) error {
+	_, deleteErr := s.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &s.BucketName,
+		Key:    aws.String(filePath),
+	})
+	if deleteErr != nil {
+		log.Error().Err(deleteErr).Msg("Error deleting file")
+		return deleteErr
+	}
+	return nil
+}
+
+// a single CopyObject can copy at most 5 GiB, larger objects are copied in
+// parts of copyPartSize with UploadPartCopy
+const (
+	maxCopyObjectSize = 5 * 1024 * 1024 * 1024
+	copyPartSize      = 512 * 1024 * 1024
+)
+
+// a function to copy a file inside the bucket without downloading it
+func (s *S3Client) CopyFile(ctx context.Context, srcPath string, dstPath string) error {
+	info, statErr := s.StatFile(ctx, srcPath)
+	if statErr != nil {
+		return statErr
+	}
+	if info.Size > maxCopyObjectSize {
+		return s.copyInParts(ctx, srcPath, dstPath, info)
+	}
+	_, copyErr := s.Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     &s.BucketName,
+		Key:        aws.String(dstPath),
+		CopySource: aws.String(s.copySource(srcPath)),
+	})
+	if copyErr != nil {
+		log.Error().Err(copyErr).Msg("Error copying file")
+		return copyErr
+	}
+	return nil
+}
+
+// copyInParts copies an object that is too large for CopyObject as a multipart
+// upload whose parts are ranges of the source, it is aborted if a part fails
+func (s *S3Client) copyInParts(ctx context.Context, srcPath string, dstPath string, info *ObjectInfo) error {
+	create := &s3.CreateMultipartUploadInput{
+		Bucket: &s.BucketName,
+		Key:    aws.String(dstPath),
+	}
+	if info.ContentType != "" {
+		create.ContentType = aws.String(info.ContentType)
+	}
+	resp, createErr := s.Client.CreateMultipartUpload(ctx, create)
+	if createErr != nil {
+		log.Error().Err(createErr).Msg("Error creating multipart copy")
+		return createErr
+	}
+	uploadID := aws.ToString(resp.UploadId)
+
+	var parts []CompletedPart
+	for start := int64(0); start < info.Size; start += copyPartSize {
+		end := start + copyPartSize - 1
+		if end >= info.Size {
+			end = info.Size - 1
+		}
+		partNumber := int32(len(parts) + 1)
+		partResp, partErr := s.Client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:          &s.BucketName,
+			Key:             aws.String(dstPath),
+			UploadId:        aws.String(uploadID),
+			PartNumber:      partNumber,
+			CopySource:      aws.String(s.copySource(srcPath)),
+			CopySourceRange: aws.String("bytes=" + strconv.FormatInt(start, 10) + "-" + strconv.FormatInt(end, 10)),
+		})
+		if partErr != nil {
+			log.Error().Err(partErr).Int32("part", partNumber).Msg("Error copying part")
+			s.AbortMultipartUpload(context.WithoutCancel(ctx), dstPath, uploadID)
+			return partErr
+		}
+		var etag string
+		if partResp.CopyPartResult != nil {
+			etag = aws.ToString(partResp.CopyPartResult.ETag)
+		}
+		parts = append(parts, CompletedPart{PartNumber: partNumber, ETag: etag})
+	}
+	if err := s.CompleteMultipartUpload(ctx, dstPath, uploadID, parts); err != nil {
+		s.AbortMultipartUpload(context.WithoutCancel(ctx), dstPath, uploadID)
+		return err
+	}
+	return nil
+}
+
+// copySource is the "bucket/key" a copy reads from, url encoded segment by segment
+func (s *S3Client) copySource(srcPath string) string {
+	segments := strings.Split(s.BucketName+"/"+srcPath, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// a function to move a file, s3 has no rename so this is a copy and a delete
+func (s *S3Client) MoveFile(ctx context.Context, srcPath string, dstPath string) error {
+	if srcPath == dstPath {
+		return nil
+	}
+	if err := s.CopyFile(ctx, srcPath, dstPath); err != nil {
+		return err
 	}
-	return resp.Body, nil
+	return s.DeleteFile(ctx, srcPath)
 }
@@ -0,0 +1,438 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// temporary files are written next to their destination and renamed into place,
+// they are never returned by GetFiles
+const tempFilePrefix = ".upload-"
+
+// parts of unfinished multipart uploads live in this directory under the root
+const multipartDir = ".multipart"
+
+var (
+	ErrInvalidKey      = errors.New("invalid storage key")
+	ErrInvalidUploadID = errors.New("invalid multipart upload id")
+	ErrInvalidPart     = errors.New("invalid multipart upload part")
+)
+
+// LocalClient stores objects on the local disk using the same keys as S3,
+// a key like "home@bob/docs/report.pdf" is stored at <RootDir>/home@bob/docs/report.pdf
+type LocalClient struct {
+	RootDir string
+}
+
+// a function to create a local client, the root directory is created if it does not exist
+func NewLocalClient(rootDir string) (*LocalClient, error) {
+	absRoot, err := filepath.Abs(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(absRoot, 0o755); err != nil {
+		log.Error().Err(err).Msg("Error creating local storage directory")
+		return nil, err
+	}
+	return &LocalClient{RootDir: absRoot}, nil
+}
+
+// a function to upload a file to the local disk
+func (l *LocalClient) UploadFile(ctx context.Context, fileName string, data io.Reader) error {
+	dest, err := l.keyPath(fileName)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		log.Error().Err(err).Msg("Error creating directory")
+		return err
+	}
+	if err := writeFileAtomic(ctx, dest, data); err != nil {
+		log.Error().Err(err).Msg("Error uploading file")
+		return err
+	}
+	return nil
+}
+
+// a function to get all files whose key starts with the given prefix
+func (l *LocalClient) GetFiles(ctx context.Context, folderName string) ([]string, error) {
+	var files []string
+	// only walk the directory the prefix points into
+	walkRoot := l.RootDir
+	if dir := folderName[:strings.LastIndex(folderName, "/")+1]; dir != "" {
+		dirPath, err := l.keyPath(strings.TrimSuffix(dir, "/"))
+		if err != nil {
+			return nil, err
+		}
+		walkRoot = dirPath
+	}
+
+	walkErr := filepath.WalkDir(walkRoot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if d.IsDir() && p == filepath.Join(l.RootDir, multipartDir) {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() || strings.HasPrefix(d.Name(), tempFilePrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(l.RootDir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, folderName) {
+			files = append(files, key)
+		}
+		return nil
+	})
+	if walkErr != nil {
+		log.Error().Err(walkErr).Msg("Error listing objects")
+		return nil, walkErr
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// a function to get the metadata of a file on the local disk
+func (l *LocalClient) StatFile(ctx context.Context, filePath string) (*ObjectInfo, error) {
+	p, err := l.keyPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	stat, statErr := os.Stat(p)
+	if statErr != nil {
+		log.Error().Err(statErr).Msg("Error getting file metadata")
+		return nil, statErr
+	}
+	if !stat.Mode().IsRegular() {
+		return nil, fmt.Errorf("%w: %q is not a file", ErrInvalidKey, filePath)
+	}
+	return objectInfo(stat), nil
+}
+
+// a function to download a file from the local disk, byteRange is optional
+func (l *LocalClient) DownloadFile(ctx context.Context, filePath string, byteRange *ByteRange) (io.ReadCloser, *ObjectInfo, error) {
+	p, err := l.keyPath(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, openErr := os.Open(p)
+	if openErr != nil {
+		log.Error().Err(openErr).Msg("Error getting file")
+		return nil, nil, openErr
+	}
+	stat, statErr := f.Stat()
+	if statErr != nil {
+		f.Close()
+		return nil, nil, statErr
+	}
+	info := objectInfo(stat)
+	if byteRange == nil {
+		return f, info, nil
+	}
+
+	end := byteRange.End
+	if end < 0 || end >= info.Size {
+		end = info.Size - 1
+	}
+	if byteRange.Start < 0 || byteRange.Start > end {
+		f.Close()
+		return nil, nil, fmt.Errorf("range %s not satisfiable for %d bytes", byteRange, info.Size)
+	}
+	section := io.NewSectionReader(f, byteRange.Start, end-byteRange.Start+1)
+	return &sectionReadCloser{SectionReader: section, closer: f}, info, nil
+}
+
+// a function to start a multipart upload, it returns the upload id
+func (l *LocalClient) CreateMultipartUpload(ctx context.Context, fileName string) (string, error) {
+	if _, err := l.keyPath(fileName); err != nil {
+		return "", err
+	}
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	uploadID := hex.EncodeToString(idBytes)
+	if err := os.MkdirAll(filepath.Join(l.RootDir, multipartDir, uploadID), 0o755); err != nil {
+		log.Error().Err(err).Msg("Error creating multipart upload")
+		return "", err
+	}
+	return uploadID, nil
+}
+
+// a function to upload one part of a multipart upload, it returns the part etag
+func (l *LocalClient) UploadPart(ctx context.Context, fileName string, uploadID string, partNumber int32, data io.Reader, size int64) (string, error) {
+	uploadDir, err := l.uploadDir(uploadID)
+	if err != nil {
+		return "", err
+	}
+	if partNumber < 1 {
+		return "", fmt.Errorf("%w: part number %d", ErrInvalidPart, partNumber)
+	}
+	hash := md5.New()
+	counter := &countingReader{r: io.TeeReader(data, hash)}
+	partPath := filepath.Join(uploadDir, strconv.Itoa(int(partNumber)))
+	if err := writeFileAtomic(ctx, partPath, counter); err != nil {
+		log.Error().Err(err).Msg("Error uploading part")
+		return "", err
+	}
+	if counter.n != size {
+		os.Remove(partPath)
+		return "", fmt.Errorf("%w: expected %d bytes, got %d", ErrInvalidPart, size, counter.n)
+	}
+	etag := hex.EncodeToString(hash.Sum(nil))
+	if err := os.WriteFile(partPath+".etag", []byte(etag), 0o644); err != nil {
+		return "", err
+	}
+	return etag, nil
+}
+
+// a function to assemble the uploaded parts into the final object
+func (l *LocalClient) CompleteMultipartUpload(ctx context.Context, fileName string, uploadID string, parts []CompletedPart) error {
+	uploadDir, err := l.uploadDir(uploadID)
+	if err != nil {
+		return err
+	}
+	dest, err := l.keyPath(fileName)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("%w: no parts to complete", ErrInvalidPart)
+	}
+
+	readers := make([]io.Reader, 0, len(parts))
+	for i, part := range parts {
+		if i > 0 && part.PartNumber <= parts[i-1].PartNumber {
+			return fmt.Errorf("%w: parts must be in ascending order", ErrInvalidPart)
+		}
+		partPath := filepath.Join(uploadDir, strconv.Itoa(int(part.PartNumber)))
+		etag, err := os.ReadFile(partPath + ".etag")
+		if err != nil || string(etag) != part.ETag {
+			return fmt.Errorf("%w: part %d does not match", ErrInvalidPart, part.PartNumber)
+		}
+		f, err := os.Open(partPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(ctx, dest, io.MultiReader(readers...)); err != nil {
+		log.Error().Err(err).Msg("Error completing multipart upload")
+		return err
+	}
+	return os.RemoveAll(uploadDir)
+}
+
+// a function to abort a multipart upload and free its parts
+func (l *LocalClient) AbortMultipartUpload(ctx context.Context, fileName string, uploadID string) error {
+	uploadDir, err := l.uploadDir(uploadID)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(uploadDir); err != nil {
+		log.Error().Err(err).Msg("Error aborting multipart upload")
+		return err
+	}
+	return nil
+}
+
+// a function to delete a file from the local disk, deleting a missing file is not an error
+func (l *LocalClient) DeleteFile(ctx context.Context, filePath string) error {
+	p, err := l.keyPath(filePath)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Error().Err(err).Msg("Error deleting file")
+		return err
+	}
+	return nil
+}
+
+// a function to copy a file on the local disk
+func (l *LocalClient) CopyFile(ctx context.Context, srcPath string, dstPath string) error {
+	src, err := l.keyPath(srcPath)
+	if err != nil {
+		return err
+	}
+	dest, err := l.keyPath(dstPath)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		log.Error().Err(err).Msg("Error copying file")
+		return err
+	}
+	defer f.Close()
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(ctx, dest, f); err != nil {
+		log.Error().Err(err).Msg("Error copying file")
+		return err
+	}
+	return nil
+}
+
+// a function to move a file on the local disk, a rename is atomic
+func (l *LocalClient) MoveFile(ctx context.Context, srcPath string, dstPath string) error {
+	src, err := l.keyPath(srcPath)
+	if err != nil {
+		return err
+	}
+	dest, err := l.keyPath(dstPath)
+	if err != nil {
+		return err
+	}
+	if src == dest {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dest); err != nil {
+		log.Error().Err(err).Msg("Error moving file")
+		return err
+	}
+	return nil
+}
+
+// uploadDir returns the directory holding the parts of an existing upload
+func (l *LocalClient) uploadDir(uploadID string) (string, error) {
+	if _, err := hex.DecodeString(uploadID); err != nil || uploadID == "" {
+		return "", fmt.Errorf("%w: %q", ErrInvalidUploadID, uploadID)
+	}
+	dir := filepath.Join(l.RootDir, multipartDir, uploadID)
+	if _, err := os.Stat(dir); err != nil {
+		return "", fmt.Errorf("%w: %q", ErrInvalidUploadID, uploadID)
+	}
+	return dir, nil
+}
+
+// keyPath maps an object key to a path inside the root directory. Keys must be
+// relative, slash separated and may not contain empty, "." or ".." segments so
+// that a key can never escape the root or alias another key.
+func (l *LocalClient) keyPath(key string) (string, error) {
+	if key == "" || strings.ContainsRune(key, 0) || strings.Contains(key, `\`) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	segments := strings.Split(key, "/")
+	if segments[0] == multipartDir {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	for _, segment := range segments {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+		}
+	}
+	p := filepath.Join(l.RootDir, filepath.FromSlash(key))
+	rel, err := filepath.Rel(l.RootDir, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	return p, nil
+}
+
+// writeFileAtomic writes data to a temporary file in the destination directory
+// and renames it over dest once it is fully flushed, so readers never observe a
+// partially written object
+func writeFileAtomic(ctx context.Context, dest string, data io.Reader) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(dest), tempFilePrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = io.Copy(tmp, &contextReader{ctx: ctx, r: data}); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+// contextReader stops a copy as soon as the context is cancelled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// countingReader keeps track of how many bytes were read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// objectInfo builds the metadata of a file on disk, the ETag is derived from the
+// modification time and size since files are only ever replaced, not edited
+func objectInfo(stat fs.FileInfo) *ObjectInfo {
+	return &ObjectInfo{
+		Size:         stat.Size(),
+		ContentType:  mime.TypeByExtension(filepath.Ext(stat.Name())),
+		ETag:         fmt.Sprintf(`"%x-%x"`, stat.ModTime().UnixNano(), stat.Size()),
+		LastModified: stat.ModTime(),
+	}
+}
+
+type sectionReadCloser struct {
+	*io.SectionReader
+	closer io.Closer
+}
+
+func (s *sectionReadCloser) Close() error {
+	return s.closer.Close()
+}
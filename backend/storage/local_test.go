@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newLocal(t *testing.T) *LocalClient {
+	t.Helper()
+	client, err := NewLocalClient(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func readAll(t *testing.T, body io.ReadCloser) string {
+	t.Helper()
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestLocalObjects(t *testing.T) {
+	ctx := context.Background()
+	l := newLocal(t)
+	if err := l.UploadFile(ctx, "home@bob/docs/notes.txt", strings.NewReader("hello world")); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.UploadFile(ctx, "home@bob/other.txt", strings.NewReader("other")); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := l.StatFile(ctx, "home@bob/docs/notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 11 || info.ContentType != "text/plain; charset=utf-8" || info.ETag == "" {
+		t.Errorf("stat gave %+v", info)
+	}
+	body, downloaded, err := l.DownloadFile(ctx, "home@bob/docs/notes.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content := readAll(t, body); content != "hello world" || downloaded.ETag != info.ETag {
+		t.Errorf("download gave %q with etag %s, want etag %s", content, downloaded.ETag, info.ETag)
+	}
+	body, downloaded, err = l.DownloadFile(ctx, "home@bob/docs/notes.txt", &ByteRange{Start: 6, End: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a range reports the size of the whole object
+	if content := readAll(t, body); content != "world" || downloaded.Size != 11 {
+		t.Errorf("a range gave %q of %d bytes", content, downloaded.Size)
+	}
+	if _, _, err := l.DownloadFile(ctx, "home@bob/docs/notes.txt", &ByteRange{Start: 11, End: 20}); err == nil {
+		t.Error("a range past the end was served")
+	}
+
+	if err := l.CopyFile(ctx, "home@bob/docs/notes.txt", "home@bob/copy/notes.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.MoveFile(ctx, "home@bob/other.txt", "home@bob/moved/other.txt"); err != nil {
+		t.Fatal(err)
+	}
+	files, err := l.GetFiles(ctx, "home@bob/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"home@bob/copy/notes.txt", "home@bob/docs/notes.txt", "home@bob/moved/other.txt"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("listed %v, want %v", files, want)
+	}
+	if files, err := l.GetFiles(ctx, "home@bob/do"); err != nil || !reflect.DeepEqual(files, want[1:2]) {
+		t.Errorf("listing a partial prefix gave %v (%v)", files, err)
+	}
+
+	if err := l.DeleteFile(ctx, "home@bob/docs/notes.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.DeleteFile(ctx, "home@bob/docs/notes.txt"); err != nil {
+		t.Errorf("deleting a missing file gave %v", err)
+	}
+	if _, err := l.StatFile(ctx, "home@bob/docs/notes.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("stat of a deleted file gave %v", err)
+	}
+}
+
+func TestLocalKeysStayInRoot(t *testing.T) {
+	ctx := context.Background()
+	l := newLocal(t)
+	for _, key := range []string{"", "/etc/passwd", "../outside", "a/../../outside", "a/./b", "a//b", "a/", `a\b`, "a\x00b", ".multipart/x"} {
+		if err := l.UploadFile(ctx, key, strings.NewReader("x")); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("uploading to %q gave %v, want ErrInvalidKey", key, err)
+		}
+		if _, err := l.StatFile(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("stat of %q gave %v, want ErrInvalidKey", key, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(l.RootDir), "outside")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("a file was written outside the root: %v", err)
+	}
+	// directories are not objects
+	if err := l.UploadFile(ctx, "dir/file", strings.NewReader("x")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.StatFile(ctx, "dir"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("stat of a directory gave %v, want ErrInvalidKey", err)
+	}
+}
+
+func TestLocalMultipartUpload(t *testing.T) {
+	ctx := context.Background()
+	l := newLocal(t)
+	uploadID, err := l.CreateMultipartUpload(ctx, "big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parts []CompletedPart
+	for i, chunk := range []string{"hello ", "world"} {
+		number := int32(i + 1)
+		etag, err := l.UploadPart(ctx, "big.bin", uploadID, number, strings.NewReader(chunk), int64(len(chunk)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, CompletedPart{PartNumber: number, ETag: etag})
+	}
+	if _, err := l.UploadPart(ctx, "big.bin", uploadID, 3, strings.NewReader("short"), 10); !errors.Is(err, ErrInvalidPart) {
+		t.Errorf("a part of the wrong size gave %v, want ErrInvalidPart", err)
+	}
+	// parts in the list must be in order and match what was uploaded
+	reversed := []CompletedPart{parts[1], parts[0]}
+	if err := l.CompleteMultipartUpload(ctx, "big.bin", uploadID, reversed); !errors.Is(err, ErrInvalidPart) {
+		t.Errorf("completing parts out of order gave %v, want ErrInvalidPart", err)
+	}
+	wrong := []CompletedPart{parts[0], {PartNumber: 2, ETag: "wrong"}}
+	if err := l.CompleteMultipartUpload(ctx, "big.bin", uploadID, wrong); !errors.Is(err, ErrInvalidPart) {
+		t.Errorf("completing with a wrong etag gave %v, want ErrInvalidPart", err)
+	}
+
+	if err := l.CompleteMultipartUpload(ctx, "big.bin", uploadID, parts); err != nil {
+		t.Fatal(err)
+	}
+	body, _, err := l.DownloadFile(ctx, "big.bin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content := readAll(t, body); content != "hello world" {
+		t.Errorf("the upload holds %q", content)
+	}
+	// the parts are gone with the upload
+	if err := l.AbortMultipartUpload(ctx, "big.bin", uploadID); !errors.Is(err, ErrInvalidUploadID) {
+		t.Errorf("aborting a completed upload gave %v, want ErrInvalidUploadID", err)
+	}
+	for _, id := range []string{"", "not-hex", "../../etc"} {
+		if _, err := l.UploadPart(ctx, "big.bin", id, 1, strings.NewReader("x"), 1); !errors.Is(err, ErrInvalidUploadID) {
+			t.Errorf("a part for upload %q gave %v, want ErrInvalidUploadID", id, err)
+		}
+	}
+	if files, err := l.GetFiles(ctx, ""); err != nil || !reflect.DeepEqual(files, []string{"big.bin"}) {
+		t.Errorf("listed %v (%v), want only big.bin", files, err)
+	}
+}
+
+func TestLocalCancelledUpload(t *testing.T) {
+	l := newLocal(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.UploadFile(ctx, "notes.txt", strings.NewReader("hello")); !errors.Is(err, context.Canceled) {
+		t.Errorf("a cancelled upload gave %v, want context.Canceled", err)
+	}
+	entries, err := os.ReadDir(l.RootDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("a cancelled upload left %d files behind", len(entries))
+	}
+}
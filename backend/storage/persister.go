@@ -2,11 +2,57 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"time"
 )
 
+// S3 rejects multipart parts smaller than this, except for the last one
+const MinPartSize = 5 * 1024 * 1024
+
+// S3 rejects parts larger than this and uploads with more parts than MaxParts
+const MaxPartSize = 5 * 1024 * 1024 * 1024
+const MaxParts = 10000
+
+type CompletedPart struct {
+	PartNumber int32  `json:"part_number"`
+	ETag       string `json:"etag"`
+}
+
+// ByteRange is an inclusive range of bytes inside an object, an End below zero
+// means the range runs to the end of the object
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+// the value of the Range header S3 expects for this range
+func (r ByteRange) String() string {
+	if r.End < 0 {
+		return fmt.Sprintf("bytes=%d-", r.Start)
+	}
+	return fmt.Sprintf("bytes=%d-%d", r.Start, r.End)
+}
+
+// ObjectInfo describes a stored object, Size is always the size of the whole
+// object even when only a range of it was downloaded
+type ObjectInfo struct {
+	Size         int64
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+}
+
 type S3Interface interface {
 	UploadFile(ctx context.Context, fileName string, data io.Reader) error
 	GetFiles(ctx context.Context, folderName string) ([]string, error)
-	DownloadFile(ctx context.Context, filePath string) (io.ReadCloser, error)
+	StatFile(ctx context.Context, filePath string) (*ObjectInfo, error)
+	DownloadFile(ctx context.Context, filePath string, byteRange *ByteRange) (io.ReadCloser, *ObjectInfo, error)
+	CreateMultipartUpload(ctx context.Context, fileName string) (string, error)
+	UploadPart(ctx context.Context, fileName string, uploadID string, partNumber int32, data io.Reader, size int64) (string, error)
+	CompleteMultipartUpload(ctx context.Context, fileName string, uploadID string, parts []CompletedPart) error
+	AbortMultipartUpload(ctx context.Context, fileName string, uploadID string) error
+	DeleteFile(ctx context.Context, filePath string) error
+	CopyFile(ctx context.Context, srcPath string, dstPath string) error
+	MoveFile(ctx context.Context, srcPath string, dstPath string) error
 }
@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"cascloud/config"
+
+	s3cfg "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/rs/zerolog/log"
+)
+
+// a function to build the storage backend selected by the config
+func Storage(ctx context.Context, cfg *config.Config) (S3Interface, error) {
+	switch cfg.StorageDriver {
+	case config.StorageDriverLocal:
+		log.Info().Str("path", cfg.LocalStoragePath).Msg("Using local file storage")
+		return NewLocalClient(cfg.LocalStoragePath)
+	case config.StorageDriverS3, "":
+		s3config, err := s3cfg.LoadDefaultConfig(ctx, s3cfg.WithRegion(cfg.S3Region))
+		if err != nil {
+			log.Error().Err(err).Msg("Error loading S3 config")
+			return nil, err
+		}
+		return &S3Client{
+			Client:     s3.NewFromConfig(s3config),
+			BucketName: cfg.S3BucketName,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.StorageDriver)
+	}
+}
@@ -0,0 +1,209 @@
+// Package testsupport has in-memory stand-ins for the database and storage
+// and a server that wires them into the real routes, for tests that go
+// through HTTP like a client would.
+//
+// The database is not a fake of db.DBInterface but the real DBClient on an
+// in-memory SQLite database with the SQLite migrations applied, so the tests
+// also cover the queries, transactions and foreign keys. The SQLite driver is
+// pure Go, the tests run with CGO_ENABLED=0 and need nothing installed.
+package testsupport
+
+import (
+	"bytes"
+	"cascloud/config"
+	"cascloud/db"
+	"cascloud/events"
+	"cascloud/helpers"
+	"cascloud/models"
+	"cascloud/routes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// a function to create a migrated and seeded database that lives in memory
+// for the rest of the test
+func NewDB(t testing.TB) *db.DBClient {
+	t.Helper()
+	// every test gets its own database, shared by the connections of its pool
+	path := fmt.Sprintf("file:%s?mode=memory&cache=shared", uuid.NewString())
+	gormDB, err := db.DB(&config.Config{DBDriver: config.DBDriverSQLite, DBPath: path})
+	if err != nil {
+		t.Fatalf("creating test database: %v", err)
+	}
+	sqlDB, err := gormDB.DB()
+	if err != nil {
+		t.Fatalf("creating test database: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return db.NewClient(gormDB)
+}
+
+// Server is the API running on an in-memory database and storage
+type Server struct {
+	t       testing.TB
+	Echo    *echo.Echo
+	Handler *routes.HandlerClient
+	DB      *db.DBClient
+	Storage *Storage
+}
+
+// the secret the tokens of test servers are signed with
+const testSecret = "a secret that is only used by the tests"
+
+// a function to create a server with every route of the API
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	if err := helpers.SetSecret(testSecret); err != nil {
+		t.Fatalf("setting the JWT secret: %v", err)
+	}
+	s := &Server{
+		t:       t,
+		Echo:    echo.New(),
+		DB:      NewDB(t),
+		Storage: NewStorage(),
+	}
+	s.Handler = &routes.HandlerClient{
+		DBClient: s.DB,
+		S3Client: s.Storage,
+		Events:   events.NewHub(),
+	}
+	s.Handler.RegisterRoutes(s.Echo)
+	return s
+}
+
+// a function to send a request, body is sent as JSON unless it is nil, a
+// reader or a Form. token can be empty for routes that need no login.
+func (s *Server) Do(method string, path string, token string, body interface{}) *httptest.ResponseRecorder {
+	s.t.Helper()
+	return s.Serve(s.Request(method, path, token, body))
+}
+
+// a function to build the request Do sends, for tests that need to change it
+func (s *Server) Request(method string, path string, token string, body interface{}) *http.Request {
+	s.t.Helper()
+	var reader io.Reader
+	contentType := echo.MIMEApplicationJSON
+	switch value := body.(type) {
+	case nil:
+	case io.Reader:
+		reader = value
+		contentType = echo.MIMEOctetStream
+	case Form:
+		var err error
+		reader, contentType, err = value.encode()
+		if err != nil {
+			s.t.Fatalf("encoding form: %v", err)
+		}
+	default:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			s.t.Fatalf("encoding request body: %v", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if reader != nil {
+		req.Header.Set(echo.HeaderContentType, contentType)
+	}
+	if token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	}
+	return req
+}
+
+// a function to send a request to the API and record the response
+func (s *Server) Serve(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.Echo.ServeHTTP(rec, req)
+	return rec
+}
+
+// a function to register a user through the API and log them in, it returns
+// the user with their workspace and a token for later requests
+func (s *Server) Register(name string) (models.User, string) {
+	s.t.Helper()
+	rec := s.Do(http.MethodPost, "/users", "", map[string]string{
+		"first_name": name,
+		"last_name":  name,
+		"email":      name + "@example.com",
+		"username":   name,
+		"password":   "password-" + name,
+	})
+	if rec.Code != http.StatusOK {
+		s.t.Fatalf("registering %s: %d %s", name, rec.Code, rec.Body.String())
+	}
+	var user models.User
+	Decode(s.t, rec, &user)
+	token, err := helpers.GenerateJWT(user)
+	if err != nil {
+		s.t.Fatalf("creating token for %s: %v", name, err)
+	}
+	return user, token
+}
+
+// a function to get the home folder of a user's own workspace
+func (s *Server) HomeFolder(user models.User) models.Folder {
+	s.t.Helper()
+	if len(user.Workspaces) == 0 {
+		s.t.Fatalf("%s has no workspace", user.UserName)
+	}
+	workspace, err := s.DB.GetWorkspaceByID(user.Workspaces[0].String())
+	if err != nil {
+		s.t.Fatalf("getting workspace of %s: %v", user.UserName, err)
+	}
+	folder, err := s.DB.GetFolderByID(workspace.HomeFolderID.String())
+	if err != nil {
+		s.t.Fatalf("getting home folder of %s: %v", user.UserName, err)
+	}
+	return *folder
+}
+
+// Form is a multipart form, Files maps a field to the name and content of a file
+type Form struct {
+	Fields map[string]string
+	Files  map[string]FormFile
+}
+
+type FormFile struct {
+	Name    string
+	Content []byte
+}
+
+func (f Form) encode() (io.Reader, string, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	for field, value := range f.Fields {
+		if err := writer.WriteField(field, value); err != nil {
+			return nil, "", err
+		}
+	}
+	for field, file := range f.Files {
+		part, err := writer.CreateFormFile(field, file.Name)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := part.Write(file.Content); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+	return &buf, writer.FormDataContentType(), nil
+}
+
+// a function to decode a JSON response, the test fails when it does not decode
+func Decode(t testing.TB, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding response %q: %v", rec.Body.String(), err)
+	}
+}
@@ -0,0 +1,254 @@
+package testsupport
+
+import (
+	"bytes"
+	"cascloud/storage"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Storage keeps objects in memory and behaves like the S3 and local clients,
+// it is safe to use from several goroutines
+type Storage struct {
+	// BeforeDownload is called with the key of every download before it is
+	// read, tests set it to change the object in between
+	BeforeDownload func(key string)
+
+	mu      sync.Mutex
+	objects map[string]object
+	uploads map[string]*multipartUpload
+}
+
+type object struct {
+	data     []byte
+	etag     string
+	modified time.Time
+}
+
+type multipartUpload struct {
+	key   string
+	parts map[int32]object
+}
+
+var _ storage.S3Interface = (*Storage)(nil)
+
+// a function to create an empty in-memory storage
+func NewStorage() *Storage {
+	return &Storage{
+		objects: make(map[string]object),
+		uploads: make(map[string]*multipartUpload),
+	}
+}
+
+// a function to get the content of an object, ok is false when it does not exist
+func (s *Storage) Object(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[key]
+	return bytes.Clone(obj.data), ok
+}
+
+// a function to get the number of multipart uploads that were neither
+// completed nor aborted
+func (s *Storage) OpenUploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.uploads)
+}
+
+// a function to get the keys of every stored object in order
+func (s *Storage) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Storage) UploadFile(ctx context.Context, fileName string, data io.Reader) error {
+	content, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[fileName] = newObject(content)
+	return nil
+}
+
+func (s *Storage) GetFiles(ctx context.Context, folderName string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var files []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, folderName) {
+			files = append(files, key)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (s *Storage) StatFile(ctx context.Context, filePath string) (*storage.ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[filePath]
+	if !ok {
+		return nil, notFound(filePath)
+	}
+	return obj.info(filePath), nil
+}
+
+func (s *Storage) DownloadFile(ctx context.Context, filePath string, byteRange *storage.ByteRange) (io.ReadCloser, *storage.ObjectInfo, error) {
+	if s.BeforeDownload != nil {
+		s.BeforeDownload(filePath)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[filePath]
+	if !ok {
+		return nil, nil, notFound(filePath)
+	}
+	info := obj.info(filePath)
+	data := obj.data
+	if byteRange != nil {
+		end := byteRange.End
+		if end < 0 || end >= info.Size {
+			end = info.Size - 1
+		}
+		if byteRange.Start < 0 || byteRange.Start > end {
+			return nil, nil, fmt.Errorf("range %s not satisfiable for %d bytes", byteRange, info.Size)
+		}
+		data = data[byteRange.Start : end+1]
+	}
+	return io.NopCloser(bytes.NewReader(data)), info, nil
+}
+
+func (s *Storage) CreateMultipartUpload(ctx context.Context, fileName string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uploadID := uuid.NewString()
+	s.uploads[uploadID] = &multipartUpload{key: fileName, parts: make(map[int32]object)}
+	return uploadID, nil
+}
+
+func (s *Storage) UploadPart(ctx context.Context, fileName string, uploadID string, partNumber int32, data io.Reader, size int64) (string, error) {
+	content, err := io.ReadAll(data)
+	if err != nil {
+		return "", err
+	}
+	if partNumber < 1 {
+		return "", fmt.Errorf("%w: part number %d", storage.ErrInvalidPart, partNumber)
+	}
+	if int64(len(content)) != size {
+		return "", fmt.Errorf("%w: expected %d bytes, got %d", storage.ErrInvalidPart, size, len(content))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.uploads[uploadID]
+	if !ok {
+		return "", storage.ErrInvalidUploadID
+	}
+	part := newObject(content)
+	upload.parts[partNumber] = part
+	return part.etag, nil
+}
+
+func (s *Storage) CompleteMultipartUpload(ctx context.Context, fileName string, uploadID string, parts []storage.CompletedPart) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.uploads[uploadID]
+	if !ok {
+		return storage.ErrInvalidUploadID
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("%w: no parts to complete", storage.ErrInvalidPart)
+	}
+	var content []byte
+	for i, part := range parts {
+		if i > 0 && part.PartNumber <= parts[i-1].PartNumber {
+			return fmt.Errorf("%w: parts must be in ascending order", storage.ErrInvalidPart)
+		}
+		stored, ok := upload.parts[part.PartNumber]
+		if !ok || stored.etag != part.ETag {
+			return fmt.Errorf("%w: part %d does not match", storage.ErrInvalidPart, part.PartNumber)
+		}
+		content = append(content, stored.data...)
+	}
+	s.objects[fileName] = newObject(content)
+	delete(s.uploads, uploadID)
+	return nil
+}
+
+func (s *Storage) AbortMultipartUpload(ctx context.Context, fileName string, uploadID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.uploads[uploadID]; !ok {
+		return storage.ErrInvalidUploadID
+	}
+	delete(s.uploads, uploadID)
+	return nil
+}
+
+func (s *Storage) DeleteFile(ctx context.Context, filePath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, filePath)
+	return nil
+}
+
+func (s *Storage) CopyFile(ctx context.Context, srcPath string, dstPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[srcPath]
+	if !ok {
+		return notFound(srcPath)
+	}
+	s.objects[dstPath] = newObject(bytes.Clone(obj.data))
+	return nil
+}
+
+func (s *Storage) MoveFile(ctx context.Context, srcPath string, dstPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[srcPath]
+	if !ok {
+		return notFound(srcPath)
+	}
+	delete(s.objects, srcPath)
+	s.objects[dstPath] = obj
+	return nil
+}
+
+func newObject(data []byte) object {
+	sum := md5.Sum(data)
+	return object{data: data, etag: `"` + hex.EncodeToString(sum[:]) + `"`, modified: time.Now().UTC()}
+}
+
+func (o object) info(key string) *storage.ObjectInfo {
+	return &storage.ObjectInfo{
+		Size:         int64(len(o.data)),
+		ContentType:  mime.TypeByExtension(path.Ext(key)),
+		ETag:         o.etag,
+		LastModified: o.modified,
+	}
+}
+
+func notFound(key string) error {
+	return fmt.Errorf("%w: %s", fs.ErrNotExist, key)
+}